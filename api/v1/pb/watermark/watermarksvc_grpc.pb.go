@@ -23,6 +23,21 @@ type WatermarkClient interface {
 	Status(ctx context.Context, in *StatusRequest, opts ...grpc.CallOption) (*StatusReply, error)
 	CreateDocument(ctx context.Context, in *CreateDocumentRequest, opts ...grpc.CallOption) (*CreateDocumentReply, error)
 	ServiceStatus(ctx context.Context, in *ServiceStatusRequest, opts ...grpc.CallOption) (*ServiceStatusReply, error)
+	// Extract recovers the ticket ID and mark hidden in a copy of a
+	// watermarked document.
+	Extract(ctx context.Context, in *ExtractRequest, opts ...grpc.CallOption) (*ExtractReply, error)
+	// WatchStatus sends the current status of the ticket followed by every
+	// transition, and ends once the ticket is Finished or Failed.
+	WatchStatus(ctx context.Context, in *StatusRequest, opts ...grpc.CallOption) (Watermark_WatchStatusClient, error)
+	// FindStream sends the matching documents one by one. The paging fields
+	// of the request are ignored.
+	FindStream(ctx context.Context, in *FindRequest, opts ...grpc.CallOption) (Watermark_FindStreamClient, error)
+	// UploadDocument creates a document from a stream of messages: the
+	// metadata first, then the content in chunks.
+	UploadDocument(ctx context.Context, opts ...grpc.CallOption) (Watermark_UploadDocumentClient, error)
+	// DownloadDocument sends the original or watermarked content in chunks.
+	// Only the first message carries the content type.
+	DownloadDocument(ctx context.Context, in *DownloadDocumentRequest, opts ...grpc.CallOption) (Watermark_DownloadDocumentClient, error)
 }
 
 type watermarkClient struct {
@@ -78,6 +93,145 @@ func (c *watermarkClient) ServiceStatus(ctx context.Context, in *ServiceStatusRe
 	return out, nil
 }
 
+func (c *watermarkClient) Extract(ctx context.Context, in *ExtractRequest, opts ...grpc.CallOption) (*ExtractReply, error) {
+	out := new(ExtractReply)
+	err := c.cc.Invoke(ctx, "/pb.Watermark/Extract", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *watermarkClient) WatchStatus(ctx context.Context, in *StatusRequest, opts ...grpc.CallOption) (Watermark_WatchStatusClient, error) {
+	stream, err := c.cc.NewStream(ctx, &Watermark_ServiceDesc.Streams[0], "/pb.Watermark/WatchStatus", opts...)
+	if err != nil {
+		return nil, err
+	}
+	x := &watermarkWatchStatusClient{stream}
+	if err := x.ClientStream.SendMsg(in); err != nil {
+		return nil, err
+	}
+	if err := x.ClientStream.CloseSend(); err != nil {
+		return nil, err
+	}
+	return x, nil
+}
+
+type Watermark_WatchStatusClient interface {
+	Recv() (*StatusReply, error)
+	grpc.ClientStream
+}
+
+type watermarkWatchStatusClient struct {
+	grpc.ClientStream
+}
+
+func (x *watermarkWatchStatusClient) Recv() (*StatusReply, error) {
+	m := new(StatusReply)
+	if err := x.ClientStream.RecvMsg(m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+func (c *watermarkClient) FindStream(ctx context.Context, in *FindRequest, opts ...grpc.CallOption) (Watermark_FindStreamClient, error) {
+	stream, err := c.cc.NewStream(ctx, &Watermark_ServiceDesc.Streams[1], "/pb.Watermark/FindStream", opts...)
+	if err != nil {
+		return nil, err
+	}
+	x := &watermarkFindStreamClient{stream}
+	if err := x.ClientStream.SendMsg(in); err != nil {
+		return nil, err
+	}
+	if err := x.ClientStream.CloseSend(); err != nil {
+		return nil, err
+	}
+	return x, nil
+}
+
+type Watermark_FindStreamClient interface {
+	Recv() (*Document, error)
+	grpc.ClientStream
+}
+
+type watermarkFindStreamClient struct {
+	grpc.ClientStream
+}
+
+func (x *watermarkFindStreamClient) Recv() (*Document, error) {
+	m := new(Document)
+	if err := x.ClientStream.RecvMsg(m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+func (c *watermarkClient) UploadDocument(ctx context.Context, opts ...grpc.CallOption) (Watermark_UploadDocumentClient, error) {
+	stream, err := c.cc.NewStream(ctx, &Watermark_ServiceDesc.Streams[2], "/pb.Watermark/UploadDocument", opts...)
+	if err != nil {
+		return nil, err
+	}
+	x := &watermarkUploadDocumentClient{stream}
+	return x, nil
+}
+
+type Watermark_UploadDocumentClient interface {
+	Send(*UploadDocumentRequest) error
+	CloseAndRecv() (*CreateDocumentReply, error)
+	grpc.ClientStream
+}
+
+type watermarkUploadDocumentClient struct {
+	grpc.ClientStream
+}
+
+func (x *watermarkUploadDocumentClient) Send(m *UploadDocumentRequest) error {
+	return x.ClientStream.SendMsg(m)
+}
+
+func (x *watermarkUploadDocumentClient) CloseAndRecv() (*CreateDocumentReply, error) {
+	if err := x.ClientStream.CloseSend(); err != nil {
+		return nil, err
+	}
+	m := new(CreateDocumentReply)
+	if err := x.ClientStream.RecvMsg(m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+func (c *watermarkClient) DownloadDocument(ctx context.Context, in *DownloadDocumentRequest, opts ...grpc.CallOption) (Watermark_DownloadDocumentClient, error) {
+	stream, err := c.cc.NewStream(ctx, &Watermark_ServiceDesc.Streams[3], "/pb.Watermark/DownloadDocument", opts...)
+	if err != nil {
+		return nil, err
+	}
+	x := &watermarkDownloadDocumentClient{stream}
+	if err := x.ClientStream.SendMsg(in); err != nil {
+		return nil, err
+	}
+	if err := x.ClientStream.CloseSend(); err != nil {
+		return nil, err
+	}
+	return x, nil
+}
+
+type Watermark_DownloadDocumentClient interface {
+	Recv() (*DownloadDocumentReply, error)
+	grpc.ClientStream
+}
+
+type watermarkDownloadDocumentClient struct {
+	grpc.ClientStream
+}
+
+func (x *watermarkDownloadDocumentClient) Recv() (*DownloadDocumentReply, error) {
+	m := new(DownloadDocumentReply)
+	if err := x.ClientStream.RecvMsg(m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
 // WatermarkServer is the server API for Watermark service.
 // All implementations must embed UnimplementedWatermarkServer
 // for forward compatibility
@@ -87,6 +241,21 @@ type WatermarkServer interface {
 	Status(context.Context, *StatusRequest) (*StatusReply, error)
 	CreateDocument(context.Context, *CreateDocumentRequest) (*CreateDocumentReply, error)
 	ServiceStatus(context.Context, *ServiceStatusRequest) (*ServiceStatusReply, error)
+	// Extract recovers the ticket ID and mark hidden in a copy of a
+	// watermarked document.
+	Extract(context.Context, *ExtractRequest) (*ExtractReply, error)
+	// WatchStatus sends the current status of the ticket followed by every
+	// transition, and ends once the ticket is Finished or Failed.
+	WatchStatus(*StatusRequest, Watermark_WatchStatusServer) error
+	// FindStream sends the matching documents one by one. The paging fields
+	// of the request are ignored.
+	FindStream(*FindRequest, Watermark_FindStreamServer) error
+	// UploadDocument creates a document from a stream of messages: the
+	// metadata first, then the content in chunks.
+	UploadDocument(Watermark_UploadDocumentServer) error
+	// DownloadDocument sends the original or watermarked content in chunks.
+	// Only the first message carries the content type.
+	DownloadDocument(*DownloadDocumentRequest, Watermark_DownloadDocumentServer) error
 	mustEmbedUnimplementedWatermarkServer()
 }
 
@@ -109,6 +278,21 @@ func (UnimplementedWatermarkServer) CreateDocument(context.Context, *CreateDocum
 func (UnimplementedWatermarkServer) ServiceStatus(context.Context, *ServiceStatusRequest) (*ServiceStatusReply, error) {
 	return nil, status.Errorf(codes.Unimplemented, "method ServiceStatus not implemented")
 }
+func (UnimplementedWatermarkServer) Extract(context.Context, *ExtractRequest) (*ExtractReply, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method Extract not implemented")
+}
+func (UnimplementedWatermarkServer) WatchStatus(*StatusRequest, Watermark_WatchStatusServer) error {
+	return status.Errorf(codes.Unimplemented, "method WatchStatus not implemented")
+}
+func (UnimplementedWatermarkServer) FindStream(*FindRequest, Watermark_FindStreamServer) error {
+	return status.Errorf(codes.Unimplemented, "method FindStream not implemented")
+}
+func (UnimplementedWatermarkServer) UploadDocument(Watermark_UploadDocumentServer) error {
+	return status.Errorf(codes.Unimplemented, "method UploadDocument not implemented")
+}
+func (UnimplementedWatermarkServer) DownloadDocument(*DownloadDocumentRequest, Watermark_DownloadDocumentServer) error {
+	return status.Errorf(codes.Unimplemented, "method DownloadDocument not implemented")
+}
 func (UnimplementedWatermarkServer) mustEmbedUnimplementedWatermarkServer() {}
 
 // UnsafeWatermarkServer may be embedded to opt out of forward compatibility for this service.
@@ -212,6 +396,113 @@ func _Watermark_ServiceStatus_Handler(srv interface{}, ctx context.Context, dec
 	return interceptor(ctx, in, info, handler)
 }
 
+func _Watermark_Extract_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(ExtractRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(WatermarkServer).Extract(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/pb.Watermark/Extract",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(WatermarkServer).Extract(ctx, req.(*ExtractRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Watermark_WatchStatus_Handler(srv interface{}, stream grpc.ServerStream) error {
+	m := new(StatusRequest)
+	if err := stream.RecvMsg(m); err != nil {
+		return err
+	}
+	return srv.(WatermarkServer).WatchStatus(m, &watermarkWatchStatusServer{stream})
+}
+
+type Watermark_WatchStatusServer interface {
+	Send(*StatusReply) error
+	grpc.ServerStream
+}
+
+type watermarkWatchStatusServer struct {
+	grpc.ServerStream
+}
+
+func (x *watermarkWatchStatusServer) Send(m *StatusReply) error {
+	return x.ServerStream.SendMsg(m)
+}
+
+func _Watermark_FindStream_Handler(srv interface{}, stream grpc.ServerStream) error {
+	m := new(FindRequest)
+	if err := stream.RecvMsg(m); err != nil {
+		return err
+	}
+	return srv.(WatermarkServer).FindStream(m, &watermarkFindStreamServer{stream})
+}
+
+type Watermark_FindStreamServer interface {
+	Send(*Document) error
+	grpc.ServerStream
+}
+
+type watermarkFindStreamServer struct {
+	grpc.ServerStream
+}
+
+func (x *watermarkFindStreamServer) Send(m *Document) error {
+	return x.ServerStream.SendMsg(m)
+}
+
+func _Watermark_UploadDocument_Handler(srv interface{}, stream grpc.ServerStream) error {
+	return srv.(WatermarkServer).UploadDocument(&watermarkUploadDocumentServer{stream})
+}
+
+type Watermark_UploadDocumentServer interface {
+	SendAndClose(*CreateDocumentReply) error
+	Recv() (*UploadDocumentRequest, error)
+	grpc.ServerStream
+}
+
+type watermarkUploadDocumentServer struct {
+	grpc.ServerStream
+}
+
+func (x *watermarkUploadDocumentServer) SendAndClose(m *CreateDocumentReply) error {
+	return x.ServerStream.SendMsg(m)
+}
+
+func (x *watermarkUploadDocumentServer) Recv() (*UploadDocumentRequest, error) {
+	m := new(UploadDocumentRequest)
+	if err := x.ServerStream.RecvMsg(m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+func _Watermark_DownloadDocument_Handler(srv interface{}, stream grpc.ServerStream) error {
+	m := new(DownloadDocumentRequest)
+	if err := stream.RecvMsg(m); err != nil {
+		return err
+	}
+	return srv.(WatermarkServer).DownloadDocument(m, &watermarkDownloadDocumentServer{stream})
+}
+
+type Watermark_DownloadDocumentServer interface {
+	Send(*DownloadDocumentReply) error
+	grpc.ServerStream
+}
+
+type watermarkDownloadDocumentServer struct {
+	grpc.ServerStream
+}
+
+func (x *watermarkDownloadDocumentServer) Send(m *DownloadDocumentReply) error {
+	return x.ServerStream.SendMsg(m)
+}
+
 // Watermark_ServiceDesc is the grpc.ServiceDesc for Watermark service.
 // It's only intended for direct use with grpc.RegisterService,
 // and not to be introspected or modified (even as a copy)
@@ -239,7 +530,32 @@ var Watermark_ServiceDesc = grpc.ServiceDesc{
 			MethodName: "ServiceStatus",
 			Handler:    _Watermark_ServiceStatus_Handler,
 		},
+		{
+			MethodName: "Extract",
+			Handler:    _Watermark_Extract_Handler,
+		},
+	},
+	Streams: []grpc.StreamDesc{
+		{
+			StreamName:    "WatchStatus",
+			Handler:       _Watermark_WatchStatus_Handler,
+			ServerStreams: true,
+		},
+		{
+			StreamName:    "FindStream",
+			Handler:       _Watermark_FindStream_Handler,
+			ServerStreams: true,
+		},
+		{
+			StreamName:    "UploadDocument",
+			Handler:       _Watermark_UploadDocument_Handler,
+			ClientStreams: true,
+		},
+		{
+			StreamName:    "DownloadDocument",
+			Handler:       _Watermark_DownloadDocument_Handler,
+			ServerStreams: true,
+		},
 	},
-	Streams:  []grpc.StreamDesc{},
 	Metadata: "watermarksvc.proto",
 }
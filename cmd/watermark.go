@@ -1,76 +1,329 @@
 package main
 
 import (
+	"context"
+	"crypto/tls"
+	"flag"
 	"fmt"
 	"net"
 	"net/http"
 	"os"
 	"os/signal"
+	"sync"
 	"syscall"
+	"time"
 
 	kitgrpc "github.com/go-kit/kit/transport/grpc"
-	"github.com/go-kit/log"
+	"github.com/go-kit/log/level"
 	"github.com/oklog/run"
+	"github.com/prometheus/client_golang/prometheus"
 	pb "github.com/wzzfarewell/go-microservice-example/api/v1/pb/watermark"
+	"github.com/wzzfarewell/go-microservice-example/internal/config"
 	"github.com/wzzfarewell/go-microservice-example/pkg/watermark"
+	"github.com/wzzfarewell/go-microservice-example/pkg/watermark/auth"
+	"github.com/wzzfarewell/go-microservice-example/pkg/watermark/bolt"
+	"github.com/wzzfarewell/go-microservice-example/pkg/watermark/certs"
 	"github.com/wzzfarewell/go-microservice-example/pkg/watermark/endpoint"
+	"github.com/wzzfarewell/go-microservice-example/pkg/watermark/fs"
+	"github.com/wzzfarewell/go-microservice-example/pkg/watermark/inmem"
+	"github.com/wzzfarewell/go-microservice-example/pkg/watermark/logging"
+	"github.com/wzzfarewell/go-microservice-example/pkg/watermark/metrics"
+	"github.com/wzzfarewell/go-microservice-example/pkg/watermark/policy"
+	"github.com/wzzfarewell/go-microservice-example/pkg/watermark/s3"
+	"github.com/wzzfarewell/go-microservice-example/pkg/watermark/tracing"
 	"github.com/wzzfarewell/go-microservice-example/pkg/watermark/transport"
+	"go.opentelemetry.io/otel"
 	"google.golang.org/grpc"
-)
-
-const (
-	defaultHTTPPort = "8081"
-	defaultGRPCPort = "8082"
+	"google.golang.org/grpc/credentials"
+	grpchealth "google.golang.org/grpc/health"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
 )
 
 func main() {
+	cfg, err := config.Load(os.Args[1:], os.Getenv)
+	if err == flag.ErrHelp {
+		os.Exit(0)
+	}
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(2)
+	}
+	logger, err := logging.New(os.Stderr, cfg.LogFormat, cfg.LogLevel)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(2)
+	}
+
+	var repo watermark.Repository
+	switch cfg.Storage.Backend {
+	case "inmem":
+		repo = inmem.NewRepository()
+	case "bolt":
+		boltRepo, err := bolt.NewRepository(cfg.Storage.BoltPath)
+		if err != nil {
+			level.Error(logger).Log("storage", cfg.Storage.Backend, "during", "Open", "err", err)
+			os.Exit(1)
+		}
+		defer boltRepo.Close()
+		repo = boltRepo
+	}
+
+	var blobs watermark.BlobStore
+	switch cfg.Blob.Backend {
+	case "inmem":
+		blobs = inmem.NewBlobStore()
+	case "fs":
+		fsBlobs, err := fs.NewBlobStore(cfg.Blob.Dir)
+		if err != nil {
+			level.Error(logger).Log("blobStore", cfg.Blob.Backend, "during", "Open", "err", err)
+			os.Exit(1)
+		}
+		blobs = fsBlobs
+	case "s3":
+		s3Blobs, err := s3.NewBlobStore(context.Background(), s3.Config{
+			Endpoint:  cfg.Blob.S3.Endpoint,
+			Bucket:    cfg.Blob.S3.Bucket,
+			Region:    cfg.Blob.S3.Region,
+			AccessKey: cfg.Blob.S3.AccessKey,
+			SecretKey: cfg.Blob.S3.SecretKey,
+			Secure:    !cfg.Blob.S3.Insecure,
+			Prefix:    cfg.Blob.S3.Prefix,
+		})
+		if err != nil {
+			level.Error(logger).Log("blobStore", cfg.Blob.Backend, "during", "Open", "err", err)
+			os.Exit(1)
+		}
+		blobs = s3Blobs
+	}
+
+	var certReloader *certs.Reloader
+	if cfg.TLSEnabled() {
+		certReloader, err = certs.NewReloader(certs.Config{
+			CertFile:     cfg.TLS.CertFile,
+			KeyFile:      cfg.TLS.KeyFile,
+			ClientCAFile: cfg.TLS.ClientCAFile,
+		}, logger)
+		if err != nil {
+			level.Error(logger).Log("during", "TLS", "err", err)
+			os.Exit(1)
+		}
+	}
+
+	shutdownTracing, err := tracing.Setup(context.Background(), tracing.Config{
+		Exporter:    cfg.Tracing.Exporter,
+		File:        cfg.Tracing.File,
+		Endpoint:    cfg.Tracing.Endpoint,
+		Insecure:    cfg.Tracing.Insecure,
+		SampleRatio: cfg.Tracing.SampleRatio,
+	})
+	if err != nil {
+		level.Error(logger).Log("during", "tracing", "err", err)
+		os.Exit(1)
+	}
+
+	authConfig := auth.Config{
+		HMACSecret:      cfg.Auth.HMACSecret,
+		JWKSFile:        cfg.Auth.JWKSFile,
+		APIKeysFile:     cfg.Auth.APIKeysFile,
+		ClientCertsFile: cfg.Auth.ClientCertsFile,
+		Issuer:          cfg.Auth.Issuer,
+		Audience:        cfg.Auth.Audience,
+	}
+	var authn *auth.Authenticator
+	if authConfig.Enabled() {
+		authn, err = auth.New(authConfig)
+		if err != nil {
+			level.Error(logger).Log("during", "auth", "err", err)
+			os.Exit(1)
+		}
+	} else {
+		level.Warn(logger).Log("msg", "authentication is disabled, configure JWTs or API keys to enable it")
+	}
+
 	var (
-		logger   log.Logger
-		httpAddr = net.JoinHostPort("localhost", envString("HTTP_PORT", defaultHTTPPort))
-		grpcAddr = net.JoinHostPort("localhost", envString("GRPC_PORT", defaultGRPCPort))
+		tracedRepo  = watermark.NewTracingRepository(watermark.NewScopedRepository(repo))
+		ready       = new(watermark.Readiness)
+		events      = watermark.NewHub()
+		workers     = watermark.NewWorkerPool(tracedRepo, blobs, events, watermark.DefaultMarkers(), watermark.DefaultEmbedders(), cfg.Workers.Count, cfg.Workers.QueueSize, logger)
+		service     = watermark.NewService(tracedRepo, blobs, workers, events, ready, logger)
+		streamer    = watermark.NewStreamer(tracedRepo, blobs, events)
+		idempotency = endpoint.NewIdempotencyStore(cfg.IdempotencyTTL, cfg.IdempotencyKeys)
+		grpcMetrics = metrics.NewGRPC(prometheus.DefaultRegisterer)
+		epOptions   = []endpoint.Option{
+			endpoint.WithIdempotency(idempotency),
+			endpoint.WithMetrics(metrics.NewEndpointMetrics(prometheus.DefaultRegisterer)),
+			endpoint.WithTracer(otel.Tracer(watermark.InstrumentationName)),
+			endpoint.WithLogger(logger),
+		}
+		health     = watermark.NewHealth(ready)
+		grpcHealth = grpchealth.NewServer()
 	)
+	if authn != nil {
+		epOptions = append(epOptions, endpoint.WithAuthentication(authn))
+		service = policy.NewService(service)
+		streamer = endpoint.NewAuthenticatingStreamer(policy.NewStreamer(streamer), authn)
+	}
+	var (
+		eps         = endpoint.NewEndpointSet(service, epOptions...)
+		httpHandler = transport.NewHTTPHandler(eps, streamer, health, int64(cfg.Blob.MaxUploadSize), logger)
+		grpcHander  = transport.NewGRPCServer(eps, streamer, int64(cfg.Blob.MaxUploadSize), logger)
+	)
+	metrics.RegisterQueueDepth(prometheus.DefaultRegisterer, workers)
+	if err := metrics.RegisterTickets(context.Background(), prometheus.DefaultRegisterer, repo, events); err != nil {
+		level.Error(logger).Log("during", "metrics", "err", err)
+		os.Exit(1)
+	}
+	// the jobs of the previous run are gone with its queue
+	if n, err := workers.Recover(context.Background()); err != nil {
+		level.Error(logger).Log("during", "Recover", "err", err)
+		os.Exit(1)
+	} else if n > 0 {
+		level.Warn(logger).Log("msg", "failed the tickets of interrupted jobs", "count", n)
+	}
+	for _, c := range []struct {
+		name       string
+		dependency interface{}
+	}{
+		{"repository", repo},
+		{"blob_store", blobs},
+		{"queue", workers},
+	} {
+		if checker, ok := c.dependency.(watermark.HealthChecker); ok {
+			health.Register(c.name, checker)
+		}
+	}
 
-	logger = log.NewLogfmtLogger(log.NewSyncWriter(os.Stderr))
-	logger = log.With(logger, "ts", log.DefaultTimestampUTC)
-
+	// drain begins the graceful shutdown the first time an actor is
+	// interrupted: the instance reports not ready for the shutdown delay,
+	// then the status streams end and every actor gets until the same
+	// deadline to finish its in-flight work. run.Group interrupts the
+	// actors in the order they were added, so the listeners stop taking
+	// requests before the worker pool drains.
 	var (
-		service     = watermark.NewService()
-		eps         = endpoint.NewEndpointSet(service)
-		httpHandler = transport.NewHTTPHandler(eps)
-		grpcHander  = transport.NewGRPCServer(eps)
+		drainOnce   sync.Once
+		drainCtx    context.Context
+		drainCancel context.CancelFunc = func() {}
 	)
+	defer func() { drainCancel() }()
+	drain := func() context.Context {
+		drainOnce.Do(func() {
+			level.Info(logger).Log("msg", "shutting down", "timeout", cfg.ShutdownTimeout)
+			ready.SetReady(false)
+			grpcHealth.Shutdown()
+			time.Sleep(cfg.ShutdownDelay)
+			events.Close()
+			drainCtx, drainCancel = context.WithTimeout(context.Background(), cfg.ShutdownTimeout)
+		})
+		return drainCtx
+	}
 
 	var g run.Group
 	{
 		// The HTTP listener mounts the Go kit HTTP handler we created.
-		httpListener, err := net.Listen("tcp", httpAddr)
+		httpListener, err := net.Listen("tcp", cfg.HTTP.Addr)
 		if err != nil {
-			logger.Log("transport", "HTTP", "during", "Listen", "err", err)
+			level.Error(logger).Log("transport", "HTTP", "during", "Listen", "err", err)
 			os.Exit(1)
 		}
+		// the probes and metrics are served without client certificates, the
+		// handler requires one for the rest
+		handler := httpHandler
+		if certReloader != nil {
+			httpListener = tls.NewListener(httpListener, certReloader.OptionalClientCertConfig())
+			if cfg.TLS.ClientCAFile != "" {
+				handler = transport.RequireClientCert(handler)
+			}
+		}
+		httpServer := &http.Server{
+			Handler:      handler,
+			ReadTimeout:  cfg.HTTP.ReadTimeout,
+			WriteTimeout: cfg.HTTP.WriteTimeout,
+			IdleTimeout:  cfg.HTTP.IdleTimeout,
+		}
 		g.Add(func() error {
-			logger.Log("transport", "HTTP", "addr", httpAddr)
-			return http.Serve(httpListener, httpHandler)
+			level.Info(logger).Log("transport", "HTTP", "addr", cfg.HTTP.Addr, "tls", certReloader != nil)
+			if err := httpServer.Serve(httpListener); err != http.ErrServerClosed {
+				return err
+			}
+			return nil
 		}, func(error) {
-			httpListener.Close()
+			if err := httpServer.Shutdown(drain()); err != nil {
+				level.Warn(logger).Log("transport", "HTTP", "during", "Shutdown", "err", err)
+				httpServer.Close()
+			}
 		})
 	}
 	{
 		// The gRPC listener mounts the Go kit gRPC server we created.
-		grpcListener, err := net.Listen("tcp", grpcAddr)
+		grpcListener, err := net.Listen("tcp", cfg.GRPC.Addr)
 		if err != nil {
-			logger.Log("transport", "gRPC", "during", "Listen", "err", err)
+			level.Error(logger).Log("transport", "gRPC", "during", "Listen", "err", err)
 			os.Exit(1)
 		}
+		// the Go kit gRPC Interceptor puts the method name in the request
+		// context, the trace context is read from the metadata by the transport
+		opts := []grpc.ServerOption{
+			grpc.ChainUnaryInterceptor(grpcMetrics.UnaryServerInterceptor(), kitgrpc.Interceptor),
+			grpc.StreamInterceptor(grpcMetrics.StreamServerInterceptor()),
+		}
+		if certReloader != nil {
+			opts = append(opts, grpc.Creds(credentials.NewTLS(certReloader.ServerConfig("h2"))))
+		}
+		baseServer := grpc.NewServer(opts...)
+		pb.RegisterWatermarkServer(baseServer, grpcHander)
+		healthpb.RegisterHealthServer(baseServer, grpcHealth)
 		g.Add(func() error {
-			logger.Log("transport", "gRPC", "addr", grpcAddr)
-			// we add the Go Kit gRPC Interceptor to our gRPC service as it is used by
-			// the here demonstrated zipkin tracing middleware.
-			baseServer := grpc.NewServer(grpc.UnaryInterceptor(kitgrpc.Interceptor))
-			pb.RegisterWatermarkServer(baseServer, grpcHander)
+			level.Info(logger).Log("transport", "gRPC", "addr", cfg.GRPC.Addr, "tls", certReloader != nil)
 			return baseServer.Serve(grpcListener)
 		}, func(error) {
-			grpcListener.Close()
+			ctx := drain()
+			stopped := make(chan struct{})
+			go func() {
+				baseServer.GracefulStop()
+				close(stopped)
+			}()
+			select {
+			case <-stopped:
+			case <-ctx.Done():
+				level.Warn(logger).Log("transport", "gRPC", "during", "GracefulStop", "err", ctx.Err())
+				baseServer.Stop()
+			}
+		})
+	}
+	{
+		// The gRPC health status follows the readiness checks until the
+		// shutdown marks everything as not serving.
+		ctx, cancel := context.WithCancel(context.Background())
+		g.Add(func() error {
+			transport.UpdateGRPCHealth(ctx, health, grpcHealth, cfg.HealthInterval)
+			return nil
+		}, func(error) {
+			cancel()
+		})
+	}
+	{
+		// The worker pool applies watermarks in the background and drains
+		// the queued jobs before returning.
+		g.Add(func() error {
+			return workers.Run()
+		}, func(error) {
+			if err := workers.Shutdown(drain()); err != nil {
+				level.Warn(logger).Log("workers", "Shutdown", "err", err)
+			}
+		})
+	}
+	if certReloader != nil {
+		// The certificates are reloaded on SIGHUP, or once their files
+		// change, while the listeners keep serving.
+		ctx, cancel := context.WithCancel(context.Background())
+		hup := make(chan os.Signal, 1)
+		signal.Notify(hup, syscall.SIGHUP)
+		g.Add(func() error {
+			certReloader.Watch(ctx, cfg.TLS.ReloadInterval, hup)
+			return nil
+		}, func(error) {
+			signal.Stop(hup)
+			cancel()
 		})
 	}
 	{
@@ -89,13 +342,13 @@ func main() {
 			close(cancelInterrupt)
 		})
 	}
-	logger.Log("exit", g.Run())
-}
+	ready.SetReady(true)
+	level.Info(logger).Log("exit", g.Run())
 
-func envString(env, fallback string) string {
-	e := os.Getenv(env)
-	if e == "" {
-		return fallback
+	// the spans of the drained work are flushed within the shutdown timeout too
+	ctx, cancel := context.WithTimeout(context.Background(), cfg.ShutdownTimeout)
+	defer cancel()
+	if err := shutdownTracing(ctx); err != nil {
+		level.Error(logger).Log("during", "tracing shutdown", "err", err)
 	}
-	return e
 }
@@ -0,0 +1,413 @@
+// Package config loads the configuration of the watermark service from, in
+// increasing order of precedence, the defaults, an optional YAML or TOML
+// file, environment variables and command line flags.
+package config
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/BurntSushi/toml"
+	"gopkg.in/yaml.v2"
+)
+
+// Every leaf field carries the key it is read from in each source: the yaml
+// and toml tags within its section of the file, the env tag and the flag tag.
+type Config struct {
+	HTTP struct {
+		Addr         string        `yaml:"addr" toml:"addr" env:"HTTP_ADDR" flag:"http-addr" usage:"HTTP listen address"`
+		ReadTimeout  time.Duration `yaml:"read_timeout" toml:"read_timeout" env:"HTTP_READ_TIMEOUT" flag:"http-read-timeout" usage:"maximum duration for reading a request, 0 for none"`
+		WriteTimeout time.Duration `yaml:"write_timeout" toml:"write_timeout" env:"HTTP_WRITE_TIMEOUT" flag:"http-write-timeout" usage:"maximum duration for writing a response, 0 for none"`
+		IdleTimeout  time.Duration `yaml:"idle_timeout" toml:"idle_timeout" env:"HTTP_IDLE_TIMEOUT" flag:"http-idle-timeout" usage:"how long idle keep-alive connections are kept open"`
+	} `yaml:"http" toml:"http"`
+
+	GRPC struct {
+		Addr string `yaml:"addr" toml:"addr" env:"GRPC_ADDR" flag:"grpc-addr" usage:"gRPC listen address"`
+	} `yaml:"grpc" toml:"grpc"`
+
+	Storage struct {
+		Backend  string `yaml:"backend" toml:"backend" env:"STORAGE" flag:"storage" usage:"document storage: inmem or bolt"`
+		BoltPath string `yaml:"bolt_path" toml:"bolt_path" env:"BOLT_PATH" flag:"bolt-path" usage:"path of the BoltDB file"`
+	} `yaml:"storage" toml:"storage"`
+
+	Blob struct {
+		Backend string `yaml:"backend" toml:"backend" env:"BLOB_STORE" flag:"blob-store" usage:"content storage: inmem, fs or s3, defaults to inmem with inmem storage and fs otherwise"`
+		Dir     string `yaml:"dir" toml:"dir" env:"BLOB_DIR" flag:"blob-dir" usage:"directory of the fs blob store"`
+
+		S3 struct {
+			Endpoint  string `yaml:"endpoint" toml:"endpoint" env:"S3_ENDPOINT" flag:"s3-endpoint" usage:"S3 server host[:port]"`
+			Bucket    string `yaml:"bucket" toml:"bucket" env:"S3_BUCKET" flag:"s3-bucket" usage:"S3 bucket"`
+			Region    string `yaml:"region" toml:"region" env:"S3_REGION" flag:"s3-region" usage:"S3 region"`
+			AccessKey string `yaml:"access_key" toml:"access_key" env:"S3_ACCESS_KEY" flag:"s3-access-key" usage:"S3 access key"`
+			SecretKey string `yaml:"secret_key" toml:"secret_key" env:"S3_SECRET_KEY" flag:"s3-secret-key" usage:"S3 secret key"`
+			Insecure  bool   `yaml:"insecure" toml:"insecure" env:"S3_INSECURE" flag:"s3-insecure" usage:"talk to S3 over plain HTTP"`
+			Prefix    string `yaml:"prefix" toml:"prefix" env:"S3_PREFIX" flag:"s3-prefix" usage:"prefix of the S3 object keys"`
+		} `yaml:"s3" toml:"s3"`
+
+		MaxUploadSize int `yaml:"max_upload_size" toml:"max_upload_size" env:"MAX_UPLOAD_SIZE" flag:"max-upload-size" usage:"largest document content accepted, in bytes"`
+	} `yaml:"blob" toml:"blob"`
+
+	Workers struct {
+		Count     int `yaml:"count" toml:"count" env:"WORKERS" flag:"workers" usage:"number of watermark workers"`
+		QueueSize int `yaml:"queue_size" toml:"queue_size" env:"QUEUE_SIZE" flag:"queue-size" usage:"capacity of the watermark queue"`
+	} `yaml:"workers" toml:"workers"`
+
+	HealthInterval  time.Duration `yaml:"health_interval" toml:"health_interval" env:"HEALTH_INTERVAL" flag:"health-interval" usage:"how often the gRPC health status is refreshed"`
+	IdempotencyTTL  time.Duration `yaml:"idempotency_ttl" toml:"idempotency_ttl" env:"IDEMPOTENCY_TTL" flag:"idempotency-ttl" usage:"how long idempotency keys are remembered"`
+	IdempotencyKeys int           `yaml:"idempotency_keys" toml:"idempotency_keys" env:"IDEMPOTENCY_KEYS" flag:"idempotency-keys" usage:"most idempotency keys remembered at once"`
+	ShutdownTimeout time.Duration `yaml:"shutdown_timeout" toml:"shutdown_timeout" env:"SHUTDOWN_TIMEOUT" flag:"shutdown-timeout" usage:"how long to wait for in-flight work on shutdown"`
+	ShutdownDelay   time.Duration `yaml:"shutdown_delay" toml:"shutdown_delay" env:"SHUTDOWN_DELAY" flag:"shutdown-delay" usage:"how long to keep serving while reporting not ready before draining"`
+
+	TLS struct {
+		CertFile     string `yaml:"cert_file" toml:"cert_file" env:"TLS_CERT_FILE" flag:"tls-cert" usage:"PEM certificate, enables TLS on both listeners"`
+		KeyFile      string `yaml:"key_file" toml:"key_file" env:"TLS_KEY_FILE" flag:"tls-key" usage:"PEM private key of the certificate"`
+		ClientCAFile string `yaml:"client_ca_file" toml:"client_ca_file" env:"TLS_CLIENT_CA_FILE" flag:"tls-client-ca" usage:"PEM CA bundle, requires client certificates signed by it everywhere but on the probes and metrics"`
+
+		// the files are also reloaded on SIGHUP
+		ReloadInterval time.Duration `yaml:"reload_interval" toml:"reload_interval" env:"TLS_RELOAD_INTERVAL" flag:"tls-reload-interval" usage:"how often the TLS files are checked for changes, 0 to only reload them on SIGHUP"`
+	} `yaml:"tls" toml:"tls"`
+
+	Auth struct {
+		HMACSecret      string `yaml:"hmac_secret" toml:"hmac_secret" env:"AUTH_HMAC_SECRET" flag:"auth-hmac-secret" usage:"secret HS256 JWTs are signed with"`
+		JWKSFile        string `yaml:"jwks_file" toml:"jwks_file" env:"AUTH_JWKS_FILE" flag:"auth-jwks" usage:"JWKS file with the keys RS256 JWTs are signed with"`
+		APIKeysFile     string `yaml:"api_keys_file" toml:"api_keys_file" env:"AUTH_API_KEYS_FILE" flag:"auth-api-keys" usage:"JSON file of the accepted API keys"`
+		ClientCertsFile string `yaml:"client_certs_file" toml:"client_certs_file" env:"AUTH_CLIENT_CERTS_FILE" flag:"auth-client-certs" usage:"JSON file of the roles of client certificate identities"`
+		Issuer          string `yaml:"issuer" toml:"issuer" env:"AUTH_ISSUER" flag:"auth-issuer" usage:"required iss claim of JWTs"`
+		Audience        string `yaml:"audience" toml:"audience" env:"AUTH_AUDIENCE" flag:"auth-audience" usage:"required aud claim of JWTs"`
+	} `yaml:"auth" toml:"auth"`
+
+	Tracing struct {
+		Exporter    string  `yaml:"exporter" toml:"exporter" env:"TRACING_EXPORTER" flag:"tracing-exporter" usage:"span exporter: none, stdout or otlp"`
+		File        string  `yaml:"file" toml:"file" env:"TRACING_FILE" flag:"tracing-file" usage:"file the stdout exporter appends to, standard output if empty"`
+		Endpoint    string  `yaml:"endpoint" toml:"endpoint" env:"OTLP_ENDPOINT" flag:"otlp-endpoint" usage:"OTLP/HTTP collector host:port"`
+		Insecure    bool    `yaml:"insecure" toml:"insecure" env:"OTLP_INSECURE" flag:"otlp-insecure" usage:"send spans to the collector over plain HTTP"`
+		SampleRatio float64 `yaml:"sample_ratio" toml:"sample_ratio" env:"TRACING_SAMPLE_RATIO" flag:"tracing-sample-ratio" usage:"fraction of new traces recorded, between 0 and 1"`
+	} `yaml:"tracing" toml:"tracing"`
+
+	LogLevel  string `yaml:"log_level" toml:"log_level" env:"LOG_LEVEL" flag:"log-level" usage:"debug, info, warn or error"`
+	LogFormat string `yaml:"log_format" toml:"log_format" env:"LOG_FORMAT" flag:"log-format" usage:"logfmt or json"`
+}
+
+// Default returns the configuration used for everything no source sets.
+func Default() Config {
+	var c Config
+	c.HTTP.Addr = ":8081"
+	c.HTTP.ReadTimeout = 30 * time.Second
+	c.HTTP.WriteTimeout = 0 // the event streams stay open
+	c.HTTP.IdleTimeout = 2 * time.Minute
+	c.GRPC.Addr = ":8082"
+	c.Storage.Backend = "inmem"
+	c.Storage.BoltPath = "watermark.db"
+	c.Blob.Dir = "blobs"
+	c.Blob.S3.Bucket = "watermark"
+	c.Blob.MaxUploadSize = 64 << 20
+	c.Workers.Count = 4
+	c.Workers.QueueSize = 100
+	c.HealthInterval = 10 * time.Second
+	c.IdempotencyTTL = 24 * time.Hour
+	c.IdempotencyKeys = 100000
+	c.ShutdownTimeout = 30 * time.Second
+	c.TLS.ReloadInterval = 30 * time.Second
+	c.Tracing.Exporter = "none"
+	c.Tracing.Endpoint = "localhost:4318"
+	c.Tracing.SampleRatio = 1
+	c.LogLevel = "info"
+	c.LogFormat = "logfmt"
+	return c
+}
+
+// Load builds the configuration from the command line arguments (without the
+// program name) and the environment, reading the file named by the -config
+// flag or the CONFIG_FILE variable if there is one, and validates it.
+func Load(args []string, getenv func(string) string) (*Config, error) {
+	c := Default()
+	fields := leaves(reflect.ValueOf(&c).Elem())
+
+	fs := flag.NewFlagSet("watermark", flag.ContinueOnError)
+	configFile := fs.String("config", "", "YAML (.yaml, .yml) or TOML (.toml) configuration file (env CONFIG_FILE)")
+	for _, f := range fields {
+		_, isBool := f.value.Interface().(bool)
+		fs.Var(&rawFlag{isBool: isBool}, f.flag, fmt.Sprintf("%s (env %s, default %q)", f.usage, f.env, fmt.Sprint(f.value.Interface())))
+	}
+	if err := fs.Parse(args); err != nil {
+		return nil, err
+	}
+
+	path := *configFile
+	if path == "" {
+		path = getenv("CONFIG_FILE")
+	}
+	if path != "" {
+		if err := loadFile(path, &c); err != nil {
+			return nil, err
+		}
+	}
+
+	// HTTP_PORT and GRPC_PORT predate the address settings
+	for _, port := range []struct{ env, addr string }{{"HTTP_PORT", "HTTP_ADDR"}, {"GRPC_PORT", "GRPC_ADDR"}} {
+		if p := getenv(port.env); p != "" && getenv(port.addr) == "" {
+			fields.byEnv(port.addr).value.SetString(":" + p)
+		}
+	}
+	for _, f := range fields {
+		if v := getenv(f.env); v != "" {
+			if err := set(f.value, v); err != nil {
+				return nil, fmt.Errorf("config: %s: %w", f.env, err)
+			}
+		}
+	}
+	var err error
+	fs.Visit(func(fl *flag.Flag) {
+		if f := fields.byFlag(fl.Name); f != nil && err == nil {
+			if serr := set(f.value, fl.Value.String()); serr != nil {
+				err = fmt.Errorf("config: -%s: %w", fl.Name, serr)
+			}
+		}
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	if c.Blob.Backend == "" {
+		c.Blob.Backend = "fs"
+		if c.Storage.Backend == "inmem" {
+			c.Blob.Backend = "inmem"
+		}
+	}
+	if err := c.Validate(); err != nil {
+		return nil, err
+	}
+	return &c, nil
+}
+
+// Validate reports every invalid setting at once.
+func (c *Config) Validate() error {
+	var errs []string
+	check := func(ok bool, format string, args ...interface{}) {
+		if !ok {
+			errs = append(errs, fmt.Sprintf(format, args...))
+		}
+	}
+
+	for _, addr := range []struct{ name, value string }{{"http.addr", c.HTTP.Addr}, {"grpc.addr", c.GRPC.Addr}} {
+		_, port, err := net.SplitHostPort(addr.value)
+		check(err == nil && port != "", "%s: %q is not a host:port address", addr.name, addr.value)
+	}
+	check(c.HTTP.Addr != c.GRPC.Addr, "http.addr and grpc.addr are both %q", c.HTTP.Addr)
+	check(c.HTTP.ReadTimeout >= 0, "http.read_timeout: must not be negative")
+	check(c.HTTP.WriteTimeout >= 0, "http.write_timeout: must not be negative")
+	check(c.HTTP.IdleTimeout >= 0, "http.idle_timeout: must not be negative")
+
+	switch c.Storage.Backend {
+	case "inmem":
+	case "bolt":
+		check(c.Storage.BoltPath != "", "storage.bolt_path: required by the bolt backend")
+	default:
+		check(false, "storage.backend: %q is not one of inmem, bolt", c.Storage.Backend)
+	}
+	switch c.Blob.Backend {
+	case "inmem":
+	case "fs":
+		check(c.Blob.Dir != "", "blob.dir: required by the fs backend")
+	case "s3":
+		check(c.Blob.S3.Endpoint != "", "blob.s3.endpoint: required by the s3 backend")
+		check(c.Blob.S3.Bucket != "", "blob.s3.bucket: required by the s3 backend")
+	default:
+		check(false, "blob.backend: %q is not one of inmem, fs, s3", c.Blob.Backend)
+	}
+	check(c.Blob.MaxUploadSize > 0, "blob.max_upload_size: must be positive")
+
+	check(c.Workers.Count >= 1, "workers.count: must be at least 1, got %d", c.Workers.Count)
+	check(c.Workers.QueueSize >= 1, "workers.queue_size: must be at least 1, got %d", c.Workers.QueueSize)
+	check(c.HealthInterval > 0, "health_interval: must be positive")
+	check(c.IdempotencyTTL > 0, "idempotency_ttl: must be positive")
+	check(c.IdempotencyKeys >= 1, "idempotency_keys: must be at least 1, got %d", c.IdempotencyKeys)
+	check(c.ShutdownTimeout > 0, "shutdown_timeout: must be positive")
+	check(c.ShutdownDelay >= 0, "shutdown_delay: must not be negative")
+
+	check((c.TLS.CertFile == "") == (c.TLS.KeyFile == ""), "tls: cert_file and key_file must be set together")
+	check(c.TLS.ClientCAFile == "" || c.TLS.CertFile != "", "tls.client_ca_file: requires cert_file and key_file")
+	check(c.TLS.ReloadInterval >= 0, "tls.reload_interval: must not be negative")
+	for _, file := range []struct{ name, path string }{
+		{"tls.cert_file", c.TLS.CertFile},
+		{"tls.key_file", c.TLS.KeyFile},
+		{"tls.client_ca_file", c.TLS.ClientCAFile},
+		{"auth.jwks_file", c.Auth.JWKSFile},
+		{"auth.api_keys_file", c.Auth.APIKeysFile},
+		{"auth.client_certs_file", c.Auth.ClientCertsFile},
+	} {
+		if file.path != "" {
+			_, err := os.Stat(file.path)
+			check(err == nil, "%s: %v", file.name, err)
+		}
+	}
+
+	check(c.Auth.ClientCertsFile == "" || c.TLS.ClientCAFile != "", "auth.client_certs_file: requires tls.client_ca_file")
+	check(c.Auth.HMACSecret == "" || len(c.Auth.HMACSecret) >= 32, "auth.hmac_secret: must be at least 32 bytes long")
+	jwt := c.Auth.HMACSecret != "" || c.Auth.JWKSFile != ""
+	check(jwt || (c.Auth.Issuer == "" && c.Auth.Audience == ""), "auth.issuer, auth.audience: require hmac_secret or jwks_file")
+
+	switch c.Tracing.Exporter {
+	case "none", "stdout":
+	case "otlp":
+		check(c.Tracing.Endpoint != "", "tracing.endpoint: required by the otlp exporter")
+	default:
+		check(false, "tracing.exporter: %q is not one of none, stdout, otlp", c.Tracing.Exporter)
+	}
+	check(c.Tracing.SampleRatio >= 0 && c.Tracing.SampleRatio <= 1, "tracing.sample_ratio: must be between 0 and 1, got %v", c.Tracing.SampleRatio)
+
+	switch c.LogLevel {
+	case "debug", "info", "warn", "error":
+	default:
+		check(false, "log_level: %q is not one of debug, info, warn, error", c.LogLevel)
+	}
+	switch c.LogFormat {
+	case "logfmt", "json":
+	default:
+		check(false, "log_format: %q is not one of logfmt, json", c.LogFormat)
+	}
+
+	if len(errs) > 0 {
+		return errors.New("invalid configuration:\n  " + strings.Join(errs, "\n  "))
+	}
+	return nil
+}
+
+// TLSEnabled reports whether the listeners serve TLS.
+func (c *Config) TLSEnabled() bool {
+	return c.TLS.CertFile != ""
+}
+
+func loadFile(path string, c *Config) error {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("config: %w", err)
+	}
+	switch ext := strings.ToLower(filepath.Ext(path)); ext {
+	case ".yaml", ".yml":
+		err = yaml.UnmarshalStrict(b, c)
+	case ".toml":
+		var md toml.MetaData
+		md, err = toml.Decode(string(b), c)
+		if err == nil && len(md.Undecoded()) > 0 {
+			err = fmt.Errorf("unknown keys %v", md.Undecoded())
+		}
+	default:
+		return fmt.Errorf("config: %s: unknown file type %q, want .yaml, .yml or .toml", path, ext)
+	}
+	if err != nil {
+		return fmt.Errorf("config: %s: %w", path, err)
+	}
+	return nil
+}
+
+type field struct {
+	env, flag, usage string
+	value            reflect.Value
+}
+
+type fieldList []field
+
+func (l fieldList) byEnv(env string) *field {
+	for i := range l {
+		if l[i].env == env {
+			return &l[i]
+		}
+	}
+	return nil
+}
+
+func (l fieldList) byFlag(name string) *field {
+	for i := range l {
+		if l[i].flag == name {
+			return &l[i]
+		}
+	}
+	return nil
+}
+
+// leaves lists the settable fields of the struct v, descending into
+// sections.
+func leaves(v reflect.Value) fieldList {
+	var fields fieldList
+	t := v.Type()
+	for i := 0; i < t.NumField(); i++ {
+		sf := t.Field(i)
+		if sf.Type.Kind() == reflect.Struct && sf.Type != reflect.TypeOf(time.Duration(0)) {
+			fields = append(fields, leaves(v.Field(i))...)
+			continue
+		}
+		fields = append(fields, field{
+			env:   sf.Tag.Get("env"),
+			flag:  sf.Tag.Get("flag"),
+			usage: sf.Tag.Get("usage"),
+			value: v.Field(i),
+		})
+	}
+	return fields
+}
+
+func set(v reflect.Value, s string) error {
+	switch v.Interface().(type) {
+	case string:
+		v.SetString(s)
+	case time.Duration:
+		d, err := time.ParseDuration(s)
+		if err != nil {
+			return err
+		}
+		v.SetInt(int64(d))
+	case int:
+		i, err := strconv.Atoi(s)
+		if err != nil {
+			return fmt.Errorf("%q is not a number", s)
+		}
+		v.SetInt(int64(i))
+	case float64:
+		f, err := strconv.ParseFloat(s, 64)
+		if err != nil {
+			return fmt.Errorf("%q is not a number", s)
+		}
+		v.SetFloat(f)
+	case bool:
+		b, err := strconv.ParseBool(s)
+		if err != nil {
+			return fmt.Errorf("%q is not a boolean", s)
+		}
+		v.SetBool(b)
+	default:
+		return fmt.Errorf("unsupported type %s", v.Type())
+	}
+	return nil
+}
+
+// rawFlag keeps the value of a flag as given, so it can be applied on top
+// of the other sources once they have been read.
+type rawFlag struct {
+	value  string
+	isBool bool
+}
+
+func (f *rawFlag) String() string {
+	if f == nil {
+		return ""
+	}
+	return f.value
+}
+
+func (f *rawFlag) Set(s string) error {
+	f.value = s
+	return nil
+}
+
+func (f *rawFlag) IsBoolFlag() bool {
+	return f.isBool
+}
@@ -0,0 +1,182 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func env(vars map[string]string) func(string) string {
+	return func(key string) string { return vars[key] }
+}
+
+func writeFile(t *testing.T, name, content string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), name)
+	if err := os.WriteFile(path, []byte(content), 0600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestDefaults(t *testing.T) {
+	c, err := Load(nil, env(nil))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := Default()
+	want.Blob.Backend = "inmem" // follows the inmem storage
+	if *c != want {
+		t.Fatalf("got %+v, want %+v", *c, want)
+	}
+}
+
+func TestPrecedence(t *testing.T) {
+	yamlFile := writeFile(t, "config.yaml", `
+http:
+  addr: ":1001"
+  read_timeout: 1s
+workers:
+  count: 1
+  queue_size: 10
+log_level: debug
+`)
+	tomlFile := writeFile(t, "config.toml", `
+log_level = "debug"
+
+[http]
+addr = ":1001"
+read_timeout = "1s"
+
+[workers]
+count = 1
+queue_size = 10
+`)
+	for _, file := range []string{yamlFile, tomlFile} {
+		t.Run(filepath.Ext(file), func(t *testing.T) {
+			c, err := Load(
+				[]string{"-config", file, "-workers", "3"},
+				env(map[string]string{"HTTP_READ_TIMEOUT": "2s", "WORKERS": "2", "LOG_LEVEL": "warn"}),
+			)
+			if err != nil {
+				t.Fatal(err)
+			}
+			for _, tc := range []struct {
+				name      string
+				got, want interface{}
+			}{
+				{"file over default", c.HTTP.Addr, ":1001"},
+				{"file over default", c.Workers.QueueSize, 10},
+				{"env over file", c.HTTP.ReadTimeout, 2 * time.Second},
+				{"env over file", c.LogLevel, "warn"},
+				{"flag over env", c.Workers.Count, 3},
+				{"default", c.GRPC.Addr, ":8082"},
+			} {
+				if tc.got != tc.want {
+					t.Errorf("%s: got %v, want %v", tc.name, tc.got, tc.want)
+				}
+			}
+		})
+	}
+}
+
+func TestConfigFileFromEnv(t *testing.T) {
+	file := writeFile(t, "config.yml", "log_format: json\n")
+	c, err := Load(nil, env(map[string]string{"CONFIG_FILE": file}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c.LogFormat != "json" {
+		t.Fatalf("log format %q, want json", c.LogFormat)
+	}
+}
+
+func TestLegacyPorts(t *testing.T) {
+	c, err := Load(nil, env(map[string]string{"HTTP_PORT": "9001", "GRPC_PORT": "9002", "GRPC_ADDR": "localhost:9003"}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c.HTTP.Addr != ":9001" || c.GRPC.Addr != "localhost:9003" {
+		t.Fatalf("addresses %q, %q", c.HTTP.Addr, c.GRPC.Addr)
+	}
+}
+
+func TestBoolFlag(t *testing.T) {
+	c, err := Load([]string{"-blob-store", "s3", "-s3-endpoint", "localhost:9000", "-s3-insecure"}, env(nil))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !c.Blob.S3.Insecure {
+		t.Fatal("-s3-insecure not set")
+	}
+}
+
+func TestLoadErrors(t *testing.T) {
+	for _, tc := range []struct {
+		name string
+		args []string
+		env  map[string]string
+		want string
+	}{
+		{"unknown flag", []string{"-nope"}, nil, "not defined"},
+		{"bad env", nil, map[string]string{"WORKERS": "many"}, "WORKERS"},
+		{"bad flag", []string{"-health-interval", "soon"}, nil, "-health-interval"},
+		{"unknown yaml key", []string{"-config", writeFile(t, "c.yaml", "nope: 1\n")}, nil, "nope"},
+		{"unknown toml key", []string{"-config", writeFile(t, "c.toml", "nope = 1\n")}, nil, "nope"},
+		{"unknown file type", []string{"-config", writeFile(t, "c.json", "{}")}, nil, "unknown file type"},
+		{"missing file", []string{"-config", filepath.Join(t.TempDir(), "c.yaml")}, nil, "no such file"},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			_, err := Load(tc.args, env(tc.env))
+			if err == nil || !strings.Contains(err.Error(), tc.want) {
+				t.Fatalf("got %v, want an error mentioning %q", err, tc.want)
+			}
+		})
+	}
+}
+
+func TestValidate(t *testing.T) {
+	for _, tc := range []struct {
+		name   string
+		modify func(*Config)
+		want   []string
+	}{
+		{"valid", func(*Config) {}, nil},
+		{"address", func(c *Config) { c.HTTP.Addr = "8081" }, []string{"http.addr"}},
+		{"same addresses", func(c *Config) { c.GRPC.Addr = c.HTTP.Addr }, []string{"both"}},
+		{"storage", func(c *Config) { c.Storage.Backend = "sql" }, []string{"storage.backend"}},
+		{"s3", func(c *Config) { c.Blob.Backend = "s3" }, []string{"blob.s3.endpoint"}},
+		{"upload size", func(c *Config) { c.Blob.MaxUploadSize = 0 }, []string{"blob.max_upload_size"}},
+		{"workers", func(c *Config) { c.Workers.Count, c.Workers.QueueSize = 0, 0 }, []string{"workers.count", "workers.queue_size"}},
+		{"idempotency", func(c *Config) { c.IdempotencyTTL, c.IdempotencyKeys = 0, 0 }, []string{"idempotency_ttl", "idempotency_keys"}},
+		{"tls pair", func(c *Config) { c.TLS.KeyFile = "key.pem" }, []string{"cert_file and key_file"}},
+		{"client ca", func(c *Config) { c.TLS.ClientCAFile = "ca.pem" }, []string{"tls.client_ca_file"}},
+		{"issuer", func(c *Config) { c.Auth.Issuer = "issuer" }, []string{"auth.issuer"}},
+		{"sample ratio", func(c *Config) { c.Tracing.SampleRatio = 2 }, []string{"tracing.sample_ratio"}},
+		{"log", func(c *Config) { c.LogLevel, c.LogFormat = "trace", "text" }, []string{"log_level", "log_format"}},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			c := Default()
+			c.Blob.Backend = "inmem"
+			tc.modify(&c)
+			err := c.Validate()
+			if tc.want == nil {
+				if err != nil {
+					t.Fatal(err)
+				}
+				return
+			}
+			if err == nil {
+				t.Fatal("no error")
+			}
+			// every invalid setting is reported at once
+			for _, want := range tc.want {
+				if !strings.Contains(err.Error(), want) {
+					t.Errorf("%v does not mention %q", err, want)
+				}
+			}
+		})
+	}
+}
@@ -1,11 +1,35 @@
 package internal
 
 type Document struct {
-	Content   string `json:"content"`
-	Title     string `json:"title"`
-	Author    string `json:"author"`
-	Topic     string `json:"topic"`
-	Watermark string `json:"watermark,omitempty"`
+	TicketID    string `json:"ticket_id,omitempty"`
+	Status      Status `json:"status,omitempty"`
+	ContentType string `json:"content_type,omitempty"`
+	Title       string `json:"title"`
+	Author      string `json:"author"`
+	Topic       string `json:"topic"`
+	Watermark   string `json:"watermark,omitempty"`
+
+	// ContentDigest is the hex encoded SHA-256 digest the content is kept
+	// under in the blob store, and ContentSize its size in bytes
+	ContentDigest string `json:"content_digest,omitempty"`
+	ContentSize   int64  `json:"content_size"`
+
+	// MarkedDigest and MarkedSize locate the content with the watermark
+	// applied, set once the ticket is Finished
+	MarkedDigest string `json:"marked_digest,omitempty"`
+	MarkedSize   int64  `json:"marked_size,omitempty"`
+
+	// RequestID identifies the request that created the ticket or, once it
+	// has been, started watermarking it
+	RequestID string `json:"request_id,omitempty"`
+
+	// CreatedBy is the subject of the principal that created the ticket,
+	// empty if authentication is disabled
+	CreatedBy string `json:"created_by,omitempty"`
+
+	// TenantID is the tenant the ticket belongs to, only callers of the same
+	// tenant can see it
+	TenantID string `json:"tenant_id,omitempty"`
 }
 
 type Filter struct {
@@ -15,6 +39,14 @@ type Filter struct {
 	Value string `json:"value,omitempty"`
 }
 
+type Page struct {
+	// Size is the maximum number of documents to return, zero selects the default
+	Size int
+
+	// Token is the next page token of the previous page, empty for the first page
+	Token string
+}
+
 type Status string
 
 const (
@@ -0,0 +1,46 @@
+package internal
+
+import "fmt"
+
+// transitions is the document status state machine: every status maps to
+// the statuses a document is allowed to move to next.
+var transitions = map[Status][]Status{
+	Pending:    {Started, Failed},
+	Started:    {InProgress, Failed},
+	InProgress: {Finished, Failed},
+	Failed:     {Started},
+	Finished:   {},
+}
+
+// TransitionError is returned when a document is asked to move to a status
+// that is not reachable from its current one.
+type TransitionError struct {
+	From Status
+	To   Status
+}
+
+func (e *TransitionError) Error() string {
+	return fmt.Sprintf("document cannot move from %s to %s", e.From, e.To)
+}
+
+func (s Status) CanTransitionTo(next Status) bool {
+	for _, allowed := range transitions[s] {
+		if allowed == next {
+			return true
+		}
+	}
+	return false
+}
+
+// Terminal reports whether s is the final status of a watermark run.
+func (s Status) Terminal() bool {
+	return s == Finished || s == Failed
+}
+
+// TransitionTo returns a *TransitionError if next is not reachable from s.
+func (s Status) TransitionTo(next Status) error {
+	if !s.CanTransitionTo(next) {
+		return &TransitionError{From: s, To: next}
+	}
+	return nil
+}
@@ -0,0 +1,58 @@
+package internal
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestStatusTransitions(t *testing.T) {
+	statuses := []Status{Pending, Started, InProgress, Finished, Failed}
+	allowed := map[[2]Status]bool{
+		{Pending, Started}:     true,
+		{Pending, Failed}:      true,
+		{Started, InProgress}:  true,
+		{Started, Failed}:      true,
+		{InProgress, Finished}: true,
+		{InProgress, Failed}:   true,
+		{Failed, Started}:      true,
+	}
+	for _, from := range statuses {
+		for _, to := range statuses {
+			want := allowed[[2]Status{from, to}]
+			if got := from.CanTransitionTo(to); got != want {
+				t.Errorf("%s.CanTransitionTo(%s) = %v, want %v", from, to, got, want)
+			}
+			err := from.TransitionTo(to)
+			if want {
+				if err != nil {
+					t.Errorf("%s.TransitionTo(%s) = %v, want nil", from, to, err)
+				}
+				continue
+			}
+			var te *TransitionError
+			if !errors.As(err, &te) || te.From != from || te.To != to {
+				t.Errorf("%s.TransitionTo(%s) = %v, want a *TransitionError", from, to, err)
+			}
+		}
+	}
+}
+
+func TestStatusTerminal(t *testing.T) {
+	for status, want := range map[Status]bool{
+		Pending:    false,
+		Started:    false,
+		InProgress: false,
+		Finished:   true,
+		Failed:     true,
+	} {
+		if got := status.Terminal(); got != want {
+			t.Errorf("%s.Terminal() = %v, want %v", status, got, want)
+		}
+	}
+}
+
+func TestUnknownStatusCantTransition(t *testing.T) {
+	if Status("Bogus").CanTransitionTo(Started) {
+		t.Error("an unknown status can transition")
+	}
+}
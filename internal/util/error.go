@@ -1,9 +1,19 @@
 package util
 
-import "errors"
+import "github.com/wzzfarewell/go-microservice-example/pkg/watermark/errors"
 
 var (
-	ErrPathParamNotFound = errors.New("unknown argument passed")
+	ErrPathParamNotFound = &errors.NotFound{Message: "unknown argument passed"}
 
-	ErrInvalidArgument = errors.New("invalid argument passed")
+	ErrInvalidArgument = &errors.InvalidArgument{Message: "invalid argument passed"}
+
+	ErrDocumentNotFound = &errors.NotFound{Message: "document not found"}
+
+	ErrDocumentExists = &errors.Conflict{Message: "document already exists"}
+
+	ErrBlobNotFound = &errors.NotFound{Message: "blob not found"}
+
+	ErrQueueFull = &errors.Unavailable{Message: "watermark queue is full"}
+
+	ErrQueueClosed = &errors.Unavailable{Message: "watermark queue is closed"}
 )
@@ -0,0 +1,146 @@
+// Package auth authenticates the callers of the service, with JWTs signed
+// with a shared secret (HS256) or a key of a local JWKS file (RS256), with
+// static API keys, or with the client certificates of mutual TLS.
+package auth
+
+import (
+	"context"
+	"crypto/x509"
+	"strings"
+
+	wmerrors "github.com/wzzfarewell/go-microservice-example/pkg/watermark/errors"
+)
+
+// The schemes of the Authorization header, or metadata, credentials are
+// presented with: "Bearer <jwt>" or "ApiKey <key>".
+const (
+	SchemeBearer = "Bearer"
+	SchemeAPIKey = "ApiKey"
+)
+
+// The methods a Principal can have been authenticated with.
+const (
+	MethodJWT        = "jwt"
+	MethodAPIKey     = "api_key"
+	MethodClientCert = "client_cert"
+)
+
+// RoleAdmin is the only role a principal may hold without belonging to a
+// tenant, as it acts across all of them.
+const RoleAdmin = "admin"
+
+var (
+	ErrMissingCredentials = &wmerrors.Unauthenticated{Message: "missing credentials"}
+	ErrUnknownScheme      = &wmerrors.Unauthenticated{Message: "unsupported authorization scheme"}
+	ErrInvalidAPIKey      = &wmerrors.Unauthenticated{Message: "invalid API key"}
+	ErrUnknownClientCert  = &wmerrors.Unauthenticated{Message: "unknown client certificate"}
+)
+
+// Credentials are what a caller presents to be authenticated.
+type Credentials struct {
+	Scheme string
+	Token  string
+}
+
+// ParseAuthorization splits the value of an Authorization header. The scheme
+// is matched case-insensitively.
+func ParseAuthorization(value string) (Credentials, bool) {
+	scheme, token, ok := strings.Cut(strings.TrimSpace(value), " ")
+	token = strings.TrimSpace(token)
+	if !ok || token == "" {
+		return Credentials{}, false
+	}
+	for _, s := range []string{SchemeBearer, SchemeAPIKey} {
+		if strings.EqualFold(scheme, s) {
+			return Credentials{Scheme: s, Token: token}, true
+		}
+	}
+	return Credentials{Scheme: scheme, Token: token}, true
+}
+
+// String formats the credentials as the value of an Authorization header.
+func (c Credentials) String() string {
+	return c.Scheme + " " + c.Token
+}
+
+// Principal is an authenticated caller, with the roles it has been granted
+// and the tenant it belongs to.
+type Principal struct {
+	Subject  string   `json:"subject"`
+	Method   string   `json:"method"`
+	Roles    []string `json:"roles,omitempty"`
+	TenantID string   `json:"tenant_id,omitempty"`
+}
+
+// NeedsTenant reports whether p must belong to a tenant, which every
+// principal but admins must.
+func (p Principal) NeedsTenant() bool {
+	return p.TenantID == "" && !p.HasRole(RoleAdmin)
+}
+
+// HasRole reports whether p has been granted role.
+func (p Principal) HasRole(role string) bool {
+	for _, r := range p.Roles {
+		if r == role {
+			return true
+		}
+	}
+	return false
+}
+
+type contextKey int
+
+const (
+	credentialsKey contextKey = iota
+	principalKey
+	peerKey
+)
+
+// ContextWithCredentials returns a copy of ctx carrying the credentials the
+// transports read from a request, or the clients send along.
+func ContextWithCredentials(ctx context.Context, c Credentials) context.Context {
+	return context.WithValue(ctx, credentialsKey, c)
+}
+
+func CredentialsFromContext(ctx context.Context) (Credentials, bool) {
+	c, ok := ctx.Value(credentialsKey).(Credentials)
+	return c, ok
+}
+
+// ContextWithPrincipal returns a copy of ctx carrying the caller p.
+func ContextWithPrincipal(ctx context.Context, p Principal) context.Context {
+	return context.WithValue(ctx, principalKey, p)
+}
+
+// PrincipalFromContext returns the caller ctx has been authenticated for, if
+// any.
+func PrincipalFromContext(ctx context.Context) (Principal, bool) {
+	p, ok := ctx.Value(principalKey).(Principal)
+	return p, ok
+}
+
+// PeerIdentity returns the identity a verified client certificate stands
+// for: its first URI SAN (e.g. a SPIFFE ID), else its first DNS SAN, else its
+// common name.
+func PeerIdentity(cert *x509.Certificate) string {
+	switch {
+	case len(cert.URIs) > 0:
+		return cert.URIs[0].String()
+	case len(cert.DNSNames) > 0:
+		return cert.DNSNames[0]
+	}
+	return cert.Subject.CommonName
+}
+
+// ContextWithPeer returns a copy of ctx carrying the identity of the verified
+// client certificate the transports read from the connection.
+func ContextWithPeer(ctx context.Context, identity string) context.Context {
+	return context.WithValue(ctx, peerKey, identity)
+}
+
+// PeerFromContext returns the identity of the client certificate of the
+// connection, if the caller presented one.
+func PeerFromContext(ctx context.Context) (string, bool) {
+	identity, ok := ctx.Value(peerKey).(string)
+	return identity, ok
+}
@@ -0,0 +1,166 @@
+package auth
+
+import (
+	"context"
+	"crypto/rsa"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+
+	"github.com/golang-jwt/jwt/v4"
+	wmerrors "github.com/wzzfarewell/go-microservice-example/pkg/watermark/errors"
+)
+
+// Config selects the accepted credentials. JWTs are accepted if HMACSecret
+// or JWKSFile is set, API keys if APIKeysFile is, and client certificates
+// if ClientCertsFile is. The transports only report certificates verified
+// by the client CA of the listeners.
+type Config struct {
+	HMACSecret      string
+	JWKSFile        string
+	APIKeysFile     string
+	ClientCertsFile string
+
+	// Issuer and Audience, if set, must match the iss and aud claims.
+	Issuer   string
+	Audience string
+}
+
+// Enabled reports whether any kind of credentials is configured.
+func (c Config) Enabled() bool {
+	return c.HMACSecret != "" || c.JWKSFile != "" || c.APIKeysFile != "" || c.ClientCertsFile != ""
+}
+
+// Authenticator verifies Credentials.
+type Authenticator struct {
+	hmacSecret []byte
+	rsaKeys    map[string]*rsa.PublicKey
+	apiKeys    map[[sha256.Size]byte]Principal
+	peers      map[string]Principal
+	issuer     string
+	audience   string
+	parser     *jwt.Parser // nil unless JWTs are accepted
+}
+
+// New loads the JWKS and API keys files of cfg.
+func New(cfg Config) (*Authenticator, error) {
+	a := &Authenticator{issuer: cfg.Issuer, audience: cfg.Audience}
+	var methods []string
+	if cfg.HMACSecret != "" {
+		a.hmacSecret = []byte(cfg.HMACSecret)
+		methods = append(methods, jwt.SigningMethodHS256.Alg())
+	}
+	if cfg.JWKSFile != "" {
+		keys, err := LoadJWKS(cfg.JWKSFile)
+		if err != nil {
+			return nil, err
+		}
+		a.rsaKeys = keys
+		methods = append(methods, jwt.SigningMethodRS256.Alg())
+	}
+	if cfg.APIKeysFile != "" {
+		keys, err := LoadAPIKeys(cfg.APIKeysFile)
+		if err != nil {
+			return nil, err
+		}
+		a.apiKeys = keys
+	}
+	if cfg.ClientCertsFile != "" {
+		peers, err := LoadClientCerts(cfg.ClientCertsFile)
+		if err != nil {
+			return nil, err
+		}
+		a.peers = peers
+	}
+	if len(methods) > 0 {
+		a.parser = jwt.NewParser(jwt.WithValidMethods(methods))
+	}
+	return a, nil
+}
+
+// Authenticate returns the caller c identifies, or a
+// *wmerrors.Unauthenticated error. Without credentials, the caller is the one
+// its client certificate identifies, if any, so a service can still present
+// a token to act for somebody else.
+func (a *Authenticator) Authenticate(ctx context.Context, c Credentials) (Principal, error) {
+	switch {
+	case c.Token == "" && a.peers != nil:
+		identity, ok := PeerFromContext(ctx)
+		if !ok {
+			return Principal{}, ErrMissingCredentials
+		}
+		if p, ok := a.peers[identity]; ok {
+			return p, nil
+		}
+		return Principal{}, ErrUnknownClientCert
+	case c.Token == "":
+		return Principal{}, ErrMissingCredentials
+	case c.Scheme == SchemeBearer && a.parser != nil:
+		return a.authenticateJWT(c.Token)
+	case c.Scheme == SchemeAPIKey && a.apiKeys != nil:
+		// looking up the hash doesn't leak how much of a key matched
+		if p, ok := a.apiKeys[sha256.Sum256([]byte(c.Token))]; ok {
+			return p, nil
+		}
+		return Principal{}, ErrInvalidAPIKey
+	}
+	return Principal{}, ErrUnknownScheme
+}
+
+// Claims are the claims of the accepted JWTs: the registered ones, plus the
+// roles of the subject and its tenant. Tokens must have a subject and an
+// expiry.
+type Claims struct {
+	jwt.RegisteredClaims
+	Roles  []string `json:"roles,omitempty"`
+	Tenant string   `json:"tenant,omitempty"`
+}
+
+func (a *Authenticator) authenticateJWT(token string) (Principal, error) {
+	var claims Claims
+	if _, err := a.parser.ParseWithClaims(token, &claims, a.key); err != nil {
+		return Principal{}, &wmerrors.Unauthenticated{Message: "invalid token", Err: err}
+	}
+	switch {
+	case claims.Subject == "":
+		return Principal{}, &wmerrors.Unauthenticated{Message: "invalid token: no subject"}
+	case claims.ExpiresAt == nil:
+		// the parser only checks exp when it is present
+		return Principal{}, &wmerrors.Unauthenticated{Message: "invalid token: no expiry"}
+	case a.issuer != "" && !claims.VerifyIssuer(a.issuer, true):
+		return Principal{}, &wmerrors.Unauthenticated{Message: "invalid token: wrong issuer"}
+	case a.audience != "" && !claims.VerifyAudience(a.audience, true):
+		return Principal{}, &wmerrors.Unauthenticated{Message: "invalid token: wrong audience"}
+	}
+	p := Principal{
+		Subject:  claims.Subject,
+		Method:   MethodJWT,
+		Roles:    claims.Roles,
+		TenantID: claims.Tenant,
+	}
+	if p.NeedsTenant() {
+		return Principal{}, &wmerrors.Unauthenticated{Message: "invalid token: no tenant"}
+	}
+	return p, nil
+}
+
+// key returns the key the signature of token is checked with.
+func (a *Authenticator) key(token *jwt.Token) (interface{}, error) {
+	switch token.Method.Alg() {
+	case jwt.SigningMethodHS256.Alg():
+		return a.hmacSecret, nil
+	case jwt.SigningMethodRS256.Alg():
+		kid, _ := token.Header["kid"].(string)
+		if key, ok := a.rsaKeys[kid]; ok {
+			return key, nil
+		}
+		// a JWKS with a single key doesn't need tokens to name it
+		if kid == "" && len(a.rsaKeys) == 1 {
+			for _, key := range a.rsaKeys {
+				return key, nil
+			}
+		}
+		return nil, fmt.Errorf("unknown key %q", kid)
+	}
+	return nil, errors.New("unexpected signing method")
+}
@@ -0,0 +1,344 @@
+package auth
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"math/big"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v4"
+	wmerrors "github.com/wzzfarewell/go-microservice-example/pkg/watermark/errors"
+)
+
+const testSecret = "secret"
+
+func writeJSON(t *testing.T, v interface{}) string {
+	t.Helper()
+	b, err := json.Marshal(v)
+	if err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(t.TempDir(), "file.json")
+	if err := os.WriteFile(path, b, 0600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+// writeJWKS writes the public halves of keys, named by their map key, to a
+// JWKS file.
+func writeJWKS(t *testing.T, keys map[string]*rsa.PrivateKey) string {
+	t.Helper()
+	type jwk struct {
+		Kty string `json:"kty"`
+		Use string `json:"use"`
+		Kid string `json:"kid"`
+		N   string `json:"n"`
+		E   string `json:"e"`
+	}
+	var set struct {
+		Keys []jwk `json:"keys"`
+	}
+	for kid, key := range keys {
+		set.Keys = append(set.Keys, jwk{
+			Kty: "RSA",
+			Use: "sig",
+			Kid: kid,
+			N:   base64.RawURLEncoding.EncodeToString(key.N.Bytes()),
+			E:   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(key.E)).Bytes()),
+		})
+	}
+	return writeJSON(t, set)
+}
+
+func newRSAKey(t *testing.T) *rsa.PrivateKey {
+	t.Helper()
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return key
+}
+
+func validClaims() Claims {
+	return Claims{
+		RegisteredClaims: jwt.RegisteredClaims{
+			Subject:   "alice",
+			Issuer:    "issuer",
+			Audience:  jwt.ClaimStrings{"watermark"},
+			ExpiresAt: jwt.NewNumericDate(time.Now().Add(time.Hour)),
+		},
+		Roles:  []string{"reader"},
+		Tenant: "red",
+	}
+}
+
+func signHS256(t *testing.T, claims Claims, secret string) string {
+	t.Helper()
+	token, err := jwt.NewWithClaims(jwt.SigningMethodHS256, claims).SignedString([]byte(secret))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return token
+}
+
+func signRS256(t *testing.T, claims Claims, kid string, key *rsa.PrivateKey) string {
+	t.Helper()
+	token := jwt.NewWithClaims(jwt.SigningMethodRS256, claims)
+	if kid != "" {
+		token.Header["kid"] = kid
+	}
+	signed, err := token.SignedString(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return signed
+}
+
+func bearer(token string) Credentials {
+	return Credentials{Scheme: SchemeBearer, Token: token}
+}
+
+func expectUnauthenticated(t *testing.T, err error) {
+	t.Helper()
+	var unauthenticated *wmerrors.Unauthenticated
+	if !errors.As(err, &unauthenticated) {
+		t.Fatalf("got %v, want an unauthenticated error", err)
+	}
+}
+
+func TestHS256(t *testing.T) {
+	a, err := New(Config{HMACSecret: testSecret, Issuer: "issuer", Audience: "watermark"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	p, err := a.Authenticate(context.Background(), bearer(signHS256(t, validClaims(), testSecret)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := Principal{Subject: "alice", Method: MethodJWT, Roles: []string{"reader"}, TenantID: "red"}
+	if !reflect.DeepEqual(p, want) {
+		t.Fatalf("got %+v, want %+v", p, want)
+	}
+
+	for _, tc := range []struct {
+		name   string
+		modify func(*Claims)
+		secret string
+	}{
+		{"wrong secret", func(*Claims) {}, "other"},
+		{"expired", func(c *Claims) { c.ExpiresAt = jwt.NewNumericDate(time.Now().Add(-time.Minute)) }, testSecret},
+		{"no expiry", func(c *Claims) { c.ExpiresAt = nil }, testSecret},
+		{"not yet valid", func(c *Claims) { c.NotBefore = jwt.NewNumericDate(time.Now().Add(time.Hour)) }, testSecret},
+		{"no subject", func(c *Claims) { c.Subject = "" }, testSecret},
+		{"wrong issuer", func(c *Claims) { c.Issuer = "other" }, testSecret},
+		{"no issuer", func(c *Claims) { c.Issuer = "" }, testSecret},
+		{"wrong audience", func(c *Claims) { c.Audience = jwt.ClaimStrings{"other"} }, testSecret},
+		{"no tenant", func(c *Claims) { c.Tenant = "" }, testSecret},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			claims := validClaims()
+			tc.modify(&claims)
+			_, err := a.Authenticate(context.Background(), bearer(signHS256(t, claims, tc.secret)))
+			expectUnauthenticated(t, err)
+		})
+	}
+}
+
+func TestRejectsUnsignedTokens(t *testing.T) {
+	a, err := New(Config{HMACSecret: testSecret})
+	if err != nil {
+		t.Fatal(err)
+	}
+	token, err := jwt.NewWithClaims(jwt.SigningMethodNone, validClaims()).SignedString(jwt.UnsafeAllowNoneSignatureType)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = a.Authenticate(context.Background(), bearer(token))
+	expectUnauthenticated(t, err)
+}
+
+func TestRS256(t *testing.T) {
+	key1, key2, other := newRSAKey(t), newRSAKey(t), newRSAKey(t)
+	a, err := New(Config{JWKSFile: writeJWKS(t, map[string]*rsa.PrivateKey{"one": key1, "two": key2})})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, tc := range []struct {
+		name  string
+		token string
+		ok    bool
+	}{
+		{"first key", signRS256(t, validClaims(), "one", key1), true},
+		{"second key", signRS256(t, validClaims(), "two", key2), true},
+		{"key of another kid", signRS256(t, validClaims(), "one", key2), false},
+		{"unknown kid", signRS256(t, validClaims(), "three", other), false},
+		{"no kid with several keys", signRS256(t, validClaims(), "", key1), false},
+		// the HMAC secret isn't configured, so HS256 isn't accepted at all
+		{"HS256", signHS256(t, validClaims(), ""), false},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			p, err := a.Authenticate(context.Background(), bearer(tc.token))
+			if !tc.ok {
+				expectUnauthenticated(t, err)
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if p.Subject != "alice" || p.Method != MethodJWT {
+				t.Fatalf("got %+v", p)
+			}
+		})
+	}
+
+	// a JWKS with a single key doesn't need tokens to name it
+	single, err := New(Config{JWKSFile: writeJWKS(t, map[string]*rsa.PrivateKey{"one": key1})})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := single.Authenticate(context.Background(), bearer(signRS256(t, validClaims(), "", key1))); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestAPIKeys(t *testing.T) {
+	a, err := New(Config{APIKeysFile: writeJSON(t, []APIKey{
+		{Key: "k1", Subject: "svc", Roles: []string{"submitter"}, Tenant: "red"},
+	})})
+	if err != nil {
+		t.Fatal(err)
+	}
+	p, err := a.Authenticate(context.Background(), Credentials{Scheme: SchemeAPIKey, Token: "k1"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := Principal{Subject: "svc", Method: MethodAPIKey, Roles: []string{"submitter"}, TenantID: "red"}
+	if !reflect.DeepEqual(p, want) {
+		t.Fatalf("got %+v, want %+v", p, want)
+	}
+
+	for _, tc := range []struct {
+		name string
+		c    Credentials
+		want error
+	}{
+		{"wrong key", Credentials{Scheme: SchemeAPIKey, Token: "k2"}, ErrInvalidAPIKey},
+		{"prefix of the key", Credentials{Scheme: SchemeAPIKey, Token: "k"}, ErrInvalidAPIKey},
+		{"missing", Credentials{}, ErrMissingCredentials},
+		{"JWTs aren't configured", bearer("k1"), ErrUnknownScheme},
+		{"unknown scheme", Credentials{Scheme: "Basic", Token: "k1"}, ErrUnknownScheme},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			if _, err := a.Authenticate(context.Background(), tc.c); err != tc.want {
+				t.Fatalf("got %v, want %v", err, tc.want)
+			}
+		})
+	}
+}
+
+func TestLoadAPIKeysErrors(t *testing.T) {
+	for name, entries := range map[string][]APIKey{
+		"no key":        {{Subject: "svc", Tenant: "red"}},
+		"no subject":    {{Key: "k1", Tenant: "red"}},
+		"duplicate key": {{Key: "k1", Subject: "a", Tenant: "red"}, {Key: "k1", Subject: "b", Tenant: "red"}},
+		"no tenant":     {{Key: "k1", Subject: "svc", Roles: []string{"submitter"}}},
+		"no role":       {{Key: "k1", Subject: "svc"}},
+	} {
+		if _, err := LoadAPIKeys(writeJSON(t, entries)); err == nil {
+			t.Errorf("%s: no error", name)
+		}
+	}
+}
+
+func TestLoadJWKSErrors(t *testing.T) {
+	for name, set := range map[string]interface{}{
+		"no keys":        map[string]interface{}{"keys": []interface{}{}},
+		"encryption key": map[string]interface{}{"keys": []interface{}{map[string]string{"kty": "RSA", "use": "enc", "n": "AQAB", "e": "AQAB"}}},
+		"bad modulus":    map[string]interface{}{"keys": []interface{}{map[string]string{"kty": "RSA", "n": "!", "e": "AQAB"}}},
+	} {
+		if _, err := LoadJWKS(writeJSON(t, set)); err == nil {
+			t.Errorf("%s: no error", name)
+		}
+	}
+}
+
+func TestParseAuthorization(t *testing.T) {
+	for _, tc := range []struct {
+		value string
+		want  Credentials
+		ok    bool
+	}{
+		{"Bearer abc", Credentials{Scheme: SchemeBearer, Token: "abc"}, true},
+		{"bearer  abc ", Credentials{Scheme: SchemeBearer, Token: "abc"}, true},
+		{"APIKEY k1", Credentials{Scheme: SchemeAPIKey, Token: "k1"}, true},
+		{"Basic dXNlcg==", Credentials{Scheme: "Basic", Token: "dXNlcg=="}, true},
+		{"Bearer", Credentials{}, false},
+		{"", Credentials{}, false},
+	} {
+		got, ok := ParseAuthorization(tc.value)
+		if got != tc.want || ok != tc.ok {
+			t.Errorf("ParseAuthorization(%q) = %+v, %v, want %+v, %v", tc.value, got, ok, tc.want, tc.ok)
+		}
+	}
+}
+
+func TestAdminsNeedNoTenant(t *testing.T) {
+	a, err := New(Config{
+		HMACSecret:  testSecret,
+		APIKeysFile: writeJSON(t, []APIKey{{Key: "k1", Subject: "root", Roles: []string{RoleAdmin}}}),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	claims := validClaims()
+	claims.Roles, claims.Tenant = []string{RoleAdmin}, ""
+	if _, err := a.Authenticate(context.Background(), bearer(signHS256(t, claims, testSecret))); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := a.Authenticate(context.Background(), Credentials{Scheme: SchemeAPIKey, Token: "k1"}); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestClientCerts(t *testing.T) {
+	a, err := New(Config{ClientCertsFile: writeJSON(t, []ClientCert{
+		{Identity: "spiffe://example.org/svc", Roles: []string{"reader"}, Tenant: "red"},
+	})})
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx := ContextWithPeer(context.Background(), "spiffe://example.org/svc")
+	p, err := a.Authenticate(ctx, Credentials{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := Principal{Subject: "spiffe://example.org/svc", Method: MethodClientCert, Roles: []string{"reader"}, TenantID: "red"}
+	if !reflect.DeepEqual(p, want) {
+		t.Fatalf("got %+v, want %+v", p, want)
+	}
+	if _, err := a.Authenticate(ContextWithPeer(context.Background(), "other"), Credentials{}); err != ErrUnknownClientCert {
+		t.Fatalf("got %v, want %v", err, ErrUnknownClientCert)
+	}
+	if _, err := a.Authenticate(context.Background(), Credentials{}); err != ErrMissingCredentials {
+		t.Fatalf("got %v, want %v", err, ErrMissingCredentials)
+	}
+
+	for name, entries := range map[string][]ClientCert{
+		"no identity":        {{Tenant: "red"}},
+		"duplicate identity": {{Identity: "a", Tenant: "red"}, {Identity: "a", Tenant: "red"}},
+		"no tenant":          {{Identity: "a", Roles: []string{"reader"}}},
+	} {
+		if _, err := LoadClientCerts(writeJSON(t, entries)); err == nil {
+			t.Errorf("%s: no error", name)
+		}
+	}
+}
@@ -0,0 +1,127 @@
+package auth
+
+import (
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"math/big"
+	"os"
+)
+
+// LoadJWKS reads the RSA signing keys of a JSON Web Key Set, keyed by kid.
+// Keys of other types or for encryption are skipped.
+func LoadJWKS(path string) (map[string]*rsa.PublicKey, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("auth: %w", err)
+	}
+	var set struct {
+		Keys []struct {
+			Kty string `json:"kty"`
+			Use string `json:"use"`
+			Kid string `json:"kid"`
+			N   string `json:"n"`
+			E   string `json:"e"`
+		} `json:"keys"`
+	}
+	if err := json.Unmarshal(b, &set); err != nil {
+		return nil, fmt.Errorf("auth: %s: %w", path, err)
+	}
+	keys := make(map[string]*rsa.PublicKey)
+	for _, k := range set.Keys {
+		if k.Kty != "RSA" || (k.Use != "" && k.Use != "sig") {
+			continue
+		}
+		n, err := base64.RawURLEncoding.DecodeString(k.N)
+		if err != nil {
+			return nil, fmt.Errorf("auth: %s: key %q: n: %w", path, k.Kid, err)
+		}
+		e, err := base64.RawURLEncoding.DecodeString(k.E)
+		if err != nil {
+			return nil, fmt.Errorf("auth: %s: key %q: e: %w", path, k.Kid, err)
+		}
+		if _, dup := keys[k.Kid]; dup {
+			return nil, fmt.Errorf("auth: %s: duplicate key %q", path, k.Kid)
+		}
+		keys[k.Kid] = &rsa.PublicKey{N: new(big.Int).SetBytes(n), E: int(new(big.Int).SetBytes(e).Int64())}
+	}
+	if len(keys) == 0 {
+		return nil, fmt.Errorf("auth: %s: no RSA signing keys", path)
+	}
+	return keys, nil
+}
+
+// APIKey is an entry of the API keys file, a JSON array of them.
+type APIKey struct {
+	Key     string   `json:"key"`
+	Subject string   `json:"subject"`
+	Roles   []string `json:"roles"`
+	Tenant  string   `json:"tenant"`
+}
+
+// LoadAPIKeys reads an API keys file into the principals they authenticate,
+// keyed by the SHA-256 digest of the key.
+func LoadAPIKeys(path string) (map[[sha256.Size]byte]Principal, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("auth: %w", err)
+	}
+	var entries []APIKey
+	if err := json.Unmarshal(b, &entries); err != nil {
+		return nil, fmt.Errorf("auth: %s: %w", path, err)
+	}
+	keys := make(map[[sha256.Size]byte]Principal, len(entries))
+	for i, e := range entries {
+		if e.Key == "" || e.Subject == "" {
+			return nil, fmt.Errorf("auth: %s: entry %d: key and subject are required", path, i)
+		}
+		digest := sha256.Sum256([]byte(e.Key))
+		if _, dup := keys[digest]; dup {
+			return nil, fmt.Errorf("auth: %s: entry %d: duplicate key", path, i)
+		}
+		p := Principal{Subject: e.Subject, Method: MethodAPIKey, Roles: e.Roles, TenantID: e.Tenant}
+		if p.NeedsTenant() {
+			return nil, fmt.Errorf("auth: %s: entry %d: tenant is required without the %s role", path, i, RoleAdmin)
+		}
+		keys[digest] = p
+	}
+	return keys, nil
+}
+
+// ClientCert is an entry of the client certificates file, a JSON array of
+// them, granting roles to the identity of a certificate (see PeerIdentity).
+type ClientCert struct {
+	Identity string   `json:"identity"`
+	Roles    []string `json:"roles"`
+	Tenant   string   `json:"tenant"`
+}
+
+// LoadClientCerts reads a client certificates file into the principals they
+// authenticate, keyed by identity, which is also their subject.
+func LoadClientCerts(path string) (map[string]Principal, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("auth: %w", err)
+	}
+	var entries []ClientCert
+	if err := json.Unmarshal(b, &entries); err != nil {
+		return nil, fmt.Errorf("auth: %s: %w", path, err)
+	}
+	peers := make(map[string]Principal, len(entries))
+	for i, e := range entries {
+		if e.Identity == "" {
+			return nil, fmt.Errorf("auth: %s: entry %d: identity is required", path, i)
+		}
+		if _, dup := peers[e.Identity]; dup {
+			return nil, fmt.Errorf("auth: %s: entry %d: duplicate identity %q", path, i, e.Identity)
+		}
+		p := Principal{Subject: e.Identity, Method: MethodClientCert, Roles: e.Roles, TenantID: e.Tenant}
+		if p.NeedsTenant() {
+			return nil, fmt.Errorf("auth: %s: entry %d: tenant is required without the %s role", path, i, RoleAdmin)
+		}
+		peers[e.Identity] = p
+	}
+	return peers, nil
+}
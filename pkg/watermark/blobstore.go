@@ -0,0 +1,44 @@
+package watermark
+
+import (
+	"context"
+	"encoding/hex"
+	"fmt"
+	"io"
+
+	"github.com/wzzfarewell/go-microservice-example/internal/util"
+)
+
+// BlobStore stores document contents keyed by the hex encoded SHA-256 digest
+// of their bytes, so a content uploaded twice is only stored once.
+type BlobStore interface {
+	// Put stores everything read from r and returns its digest and size.
+	Put(ctx context.Context, r io.Reader) (digest string, size int64, err error)
+
+	// Get opens the blob with the given digest, failing with
+	// util.ErrBlobNotFound if there is none. The caller must close it.
+	Get(ctx context.Context, digest string) (io.ReadCloser, int64, error)
+
+	// Delete removes the blob with the given digest. Deleting a missing
+	// blob is not an error.
+	Delete(ctx context.Context, digest string) error
+}
+
+// CheckDigest returns an error unless digest is a lowercase hex encoded
+// SHA-256 digest. Stores call it before using a digest as a key or path.
+func CheckDigest(digest string) error {
+	b, err := hex.DecodeString(digest)
+	if err != nil || len(b) != 32 || hex.EncodeToString(b) != digest {
+		return fmt.Errorf("%w: malformed digest %q", util.ErrInvalidArgument, digest)
+	}
+	return nil
+}
+
+func readBlob(ctx context.Context, blobs BlobStore, digest string) ([]byte, error) {
+	rc, _, err := blobs.Get(ctx, digest)
+	if err != nil {
+		return nil, err
+	}
+	defer rc.Close()
+	return io.ReadAll(rc)
+}
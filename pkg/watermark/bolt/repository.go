@@ -0,0 +1,173 @@
+package bolt
+
+import (
+	"bytes"
+	"context"
+	"encoding/gob"
+	"fmt"
+	"time"
+
+	"github.com/wzzfarewell/go-microservice-example/internal"
+	"github.com/wzzfarewell/go-microservice-example/internal/util"
+	bolt "go.etcd.io/bbolt"
+)
+
+var documentBucket = []byte("documents")
+
+// Repository is a watermark.Repository backed by a BoltDB file on local disk.
+// Documents are gob encoded.
+type Repository struct {
+	db *bolt.DB
+}
+
+func NewRepository(path string) (*Repository, error) {
+	db, err := bolt.Open(path, 0600, &bolt.Options{Timeout: time.Second})
+	if err != nil {
+		return nil, err
+	}
+	err = db.Update(func(tx *bolt.Tx) error {
+		_, err := tx.CreateBucketIfNotExists(documentBucket)
+		return err
+	})
+	if err != nil {
+		db.Close()
+		return nil, err
+	}
+	return &Repository{db: db}, nil
+}
+
+func (r *Repository) Close() error {
+	return r.db.Close()
+}
+
+func (r *Repository) Create(_ context.Context, doc *internal.Document) error {
+	return r.db.Update(func(tx *bolt.Tx) error {
+		b := tx.Bucket(documentBucket)
+		if b.Get([]byte(doc.TicketID)) != nil {
+			return util.ErrDocumentExists
+		}
+		return put(b, doc)
+	})
+}
+
+func (r *Repository) Get(_ context.Context, ticketID string) (*internal.Document, error) {
+	var doc internal.Document
+	err := r.db.View(func(tx *bolt.Tx) error {
+		v := tx.Bucket(documentBucket).Get([]byte(ticketID))
+		if v == nil {
+			return util.ErrDocumentNotFound
+		}
+		return decode(v, &doc)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &doc, nil
+}
+
+func (r *Repository) Update(_ context.Context, doc *internal.Document) error {
+	return r.db.Update(func(tx *bolt.Tx) error {
+		b := tx.Bucket(documentBucket)
+		if b.Get([]byte(doc.TicketID)) == nil {
+			return util.ErrDocumentNotFound
+		}
+		return put(b, doc)
+	})
+}
+
+func (r *Repository) List(_ context.Context) ([]internal.Document, error) {
+	var docs []internal.Document
+	err := r.db.View(func(tx *bolt.Tx) error {
+		return tx.Bucket(documentBucket).ForEach(func(_, v []byte) error {
+			var doc internal.Document
+			if err := decode(v, &doc); err != nil {
+				return err
+			}
+			docs = append(docs, doc)
+			return nil
+		})
+	})
+	if err != nil {
+		return nil, err
+	}
+	return docs, nil
+}
+
+// walkBatch is how many documents Walk reads per transaction.
+const walkBatch = 256
+
+// Walk reads the documents in batches, each in its own read transaction, and
+// calls fn between them. A slow fn, e.g. sending to a stream client, must not
+// keep a transaction open: that blocks the writers once the file has to grow.
+func (r *Repository) Walk(ctx context.Context, fn func(internal.Document) error) error {
+	return r.WalkAfter(ctx, "", fn)
+}
+
+// WalkAfter seeks to after, so it doesn't read the documents before it.
+func (r *Repository) WalkAfter(ctx context.Context, after string, fn func(internal.Document) error) error {
+	for {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		batch, err := r.readAfter(after, walkBatch)
+		if err != nil {
+			return err
+		}
+		for _, doc := range batch {
+			if err := fn(doc); err != nil {
+				return err
+			}
+		}
+		if len(batch) < walkBatch {
+			return nil
+		}
+		after = batch[len(batch)-1].TicketID
+	}
+}
+
+// readAfter reads up to n documents following the ticket ID after, or from
+// the first one if after is empty.
+func (r *Repository) readAfter(after string, n int) ([]internal.Document, error) {
+	docs := make([]internal.Document, 0, n)
+	err := r.db.View(func(tx *bolt.Tx) error {
+		c := tx.Bucket(documentBucket).Cursor()
+		k, v := c.Seek([]byte(after))
+		if k != nil && after != "" && string(k) == after {
+			k, v = c.Next()
+		}
+		for ; k != nil && len(docs) < n; k, v = c.Next() {
+			var doc internal.Document
+			if err := decode(v, &doc); err != nil {
+				return err
+			}
+			docs = append(docs, doc)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return docs, nil
+}
+
+func put(b *bolt.Bucket, doc *internal.Document) error {
+	var buf bytes.Buffer
+	if err := gob.NewEncoder(&buf).Encode(doc); err != nil {
+		return err
+	}
+	return b.Put([]byte(doc.TicketID), buf.Bytes())
+}
+
+func decode(v []byte, doc *internal.Document) error {
+	return gob.NewDecoder(bytes.NewReader(v)).Decode(doc)
+}
+
+// CheckHealth makes sure the database can still be read.
+func (r *Repository) CheckHealth(_ context.Context) error {
+	return r.db.View(func(tx *bolt.Tx) error {
+		if tx.Bucket(documentBucket) == nil {
+			return fmt.Errorf("bucket %q is missing", documentBucket)
+		}
+		return nil
+	})
+}
@@ -0,0 +1,164 @@
+package bolt
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/wzzfarewell/go-microservice-example/internal"
+	"github.com/wzzfarewell/go-microservice-example/internal/util"
+)
+
+func newTestRepository(t *testing.T) *Repository {
+	t.Helper()
+	r, err := NewRepository(filepath.Join(t.TempDir(), "watermark.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { r.Close() })
+	return r
+}
+
+func create(t *testing.T, r *Repository, n int) {
+	t.Helper()
+	for i := 0; i < n; i++ {
+		doc := &internal.Document{TicketID: fmt.Sprintf("%06d", i), Status: internal.Pending}
+		if err := r.Create(context.Background(), doc); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestRepositoryCRUD(t *testing.T) {
+	ctx := context.Background()
+	r := newTestRepository(t)
+	doc := &internal.Document{TicketID: "t1", Status: internal.Pending, Title: "title"}
+	if err := r.Create(ctx, doc); err != nil {
+		t.Fatal(err)
+	}
+	if err := r.Create(ctx, doc); err != util.ErrDocumentExists {
+		t.Fatalf("second Create = %v, want %v", err, util.ErrDocumentExists)
+	}
+	doc.Status = internal.Started
+	if err := r.Update(ctx, doc); err != nil {
+		t.Fatal(err)
+	}
+	got, err := r.Get(ctx, "t1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if *got != *doc {
+		t.Fatalf("Get = %+v, want %+v", got, doc)
+	}
+	if _, err := r.Get(ctx, "missing"); err != util.ErrDocumentNotFound {
+		t.Fatalf("Get(missing) = %v, want %v", err, util.ErrDocumentNotFound)
+	}
+	if err := r.Update(ctx, &internal.Document{TicketID: "missing"}); err != util.ErrDocumentNotFound {
+		t.Fatalf("Update(missing) = %v, want %v", err, util.ErrDocumentNotFound)
+	}
+}
+
+func TestWalkVisitsEveryDocumentInOrder(t *testing.T) {
+	r := newTestRepository(t)
+	n := 2*walkBatch + 3
+	create(t, r, n)
+	i := 0
+	err := r.Walk(context.Background(), func(doc internal.Document) error {
+		if want := fmt.Sprintf("%06d", i); doc.TicketID != want {
+			return fmt.Errorf("document %d is %s, want %s", i, doc.TicketID, want)
+		}
+		i++
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if i != n {
+		t.Fatalf("walked %d documents, want %d", i, n)
+	}
+}
+
+func TestWalkStopsAtError(t *testing.T) {
+	r := newTestRepository(t)
+	create(t, r, walkBatch+1)
+	stop := errors.New("stop")
+	calls := 0
+	err := r.Walk(context.Background(), func(internal.Document) error {
+		calls++
+		return stop
+	})
+	if err != stop || calls != 1 {
+		t.Fatalf("Walk = %v after %d calls, want %v after 1", err, calls, stop)
+	}
+}
+
+// TestWalkDoesNotBlockWriters grows the file from within fn, which deadlocks
+// if Walk holds a read transaction while calling it. The repository is only
+// closed on success, closing it would wait for the deadlocked transaction.
+func TestWalkDoesNotBlockWriters(t *testing.T) {
+	r, err := NewRepository(filepath.Join(t.TempDir(), "watermark.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	create(t, r, walkBatch+1)
+	done := make(chan error, 1)
+	go func() {
+		first := true
+		done <- r.Walk(context.Background(), func(internal.Document) error {
+			if !first {
+				return nil
+			}
+			first = false
+			for i := 0; i < 500; i++ {
+				doc := &internal.Document{TicketID: fmt.Sprintf("big-%04d", i), Title: strings.Repeat("x", 16<<10)}
+				if err := r.Create(context.Background(), doc); err != nil {
+					return err
+				}
+			}
+			return nil
+		})
+	}()
+	select {
+	case err := <-done:
+		r.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+	case <-time.After(10 * time.Second):
+		t.Fatal("writes within Walk are blocked")
+	}
+}
+
+func TestWalkAfter(t *testing.T) {
+	r := newTestRepository(t)
+	create(t, r, walkBatch+10)
+	for _, tc := range []struct {
+		after, first string
+		n            int
+	}{
+		{"", "000000", walkBatch + 10},
+		{"000005", "000006", walkBatch + 4},
+		{"000005x", "000006", walkBatch + 4}, // after doesn't need to exist
+		{"999999", "", 0},
+	} {
+		var ids []string
+		err := r.WalkAfter(context.Background(), tc.after, func(doc internal.Document) error {
+			ids = append(ids, doc.TicketID)
+			return nil
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		first := ""
+		if len(ids) > 0 {
+			first = ids[0]
+		}
+		if len(ids) != tc.n || first != tc.first {
+			t.Errorf("WalkAfter(%q) walked %d documents from %q, want %d from %q", tc.after, len(ids), first, tc.n, tc.first)
+		}
+	}
+}
@@ -0,0 +1,194 @@
+// Package certs serves TLS with certificates that are reloaded from disk
+// while the listeners keep running, so rotating them needs no restart.
+package certs
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/go-kit/log"
+	"github.com/go-kit/log/level"
+)
+
+// Config locates the PEM files of the server certificate and, for mutual
+// TLS, of the CAs client certificates must be signed by.
+type Config struct {
+	CertFile     string
+	KeyFile      string
+	ClientCAFile string // if set, clients must present a certificate signed by it
+}
+
+// Reloader holds the TLS configuration loaded from the files of a Config.
+type Reloader struct {
+	cfg    Config
+	logger log.Logger
+
+	mtx     sync.RWMutex
+	current *tls.Config
+	stamps  map[string]stamp // of the files at the last load attempt
+}
+
+// stamp tells whether a file has been rewritten since it was read.
+type stamp struct {
+	modTime time.Time
+	size    int64
+}
+
+// NewReloader loads the files of cfg, failing if any of them is invalid.
+func NewReloader(cfg Config, logger log.Logger) (*Reloader, error) {
+	r := &Reloader{cfg: cfg, logger: logger}
+	if err := r.Reload(); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+// Reload loads the files again. The new certificates are used by the
+// following handshakes; if they are invalid the previous ones are kept.
+func (r *Reloader) Reload() error {
+	stamps := r.stat()
+	c, err := load(r.cfg)
+	r.mtx.Lock()
+	defer r.mtx.Unlock()
+	r.stamps = stamps
+	if err != nil {
+		return err
+	}
+	r.current = c
+	return nil
+}
+
+// ServerConfig returns the configuration of a listener. It is the same for
+// every connection, with the certificates loaded last; nextProtos are the
+// ALPN protocols the listener speaks. If a client CA is configured, clients
+// must present a certificate signed by it.
+func (r *Reloader) ServerConfig(nextProtos ...string) *tls.Config {
+	return r.serverConfig(tls.RequireAndVerifyClientCert, nextProtos)
+}
+
+// OptionalClientCertConfig is like ServerConfig for a listener that also
+// serves clients without a certificate, such as probes. The certificates
+// clients present are still verified; the handlers must require one where
+// it matters.
+func (r *Reloader) OptionalClientCertConfig(nextProtos ...string) *tls.Config {
+	return r.serverConfig(tls.VerifyClientCertIfGiven, nextProtos)
+}
+
+func (r *Reloader) serverConfig(clientAuth tls.ClientAuthType, nextProtos []string) *tls.Config {
+	return &tls.Config{
+		MinVersion: tls.VersionTLS12,
+		NextProtos: nextProtos,
+		GetConfigForClient: func(*tls.ClientHelloInfo) (*tls.Config, error) {
+			r.mtx.RLock()
+			c := r.current.Clone()
+			r.mtx.RUnlock()
+			c.NextProtos = nextProtos
+			if c.ClientCAs != nil {
+				c.ClientAuth = clientAuth
+			}
+			return c, nil
+		},
+	}
+}
+
+// Watch reloads the certificates whenever a signal is received on reload,
+// and whenever one of the files changes, checking them every interval unless
+// it is 0. It returns when ctx is done.
+func (r *Reloader) Watch(ctx context.Context, interval time.Duration, reload <-chan os.Signal) {
+	var tick <-chan time.Time
+	if interval > 0 {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		tick = ticker.C
+	}
+	for {
+		select {
+		case sig := <-reload:
+			r.reload("signal", sig.String())
+		case <-tick:
+			if r.changed() {
+				r.reload("file", "changed")
+			}
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+func (r *Reloader) reload(keyvals ...interface{}) {
+	logger := log.With(r.logger, keyvals...)
+	if err := r.Reload(); err != nil {
+		level.Error(logger).Log("during", "TLS reload", "err", err, "msg", "keeping the previous certificates")
+		return
+	}
+	r.mtx.RLock()
+	leaf := r.current.Certificates[0].Leaf
+	r.mtx.RUnlock()
+	level.Info(logger).Log("msg", "reloaded TLS certificates", "subject", leaf.Subject.String(), "notAfter", leaf.NotAfter)
+}
+
+// changed reports whether a file differs from the last load attempt, so a
+// broken file is reported once rather than at every check.
+func (r *Reloader) changed() bool {
+	stamps := r.stat()
+	r.mtx.RLock()
+	defer r.mtx.RUnlock()
+	if len(stamps) != len(r.stamps) {
+		return true
+	}
+	for path, s := range stamps {
+		if r.stamps[path] != s {
+			return true
+		}
+	}
+	return false
+}
+
+// stat stamps the files that exist, the missing ones fail the load anyway.
+func (r *Reloader) stat() map[string]stamp {
+	stamps := make(map[string]stamp)
+	for _, path := range []string{r.cfg.CertFile, r.cfg.KeyFile, r.cfg.ClientCAFile} {
+		if path == "" {
+			continue
+		}
+		if info, err := os.Stat(path); err == nil {
+			stamps[path] = stamp{modTime: info.ModTime(), size: info.Size()}
+		}
+	}
+	return stamps
+}
+
+// load reads the server certificate and, if configured, the client CA. How
+// client certificates are asked for depends on the listener.
+func load(cfg Config) (*tls.Config, error) {
+	cert, err := tls.LoadX509KeyPair(cfg.CertFile, cfg.KeyFile)
+	if err != nil {
+		return nil, fmt.Errorf("certs: %w", err)
+	}
+	if cert.Leaf == nil {
+		if cert.Leaf, err = x509.ParseCertificate(cert.Certificate[0]); err != nil {
+			return nil, fmt.Errorf("certs: %s: %w", cfg.CertFile, err)
+		}
+	}
+	c := &tls.Config{
+		Certificates: []tls.Certificate{cert},
+		MinVersion:   tls.VersionTLS12,
+	}
+	if cfg.ClientCAFile != "" {
+		pem, err := os.ReadFile(cfg.ClientCAFile)
+		if err != nil {
+			return nil, fmt.Errorf("certs: %w", err)
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("certs: %s: no certificates found", cfg.ClientCAFile)
+		}
+		c.ClientCAs = pool
+	}
+	return c, nil
+}
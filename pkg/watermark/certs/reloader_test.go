@@ -0,0 +1,352 @@
+package certs
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"net/url"
+	"os"
+	"path/filepath"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/go-kit/log"
+	"github.com/wzzfarewell/go-microservice-example/pkg/watermark/auth"
+)
+
+// issuer signs certificates, or itself when it is a CA made by newCA.
+type issuer struct {
+	cert *x509.Certificate
+	key  *ecdsa.PrivateKey
+	pem  []byte
+}
+
+var serial int64
+
+func newKey(t *testing.T) *ecdsa.PrivateKey {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return key
+}
+
+func newCA(t *testing.T, name string) *issuer {
+	t.Helper()
+	serial++
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(serial),
+		Subject:               pkix.Name{CommonName: name},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+		KeyUsage:              x509.KeyUsageCertSign,
+	}
+	key := newKey(t)
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &issuer{cert: cert, key: key, pem: pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})}
+}
+
+// issue returns the PEM certificate and key of a leaf named by template.
+func (ca *issuer) issue(t *testing.T, template *x509.Certificate, usage x509.ExtKeyUsage) (certPEM, keyPEM []byte) {
+	t.Helper()
+	serial++
+	template.SerialNumber = big.NewInt(serial)
+	template.NotBefore = time.Now().Add(-time.Hour)
+	template.NotAfter = time.Now().Add(time.Hour)
+	template.KeyUsage = x509.KeyUsageDigitalSignature
+	template.ExtKeyUsage = []x509.ExtKeyUsage{usage}
+	key := newKey(t)
+	der, err := x509.CreateCertificate(rand.Reader, template, ca.cert, &key.PublicKey, ca.key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}),
+		pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER})
+}
+
+func (ca *issuer) server(t *testing.T, name string) (certPEM, keyPEM []byte) {
+	return ca.issue(t, &x509.Certificate{Subject: pkix.Name{CommonName: name}, DNSNames: []string{"localhost"}}, x509.ExtKeyUsageServerAuth)
+}
+
+func (ca *issuer) client(t *testing.T, template *x509.Certificate) tls.Certificate {
+	t.Helper()
+	certPEM, keyPEM := ca.issue(t, template, x509.ExtKeyUsageClientAuth)
+	cert, err := tls.X509KeyPair(certPEM, keyPEM)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return cert
+}
+
+func writeFile(t *testing.T, path string, content []byte) {
+	t.Helper()
+	if err := os.WriteFile(path, content, 0600); err != nil {
+		t.Fatal(err)
+	}
+	// the file is stamped by modification time and size, which a quick
+	// rewrite with a key of the same size might not change
+	future := time.Now().Add(time.Duration(serial) * time.Second)
+	if err := os.Chtimes(path, future, future); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// setup writes a server certificate named name, signed by ca, and the
+// client CA to a directory, and loads them.
+func setup(t *testing.T, ca *issuer, name string, clientCA *issuer) (*Reloader, Config) {
+	t.Helper()
+	dir := t.TempDir()
+	cfg := Config{CertFile: filepath.Join(dir, "server.crt"), KeyFile: filepath.Join(dir, "server.key")}
+	certPEM, keyPEM := ca.server(t, name)
+	writeFile(t, cfg.CertFile, certPEM)
+	writeFile(t, cfg.KeyFile, keyPEM)
+	if clientCA != nil {
+		cfg.ClientCAFile = filepath.Join(dir, "ca.crt")
+		writeFile(t, cfg.ClientCAFile, clientCA.pem)
+	}
+	r, err := NewReloader(cfg, log.NewNopLogger())
+	if err != nil {
+		t.Fatal(err)
+	}
+	return r, cfg
+}
+
+type handshake struct {
+	// serverName is the common name of the certificate the server presented
+	serverName string
+	// peer is the identity of the verified client certificate, if any
+	peer string
+	err  error
+}
+
+// connect makes one TLS handshake with a listener using config, presenting
+// certs, and reports how it went on the server side.
+func connect(t *testing.T, config *tls.Config, ca *issuer, certs ...tls.Certificate) handshake {
+	t.Helper()
+	lis, err := tls.Listen("tcp", "127.0.0.1:0", config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer lis.Close()
+	result := make(chan handshake, 1)
+	go func() {
+		conn, err := lis.Accept()
+		if err != nil {
+			result <- handshake{err: err}
+			return
+		}
+		defer conn.Close()
+		tlsConn := conn.(*tls.Conn)
+		if err := tlsConn.Handshake(); err != nil {
+			result <- handshake{err: err}
+			return
+		}
+		var h handshake
+		if chains := tlsConn.ConnectionState().VerifiedChains; len(chains) > 0 {
+			h.peer = auth.PeerIdentity(chains[0][0])
+		}
+		result <- h
+	}()
+
+	roots := x509.NewCertPool()
+	roots.AddCert(ca.cert)
+	conn, err := tls.Dial("tcp", lis.Addr().String(), &tls.Config{
+		RootCAs:    roots,
+		ServerName: "localhost",
+		// the certificate is sent even if the server doesn't list its CA
+		GetClientCertificate: func(*tls.CertificateRequestInfo) (*tls.Certificate, error) {
+			if len(certs) == 0 {
+				return &tls.Certificate{}, nil
+			}
+			return &certs[0], nil
+		},
+	})
+	var serverName string
+	if err == nil {
+		serverName = conn.ConnectionState().PeerCertificates[0].Subject.CommonName
+		// with TLS 1.3 the server verifies the client certificate after
+		// the client is done, a read waits for its verdict
+		conn.Read(make([]byte, 1))
+		conn.Close()
+	}
+	h := <-result
+	h.serverName = serverName
+	return h
+}
+
+func TestMutualTLS(t *testing.T) {
+	ca, other := newCA(t, "ca"), newCA(t, "other")
+	r, _ := setup(t, ca, "server", ca)
+	valid := ca.client(t, &x509.Certificate{Subject: pkix.Name{CommonName: "client"}})
+	untrusted := other.client(t, &x509.Certificate{Subject: pkix.Name{CommonName: "client"}})
+
+	for _, tc := range []struct {
+		name     string
+		config   *tls.Config
+		certs    []tls.Certificate
+		wantPeer string
+		wantErr  bool
+	}{
+		{"required, valid", r.ServerConfig(), []tls.Certificate{valid}, "client", false},
+		{"required, none", r.ServerConfig(), nil, "", true},
+		{"required, untrusted", r.ServerConfig(), []tls.Certificate{untrusted}, "", true},
+		{"optional, valid", r.OptionalClientCertConfig(), []tls.Certificate{valid}, "client", false},
+		{"optional, none", r.OptionalClientCertConfig(), nil, "", false},
+		{"optional, untrusted", r.OptionalClientCertConfig(), []tls.Certificate{untrusted}, "", true},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			h := connect(t, tc.config, ca, tc.certs...)
+			if (h.err != nil) != tc.wantErr {
+				t.Fatalf("handshake error %v, want error %v", h.err, tc.wantErr)
+			}
+			if h.peer != tc.wantPeer {
+				t.Fatalf("peer %q, want %q", h.peer, tc.wantPeer)
+			}
+		})
+	}
+}
+
+func TestWithoutClientCA(t *testing.T) {
+	ca := newCA(t, "ca")
+	r, _ := setup(t, ca, "server", nil)
+	h := connect(t, r.ServerConfig(), ca)
+	if h.err != nil || h.serverName != "server" {
+		t.Fatalf("handshake with %q: %v", h.serverName, h.err)
+	}
+}
+
+func TestPeerIdentity(t *testing.T) {
+	ca := newCA(t, "ca")
+	r, _ := setup(t, ca, "server", ca)
+	spiffe, _ := url.Parse("spiffe://example.org/svc")
+	for _, tc := range []struct {
+		name     string
+		template *x509.Certificate
+		want     string
+	}{
+		{"URI SAN first", &x509.Certificate{Subject: pkix.Name{CommonName: "cn"}, DNSNames: []string{"svc.example.org"}, URIs: []*url.URL{spiffe}}, "spiffe://example.org/svc"},
+		{"then DNS SAN", &x509.Certificate{Subject: pkix.Name{CommonName: "cn"}, DNSNames: []string{"svc.example.org", "other.example.org"}}, "svc.example.org"},
+		{"then common name", &x509.Certificate{Subject: pkix.Name{CommonName: "cn"}}, "cn"},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			h := connect(t, r.ServerConfig(), ca, ca.client(t, tc.template))
+			if h.err != nil {
+				t.Fatal(h.err)
+			}
+			if h.peer != tc.want {
+				t.Fatalf("identity %q, want %q", h.peer, tc.want)
+			}
+		})
+	}
+}
+
+// rotate rewrites the server certificate of cfg with one named name.
+func rotate(t *testing.T, cfg Config, ca *issuer, name string) {
+	t.Helper()
+	certPEM, keyPEM := ca.server(t, name)
+	writeFile(t, cfg.CertFile, certPEM)
+	writeFile(t, cfg.KeyFile, keyPEM)
+}
+
+// waitForServer makes handshakes until the server presents the certificate
+// named name.
+func waitForServer(t *testing.T, r *Reloader, ca *issuer, name string) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		h := connect(t, r.ServerConfig(), ca)
+		if h.err != nil {
+			t.Fatal(h.err)
+		}
+		if h.serverName == name {
+			return
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("server still presents %q, want %q", h.serverName, name)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestReloadWhenFilesChange(t *testing.T) {
+	ca := newCA(t, "ca")
+	r, cfg := setup(t, ca, "first", nil)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go r.Watch(ctx, 10*time.Millisecond, nil)
+
+	waitForServer(t, r, ca, "first")
+	rotate(t, cfg, ca, "second")
+	waitForServer(t, r, ca, "second")
+}
+
+func TestReloadOnSignal(t *testing.T) {
+	ca := newCA(t, "ca")
+	r, cfg := setup(t, ca, "first", nil)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	hup := make(chan os.Signal, 1)
+	// the files are not checked without an interval
+	go r.Watch(ctx, 0, hup)
+
+	rotate(t, cfg, ca, "second")
+	if h := connect(t, r.ServerConfig(), ca); h.serverName != "first" {
+		t.Fatalf("reloaded %q without a signal", h.serverName)
+	}
+	hup <- syscall.SIGHUP
+	waitForServer(t, r, ca, "second")
+}
+
+func TestReloadKeepsValidCertificates(t *testing.T) {
+	ca := newCA(t, "ca")
+	r, cfg := setup(t, ca, "first", nil)
+	writeFile(t, cfg.CertFile, []byte("not a certificate"))
+	if err := r.Reload(); err == nil {
+		t.Fatal("no error loading a broken certificate")
+	}
+	if h := connect(t, r.ServerConfig(), ca); h.err != nil || h.serverName != "first" {
+		t.Fatalf("handshake with %q: %v", h.serverName, h.err)
+	}
+	// a broken file is reported once, not at every check
+	if r.changed() {
+		t.Fatal("broken file still reported as changed")
+	}
+}
+
+func TestNewReloaderErrors(t *testing.T) {
+	ca := newCA(t, "ca")
+	_, cfg := setup(t, ca, "server", nil)
+	for name, modify := range map[string]func(*Config){
+		"missing cert":      func(c *Config) { c.CertFile = filepath.Join(t.TempDir(), "missing") },
+		"mismatched key":    func(c *Config) { c.KeyFile = c.CertFile },
+		"missing client CA": func(c *Config) { c.ClientCAFile = filepath.Join(t.TempDir(), "missing") },
+		"empty client CA":   func(c *Config) { c.ClientCAFile = c.KeyFile },
+	} {
+		c := cfg
+		modify(&c)
+		if _, err := NewReloader(c, log.NewNopLogger()); err == nil {
+			t.Errorf("%s: no error", name)
+		}
+	}
+}
@@ -0,0 +1,155 @@
+package watermark
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"hash/crc32"
+	"strings"
+	"unicode/utf8"
+)
+
+// payloadMagic starts every hidden payload, so random data is not mistaken
+// for a watermark.
+var payloadMagic = []byte("WMK1")
+
+var errNoPayload = errors.New("no watermark found")
+
+// Embedder hides a payload in document content without visibly changing it,
+// and recovers it from a copy of the content.
+type Embedder interface {
+	Embed(content, payload []byte) ([]byte, error)
+	Extract(content []byte) ([]byte, error)
+}
+
+// Embedders selects the Embedder for a document by its content type.
+type Embedders map[string]Embedder
+
+// DefaultEmbedders returns an Embedders handling plain text and PNG. JPEG is
+// left out on purpose: its lossy compression destroys the hidden bits.
+func DefaultEmbedders() Embedders {
+	return Embedders{
+		ContentTypeText: TextEmbedder{},
+		ContentTypePNG:  PNGEmbedder{},
+	}
+}
+
+// For returns the Embedder for contentType, if there is one.
+func (e Embedders) For(contentType string) (Embedder, bool) {
+	mediaType, err := normalizeContentType(contentType)
+	if err != nil {
+		return nil, false
+	}
+	embedder, ok := e[mediaType]
+	return embedder, ok
+}
+
+// encodePayload frames the ticket ID and mark as magic, length, data and a
+// CRC32 of the data.
+func encodePayload(ticketID, mark string) []byte {
+	data := []byte(ticketID + "\x00" + mark)
+	b := make([]byte, 0, len(payloadMagic)+2+len(data)+4)
+	b = append(b, payloadMagic...)
+	b = binary.BigEndian.AppendUint16(b, uint16(len(data)))
+	b = append(b, data...)
+	return binary.BigEndian.AppendUint32(b, crc32.ChecksumIEEE(data))
+}
+
+func decodePayload(b []byte) (string, string, error) {
+	if !bytes.HasPrefix(b, payloadMagic) || len(b) < len(payloadMagic)+2 {
+		return "", "", errNoPayload
+	}
+	b = b[len(payloadMagic):]
+	n := int(binary.BigEndian.Uint16(b))
+	b = b[2:]
+	if len(b) < n+4 {
+		return "", "", errNoPayload
+	}
+	data := b[:n]
+	if crc32.ChecksumIEEE(data) != binary.BigEndian.Uint32(b[n:]) {
+		return "", "", errNoPayload
+	}
+	ticketID, mark, ok := strings.Cut(string(data), "\x00")
+	if !ok {
+		return "", "", errNoPayload
+	}
+	return ticketID, mark, nil
+}
+
+const (
+	zeroWidthFrame = '\u2060' // word joiner, starts and ends a payload
+	zeroWidthZero  = '\u200b' // zero width space
+	zeroWidthOne   = '\u200c' // zero width non-joiner
+)
+
+// TextEmbedder hides the payload as a run of zero-width characters, once
+// after the first character of the text and once at its end, so a copy of
+// either part still carries it.
+type TextEmbedder struct{}
+
+func (TextEmbedder) Embed(content, payload []byte) ([]byte, error) {
+	var hidden strings.Builder
+	hidden.WriteRune(zeroWidthFrame)
+	for _, c := range payload {
+		for i := 7; i >= 0; i-- {
+			if c&(1<<i) == 0 {
+				hidden.WriteRune(zeroWidthZero)
+			} else {
+				hidden.WriteRune(zeroWidthOne)
+			}
+		}
+	}
+	hidden.WriteRune(zeroWidthFrame)
+
+	_, first := utf8.DecodeRune(content)
+	var out bytes.Buffer
+	out.Write(content[:first])
+	out.WriteString(hidden.String())
+	out.Write(content[first:])
+	out.WriteString(hidden.String())
+	return out.Bytes(), nil
+}
+
+func (TextEmbedder) Extract(content []byte) ([]byte, error) {
+	text := string(content)
+	for {
+		start := strings.IndexRune(text, zeroWidthFrame)
+		if start < 0 {
+			return nil, errNoPayload
+		}
+		text = text[start+utf8.RuneLen(zeroWidthFrame):]
+		end := strings.IndexRune(text, zeroWidthFrame)
+		if end < 0 {
+			return nil, errNoPayload
+		}
+		if payload, ok := zeroWidthBits(text[:end]); ok {
+			if _, _, err := decodePayload(payload); err == nil {
+				return payload, nil
+			}
+		}
+		// not a payload, the closing frame may open the next one
+	}
+}
+
+func zeroWidthBits(s string) ([]byte, bool) {
+	var (
+		payload []byte
+		c       byte
+		n       int
+	)
+	for _, r := range s {
+		switch r {
+		case zeroWidthZero:
+			c <<= 1
+		case zeroWidthOne:
+			c = c<<1 | 1
+		default:
+			return nil, false
+		}
+		if n++; n%8 == 0 {
+			payload = append(payload, c)
+			c = 0
+		}
+	}
+	return payload, n > 0 && n%8 == 0
+}
@@ -0,0 +1,102 @@
+package watermark
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"image"
+	"image/draw"
+	"image/png"
+
+	"github.com/wzzfarewell/go-microservice-example/internal/util"
+)
+
+// PNGEmbedder hides the payload in the least significant bit of the red,
+// green and blue channels of the pixels, preceded by its length as a 32-bit
+// integer.
+type PNGEmbedder struct{}
+
+func (PNGEmbedder) Embed(content, payload []byte) ([]byte, error) {
+	if err := checkImageSize(content); err != nil {
+		return nil, err
+	}
+	src, err := png.Decode(bytes.NewReader(content))
+	if err != nil {
+		return nil, err
+	}
+	img := toNRGBA(src)
+
+	data := binary.BigEndian.AppendUint32(nil, uint32(len(payload)))
+	data = append(data, payload...)
+	if capacity := lsbCapacity(img); len(data)*8 > capacity {
+		return nil, fmt.Errorf("%w: image too small for the watermark", util.ErrInvalidArgument)
+	}
+	bit := 0
+	for i := range img.Pix {
+		if i%4 == 3 {
+			continue // leave alpha alone
+		}
+		if bit == len(data)*8 {
+			break
+		}
+		b := data[bit/8] >> (7 - bit%8) & 1
+		img.Pix[i] = img.Pix[i]&^1 | b
+		bit++
+	}
+
+	var out bytes.Buffer
+	if err := png.Encode(&out, img); err != nil {
+		return nil, err
+	}
+	return out.Bytes(), nil
+}
+
+func (PNGEmbedder) Extract(content []byte) ([]byte, error) {
+	if err := checkImageSize(content); err != nil {
+		return nil, err
+	}
+	src, err := png.Decode(bytes.NewReader(content))
+	if err != nil {
+		return nil, err
+	}
+	img := toNRGBA(src)
+
+	// read the bits back from every channel but alpha, in order
+	next := 0
+	read := func(n int) []byte {
+		b := make([]byte, n)
+		for i := 0; i < n*8; i++ {
+			for next%4 == 3 {
+				next++
+			}
+			b[i/8] = b[i/8]<<1 | img.Pix[next]&1
+			next++
+		}
+		return b
+	}
+	capacity := lsbCapacity(img)
+	if capacity < 32 {
+		return nil, errNoPayload
+	}
+	n := int(binary.BigEndian.Uint32(read(4)))
+	if n == 0 || n > 1<<16 || n*8 > capacity-32 {
+		return nil, errNoPayload
+	}
+	return read(n), nil
+}
+
+func lsbCapacity(img *image.NRGBA) int {
+	return len(img.Pix) / 4 * 3
+}
+
+// toNRGBA returns img as non-premultiplied RGBA. An *image.NRGBA is used as
+// is, because converting it would lose the color of transparent pixels.
+func toNRGBA(img image.Image) *image.NRGBA {
+	if n, ok := img.(*image.NRGBA); ok && n.Rect.Min == (image.Point{}) && n.Stride == 4*n.Rect.Dx() {
+		return n
+	}
+	b := img.Bounds()
+	n := image.NewNRGBA(image.Rect(0, 0, b.Dx(), b.Dy()))
+	draw.Draw(n, n.Bounds(), img, b.Min, draw.Src)
+	return n
+}
@@ -0,0 +1,98 @@
+package watermark
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+	"unicode/utf8"
+)
+
+func TestTextEmbedderRoundTrip(t *testing.T) {
+	payload := encodePayload("t1", "confidential")
+	for _, tc := range []struct {
+		name    string
+		content string
+		// copy keeps the part of the embedded text a reader copied
+		copy func(embedded string) string
+	}{
+		{"whole text", "some text\nover two lines\n", nil},
+		{"multi-byte first character", "été", nil},
+		{"single character", "x", nil},
+		{"empty", "", nil},
+		{"start only", "some text", func(s string) string { return s[:strings.Index(s, "text")] }},
+		{"end only", "some text", func(s string) string { return s[strings.Index(s, "text"):] }},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			embedded, err := TextEmbedder{}.Embed([]byte(tc.content), payload)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !utf8.Valid(embedded) {
+				t.Fatal("embedded text isn't valid UTF-8")
+			}
+			if visible := stripZeroWidth(string(embedded)); visible != tc.content {
+				t.Fatalf("visible text %q, want %q", visible, tc.content)
+			}
+			copied := string(embedded)
+			if tc.copy != nil {
+				copied = tc.copy(copied)
+			}
+			got, err := TextEmbedder{}.Extract([]byte(copied))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(got, payload) {
+				t.Fatalf("got %q, want %q", got, payload)
+			}
+		})
+	}
+}
+
+func TestTextEmbedderNoWatermark(t *testing.T) {
+	// a payload whose checksum doesn't match
+	payload := encodePayload("t1", "mark")
+	payload[len(payload)-1] ^= 1
+	corrupted, err := TextEmbedder{}.Embed([]byte("text"), payload)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, tc := range []struct {
+		name, content string
+	}{
+		{"plain text", "no watermark here\n"},
+		{"empty", ""},
+		{"unclosed frame", "a\u2060\u200b\u200c"},
+		{"other characters in the frame", "a\u2060\u200bx\u200c\u200b\u200c\u200b\u200c\u200b\u2060"},
+		{"bits without the magic", "a\u2060\u200b\u200b\u200b\u200b\u200b\u200b\u200b\u200b\u2060"},
+		{"corrupted", string(corrupted)},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			if _, err := (TextEmbedder{}).Extract([]byte(tc.content)); err != errNoPayload {
+				t.Fatalf("got %v, want %v", err, errNoPayload)
+			}
+		})
+	}
+}
+
+func TestDecodePayload(t *testing.T) {
+	ticketID, mark, err := decodePayload(encodePayload("t1", "a mark"))
+	if err != nil || ticketID != "t1" || mark != "a mark" {
+		t.Fatalf("got %q, %q, %v", ticketID, mark, err)
+	}
+	for _, b := range [][]byte{nil, payloadMagic, []byte("WMK1\x00\x10short")} {
+		if _, _, err := decodePayload(b); err != errNoPayload {
+			t.Errorf("decodePayload(%q) = %v, want %v", b, err, errNoPayload)
+		}
+	}
+}
+
+func stripZeroWidth(s string) string {
+	return strings.Map(func(r rune) rune {
+		switch r {
+		case zeroWidthFrame, zeroWidthZero, zeroWidthOne:
+			return -1
+		}
+		return r
+	}, s)
+}
@@ -0,0 +1,69 @@
+package endpoint
+
+import (
+	"context"
+
+	"github.com/go-kit/kit/endpoint"
+	"github.com/wzzfarewell/go-microservice-example/internal"
+	"github.com/wzzfarewell/go-microservice-example/pkg/watermark"
+	"github.com/wzzfarewell/go-microservice-example/pkg/watermark/auth"
+)
+
+// AuthenticationMiddleware rejects calls without valid credentials in the
+// context, and puts the authenticated principal there for the others.
+func AuthenticationMiddleware(authn *auth.Authenticator) endpoint.Middleware {
+	return func(next endpoint.Endpoint) endpoint.Endpoint {
+		return func(ctx context.Context, request interface{}) (interface{}, error) {
+			ctx, err := authenticate(ctx, authn)
+			if err != nil {
+				return nil, err
+			}
+			return next(ctx, request)
+		}
+	}
+}
+
+func authenticate(ctx context.Context, authn *auth.Authenticator) (context.Context, error) {
+	// without credentials the client certificate may authenticate the caller
+	creds, _ := auth.CredentialsFromContext(ctx)
+	p, err := authn.Authenticate(ctx, creds)
+	if err != nil {
+		return ctx, err
+	}
+	return auth.ContextWithPrincipal(ctx, p), nil
+}
+
+type authenticatingStreamer struct {
+	next  watermark.Streamer
+	authn *auth.Authenticator
+}
+
+// NewAuthenticatingStreamer authenticates the calls of the streams, which
+// are served without endpoints, like AuthenticationMiddleware does.
+func NewAuthenticatingStreamer(next watermark.Streamer, authn *auth.Authenticator) watermark.Streamer {
+	return &authenticatingStreamer{next: next, authn: authn}
+}
+
+func (s *authenticatingStreamer) WatchStatus(ctx context.Context, ticketID string) (<-chan internal.Status, error) {
+	ctx, err := authenticate(ctx, s.authn)
+	if err != nil {
+		return nil, err
+	}
+	return s.next.WatchStatus(ctx, ticketID)
+}
+
+func (s *authenticatingStreamer) FindStream(ctx context.Context, send func(internal.Document) error, filters ...internal.Filter) error {
+	ctx, err := authenticate(ctx, s.authn)
+	if err != nil {
+		return err
+	}
+	return s.next.FindStream(ctx, send, filters...)
+}
+
+func (s *authenticatingStreamer) OpenContent(ctx context.Context, ticketID string, watermarked bool) (*watermark.Content, error) {
+	ctx, err := authenticate(ctx, s.authn)
+	if err != nil {
+		return nil, err
+	}
+	return s.next.OpenContent(ctx, ticketID, watermarked)
+}
@@ -1,47 +1,108 @@
 package endpoint
 
 import (
+	"bytes"
 	"context"
-	"os"
+	"io"
 
 	"github.com/go-kit/kit/endpoint"
-	"github.com/go-kit/kit/log"
+	"github.com/go-kit/log"
+	"github.com/wzzfarewell/go-microservice-example/internal"
 	"github.com/wzzfarewell/go-microservice-example/pkg/watermark"
+	"github.com/wzzfarewell/go-microservice-example/pkg/watermark/auth"
+	wmerrors "github.com/wzzfarewell/go-microservice-example/pkg/watermark/errors"
+	"go.opentelemetry.io/otel/trace"
 )
 
-var logger log.Logger
-
-func init() {
-	logger = log.NewLogfmtLogger(log.NewSyncWriter(os.Stderr))
-	logger = log.With(logger, "ts", log.DefaultTimestampUTC)
-}
-
 type Set struct {
 	FindEndpoint           endpoint.Endpoint
 	CreateDocumentEndpoint endpoint.Endpoint
 	StatusEndpoint         endpoint.Endpoint
 	ServiceStatusEndpoint  endpoint.Endpoint
 	WatermarkEndpoint      endpoint.Endpoint
+	ExtractEndpoint        endpoint.Endpoint
+}
+
+// Option configures the middlewares NewEndpointSet wraps the endpoints with.
+type Option func(*options)
+
+type options struct {
+	idempotency IdempotencyStore
+	metrics     *Metrics
+	tracer      trace.Tracer
+	logger      log.Logger
+	authn       *auth.Authenticator
+}
+
+// WithIdempotency makes CreateDocument and Watermark honour idempotency keys.
+func WithIdempotency(store IdempotencyStore) Option {
+	return func(o *options) { o.idempotency = store }
+}
+
+// WithMetrics instruments every endpoint with m.
+func WithMetrics(m Metrics) Option {
+	return func(o *options) { o.metrics = &m }
+}
+
+// WithTracer traces every endpoint with tracer.
+func WithTracer(tracer trace.Tracer) Option {
+	return func(o *options) { o.tracer = tracer }
+}
+
+// WithLogger logs every call of an endpoint to logger.
+func WithLogger(logger log.Logger) Option {
+	return func(o *options) { o.logger = logger }
+}
+
+// WithAuthentication requires every call to carry credentials authn accepts.
+func WithAuthentication(authn *auth.Authenticator) Option {
+	return func(o *options) { o.authn = authn }
 }
 
-func NewEndpointSet(svc watermark.Service) Set {
+func NewEndpointSet(svc watermark.Service, opts ...Option) Set {
+	var o options
+	for _, opt := range opts {
+		opt(&o)
+	}
+	wrap := func(method string, e endpoint.Endpoint) endpoint.Endpoint {
+		if o.idempotency != nil && (method == "CreateDocument" || method == "Watermark") {
+			e = Idempotent(o.idempotency, method)(e)
+		}
+		// idempotency keys are scoped by the principal it puts in the context
+		if o.authn != nil {
+			e = AuthenticationMiddleware(o.authn)(e)
+		}
+		if o.metrics != nil {
+			e = InstrumentingMiddleware(*o.metrics, method)(e)
+		}
+		// inside the span, so the lines carry its trace ID
+		if o.logger != nil {
+			e = LoggingMiddleware(o.logger, method)(e)
+		}
+		if o.tracer != nil {
+			e = TracingMiddleware(o.tracer, method)(e)
+		}
+		return e
+	}
 	return Set{
-		FindEndpoint:           MakeFindEndpoint(svc),
-		CreateDocumentEndpoint: MakeCreateDocumentEndpoint(svc),
-		StatusEndpoint:         MakeStatusEndpoint(svc),
-		ServiceStatusEndpoint:  MakeServiceStatusEndpoint(svc),
-		WatermarkEndpoint:      MakeWatermarkEndpoint(svc),
+		FindEndpoint:           wrap("Find", MakeFindEndpoint(svc)),
+		CreateDocumentEndpoint: wrap("CreateDocument", MakeCreateDocumentEndpoint(svc)),
+		StatusEndpoint:         wrap("Status", MakeStatusEndpoint(svc)),
+		ServiceStatusEndpoint:  wrap("ServiceStatus", MakeServiceStatusEndpoint(svc)),
+		WatermarkEndpoint:      wrap("Watermark", MakeWatermarkEndpoint(svc)),
+		ExtractEndpoint:        wrap("Extract", MakeExtractEndpoint(svc)),
 	}
 }
 
 func MakeFindEndpoint(svc watermark.Service) endpoint.Endpoint {
 	return func(ctx context.Context, request interface{}) (interface{}, error) {
 		req := request.(FindRequest)
-		docs, err := svc.Find(ctx, req.Filters...)
+		page := internal.Page{Size: req.PageSize, Token: req.PageToken}
+		docs, next, err := svc.Find(ctx, page, req.Filters...)
 		if err != nil {
-			return FindResponse{docs, err.Error()}, nil
+			return nil, err
 		}
-		return FindResponse{docs, ""}, nil
+		return FindResponse{Documents: docs, NextPageToken: next}, nil
 	}
 }
 
@@ -50,20 +111,24 @@ func MakeStatusEndpoint(svc watermark.Service) endpoint.Endpoint {
 		req := request.(StatusRequest)
 		status, err := svc.Status(ctx, req.TicketID)
 		if err != nil {
-			return StatusResponse{Status: status, Err: err.Error()}, nil
+			return nil, err
 		}
-		return StatusResponse{Status: status, Err: ""}, nil
+		return StatusResponse{Status: status}, nil
 	}
 }
 
 func MakeCreateDocumentEndpoint(svc watermark.Service) endpoint.Endpoint {
 	return func(ctx context.Context, request interface{}) (interface{}, error) {
 		req := request.(CreateDocumentRequest)
-		ticketID, err := svc.CreateDocument(ctx, req.Document)
+		var content io.Reader = bytes.NewReader(req.Content)
+		if req.Upload != nil {
+			content = req.Upload.Reader()
+		}
+		ticketID, err := svc.CreateDocument(ctx, req.Document, content)
 		if err != nil {
-			return CreateDocumentResponse{TicketID: ticketID, Err: err.Error()}, nil
+			return nil, err
 		}
-		return CreateDocumentResponse{TicketID: ticketID, Err: ""}, nil
+		return CreateDocumentResponse{TicketID: ticketID}, nil
 	}
 }
 
@@ -72,9 +137,9 @@ func MakeWatermarkEndpoint(svc watermark.Service) endpoint.Endpoint {
 		req := request.(WatermarkRequest)
 		code, err := svc.Watermark(ctx, req.TicketID, req.Mark)
 		if err != nil {
-			return WatermarkResponse{Code: code, Err: err.Error()}, nil
+			return nil, err
 		}
-		return WatermarkResponse{Code: code, Err: ""}, nil
+		return WatermarkResponse{Code: code}, nil
 	}
 }
 
@@ -83,68 +148,76 @@ func MakeServiceStatusEndpoint(svc watermark.Service) endpoint.Endpoint {
 		_ = request.(ServiceStatusRequest)
 		code, err := svc.ServiceStatus(ctx)
 		if err != nil {
-			return ServiceStatusResponse{Code: code, Err: err.Error()}, nil
+			return nil, err
 		}
-		return ServiceStatusResponse{Code: code, Err: ""}, nil
-	}
-}
-
-// func (s *Set) Find(ctx context.Context, filters ...internal.Filter) ([]internal.Document, error) {
-// 	resp, err := s.FindEndpoint(ctx, FindRequest{Filters: filters})
-// 	if err != nil {
-// 		return []internal.Document{}, err
-// 	}
-// 	findResp := resp.(FindResponse)
-// 	if findResp.Err != "" {
-// 		return []internal.Document{}, errors.New(findResp.Err)
-// 	}
-// 	return findResp.Documents, nil
-// }
-
-// func (s *Set) ServiceStatus(ctx context.Context) (int, error) {
-// 	resp, err := s.ServiceStatusEndpoint(ctx, ServiceStatusRequest{})
-// 	svcStatusResp := resp.(ServiceStatusResponse)
-// 	if err != nil {
-// 		return svcStatusResp.Code, err
-// 	}
-// 	if svcStatusResp.Err != "" {
-// 		return svcStatusResp.Code, errors.New(svcStatusResp.Err)
-// 	}
-// 	return svcStatusResp.Code, nil
-// }
-
-// func (s *Set) CreateDocument(ctx context.Context, doc *internal.Document) (string, error) {
-// 	resp, err := s.CreateDocumentEndpoint(ctx, CreateDocumentRequest{Document: doc})
-// 	if err != nil {
-// 		return "", err
-// 	}
-// 	adResp := resp.(CreateDocumentResponse)
-// 	if adResp.Err != "" {
-// 		return "", errors.New(adResp.Err)
-// 	}
-// 	return adResp.TicketID, nil
-// }
-
-// func (s *Set) Status(ctx context.Context, ticketID string) (internal.Status, error) {
-// 	resp, err := s.StatusEndpoint(ctx, StatusRequest{TicketID: ticketID})
-// 	if err != nil {
-// 		return internal.Failed, err
-// 	}
-// 	stsResp := resp.(StatusResponse)
-// 	if stsResp.Err != "" {
-// 		return internal.Failed, errors.New(stsResp.Err)
-// 	}
-// 	return stsResp.Status, nil
-// }
-
-// func (s *Set) Watermark(ctx context.Context, ticketID, mark string) (int, error) {
-// 	resp, err := s.WatermarkEndpoint(ctx, WatermarkRequest{TicketID: ticketID, Mark: mark})
-// 	wmResp := resp.(WatermarkResponse)
-// 	if err != nil {
-// 		return wmResp.Code, err
-// 	}
-// 	if wmResp.Err != "" {
-// 		return wmResp.Code, errors.New(wmResp.Err)
-// 	}
-// 	return wmResp.Code, nil
-// }
+		return ServiceStatusResponse{Code: code}, nil
+	}
+}
+
+func MakeExtractEndpoint(svc watermark.Service) endpoint.Endpoint {
+	return func(ctx context.Context, request interface{}) (interface{}, error) {
+		req := request.(ExtractRequest)
+		ticketID, mark, err := svc.Extract(ctx, req.Content)
+		if err != nil {
+			return nil, err
+		}
+		return ExtractResponse{TicketID: ticketID, Mark: mark}, nil
+	}
+}
+
+// Find, Status, Watermark, CreateDocument, ServiceStatus and Extract make Set a
+// watermark.Service, so a Set built from client endpoints can be used
+// anywhere the service is expected.
+func (s *Set) Find(ctx context.Context, page internal.Page, filters ...internal.Filter) ([]internal.Document, string, error) {
+	resp, err := s.FindEndpoint(ctx, FindRequest{Filters: filters, PageSize: page.Size, PageToken: page.Token})
+	if err != nil {
+		return []internal.Document{}, "", err
+	}
+	findResp := resp.(FindResponse)
+	return findResp.Documents, findResp.NextPageToken, nil
+}
+
+func (s *Set) ServiceStatus(ctx context.Context) (int, error) {
+	resp, err := s.ServiceStatusEndpoint(ctx, ServiceStatusRequest{})
+	if err != nil {
+		return wmerrors.HTTPStatus(err), err
+	}
+	return resp.(ServiceStatusResponse).Code, nil
+}
+
+func (s *Set) CreateDocument(ctx context.Context, doc *internal.Document, content io.Reader) (string, error) {
+	b, err := io.ReadAll(content)
+	if err != nil {
+		return "", err
+	}
+	resp, err := s.CreateDocumentEndpoint(ctx, CreateDocumentRequest{Document: doc, Content: b})
+	if err != nil {
+		return "", err
+	}
+	return resp.(CreateDocumentResponse).TicketID, nil
+}
+
+func (s *Set) Status(ctx context.Context, ticketID string) (internal.Status, error) {
+	resp, err := s.StatusEndpoint(ctx, StatusRequest{TicketID: ticketID})
+	if err != nil {
+		return internal.Failed, err
+	}
+	return resp.(StatusResponse).Status, nil
+}
+
+func (s *Set) Watermark(ctx context.Context, ticketID, mark string) (int, error) {
+	resp, err := s.WatermarkEndpoint(ctx, WatermarkRequest{TicketID: ticketID, Mark: mark})
+	if err != nil {
+		return wmerrors.HTTPStatus(err), err
+	}
+	return resp.(WatermarkResponse).Code, nil
+}
+
+func (s *Set) Extract(ctx context.Context, content []byte) (string, string, error) {
+	resp, err := s.ExtractEndpoint(ctx, ExtractRequest{Content: content})
+	if err != nil {
+		return "", "", err
+	}
+	extractResp := resp.(ExtractResponse)
+	return extractResp.TicketID, extractResp.Mark, nil
+}
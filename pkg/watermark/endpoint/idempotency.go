@@ -0,0 +1,187 @@
+package endpoint
+
+import (
+	"container/list"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"sync"
+	"time"
+
+	"github.com/go-kit/kit/endpoint"
+	"github.com/wzzfarewell/go-microservice-example/pkg/watermark/auth"
+	wmerrors "github.com/wzzfarewell/go-microservice-example/pkg/watermark/errors"
+)
+
+// IdempotencyKeyHeader is the HTTP header, and in lower case the gRPC
+// metadata key, a client sets to make retrying a request safe.
+const IdempotencyKeyHeader = "Idempotency-Key"
+
+type idempotencyKeyContextKey struct{}
+
+// ContextWithIdempotencyKey returns a copy of ctx carrying key. The transports
+// set it from the incoming request, and the clients send it along.
+func ContextWithIdempotencyKey(ctx context.Context, key string) context.Context {
+	if key == "" {
+		return ctx
+	}
+	return context.WithValue(ctx, idempotencyKeyContextKey{}, key)
+}
+
+// IdempotencyKeyFromContext returns the idempotency key carried by ctx, if any.
+func IdempotencyKeyFromContext(ctx context.Context) string {
+	key, _ := ctx.Value(idempotencyKeyContextKey{}).(string)
+	return key
+}
+
+// IdempotencyStore remembers the response sent for every idempotency key.
+type IdempotencyStore interface {
+	// Reserve claims key for a request with the given fingerprint. It
+	// returns the response that was saved for key, or nil if the caller
+	// claimed it and must call either Save or Release. It fails if key was
+	// used for a request with another fingerprint or is still in flight.
+	Reserve(key, fingerprint string) (response interface{}, err error)
+
+	Save(key string, response interface{})
+	Release(key string)
+}
+
+type idempotencyEntry struct {
+	key         string
+	fingerprint string
+	response    interface{}
+	expires     time.Time
+	// saved is the element of the entry in idempotencyStore.saved, nil
+	// while the request is in flight.
+	saved *list.Element
+}
+
+type idempotencyStore struct {
+	ttl        time.Duration
+	maxEntries int
+	now        func() time.Time
+
+	mtx     sync.Mutex
+	entries map[string]*idempotencyEntry
+	// saved lists the entries with a response in the order they were
+	// saved, which all having the same TTL is the order they expire in.
+	saved *list.List
+}
+
+// NewIdempotencyStore returns an in-memory IdempotencyStore that forgets a
+// key ttl after its response was saved. It holds at most maxEntries keys,
+// forgetting the oldest saved ones early to make room for new ones.
+func NewIdempotencyStore(ttl time.Duration, maxEntries int) IdempotencyStore {
+	return &idempotencyStore{
+		ttl:        ttl,
+		maxEntries: maxEntries,
+		now:        time.Now,
+		entries:    make(map[string]*idempotencyEntry),
+		saved:      list.New(),
+	}
+}
+
+func (s *idempotencyStore) Reserve(key, fingerprint string) (interface{}, error) {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+	s.expire(s.now())
+	entry, ok := s.entries[key]
+	if !ok {
+		if len(s.entries) >= s.maxEntries {
+			if s.saved.Len() == 0 {
+				return nil, &wmerrors.Unavailable{Message: "too many requests with an idempotency key in progress"}
+			}
+			s.remove(s.saved.Front().Value.(*idempotencyEntry))
+		}
+		s.entries[key] = &idempotencyEntry{key: key, fingerprint: fingerprint}
+		return nil, nil
+	}
+	if entry.fingerprint != fingerprint {
+		return nil, &wmerrors.Unprocessable{Message: "idempotency key reused with a different request"}
+	}
+	if entry.response == nil {
+		return nil, &wmerrors.Conflict{Message: "a request with this idempotency key is in progress"}
+	}
+	return entry.response, nil
+}
+
+func (s *idempotencyStore) Save(key string, response interface{}) {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+	if entry, ok := s.entries[key]; ok && entry.saved == nil {
+		entry.response = response
+		entry.expires = s.now().Add(s.ttl)
+		entry.saved = s.saved.PushBack(entry)
+	}
+}
+
+func (s *idempotencyStore) Release(key string) {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+	if entry, ok := s.entries[key]; ok {
+		s.remove(entry)
+	}
+}
+
+// expire drops the entries whose TTL is over, which are at the front of the
+// saved list. Entries still in flight have no expiry yet.
+func (s *idempotencyStore) expire(now time.Time) {
+	for e := s.saved.Front(); e != nil; e = s.saved.Front() {
+		entry := e.Value.(*idempotencyEntry)
+		if !now.After(entry.expires) {
+			return
+		}
+		s.remove(entry)
+	}
+}
+
+func (s *idempotencyStore) remove(entry *idempotencyEntry) {
+	if entry.saved != nil {
+		s.saved.Remove(entry.saved)
+	}
+	delete(s.entries, entry.key)
+}
+
+// Idempotent makes the endpoint return the original response when a request
+// is retried with the same idempotency key, instead of running it again.
+// Failed requests are not remembered, so they can be retried. Keys are scoped
+// by name so the same key can be used on different endpoints, and by the
+// authenticated principal, if any, so callers can't replay each other's.
+func Idempotent(store IdempotencyStore, name string) endpoint.Middleware {
+	return func(next endpoint.Endpoint) endpoint.Endpoint {
+		return func(ctx context.Context, request interface{}) (interface{}, error) {
+			key := IdempotencyKeyFromContext(ctx)
+			if key == "" {
+				return next(ctx, request)
+			}
+			fingerprint, err := fingerprint(request)
+			if err != nil {
+				return nil, err
+			}
+			if p, ok := auth.PrincipalFromContext(ctx); ok {
+				key = p.Subject + "/" + key
+			}
+			key = name + "/" + key
+			if response, err := store.Reserve(key, fingerprint); err != nil || response != nil {
+				return response, err
+			}
+			response, err := next(ctx, request)
+			if err != nil {
+				store.Release(key)
+				return nil, err
+			}
+			store.Save(key, response)
+			return response, nil
+		}
+	}
+}
+
+func fingerprint(request interface{}) (string, error) {
+	b, err := json.Marshal(request)
+	if err != nil {
+		return "", err
+	}
+	sum := sha256.Sum256(b)
+	return hex.EncodeToString(sum[:]), nil
+}
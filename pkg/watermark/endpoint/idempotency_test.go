@@ -0,0 +1,179 @@
+package endpoint
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/wzzfarewell/go-microservice-example/pkg/watermark/auth"
+	wmerrors "github.com/wzzfarewell/go-microservice-example/pkg/watermark/errors"
+)
+
+// countingEndpoint answers every request with the number of calls so far,
+// or fails with err.
+type countingEndpoint struct {
+	calls int
+	err   error
+}
+
+func (c *countingEndpoint) serve(_ context.Context, request interface{}) (interface{}, error) {
+	c.calls++
+	if c.err != nil {
+		return nil, c.err
+	}
+	return c.calls, nil
+}
+
+func TestIdempotentReplay(t *testing.T) {
+	next := &countingEndpoint{}
+	e := Idempotent(NewIdempotencyStore(time.Hour, 10), "Watermark")(next.serve)
+	ctx := ContextWithIdempotencyKey(context.Background(), "key")
+	req := WatermarkRequest{TicketID: "t1", Mark: "mark"}
+
+	for i := 0; i < 3; i++ {
+		response, err := e(ctx, req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if response != 1 {
+			t.Fatalf("call %d returned %v, want the first response", i, response)
+		}
+	}
+	if next.calls != 1 {
+		t.Fatalf("endpoint called %d times, want 1", next.calls)
+	}
+
+	// without a key every request runs
+	if _, err := e(context.Background(), req); err != nil {
+		t.Fatal(err)
+	}
+	if next.calls != 2 {
+		t.Fatalf("endpoint called %d times, want 2", next.calls)
+	}
+}
+
+func TestIdempotentKeyReuse(t *testing.T) {
+	next := &countingEndpoint{}
+	e := Idempotent(NewIdempotencyStore(time.Hour, 10), "Watermark")(next.serve)
+	ctx := ContextWithIdempotencyKey(context.Background(), "key")
+	if _, err := e(ctx, WatermarkRequest{TicketID: "t1", Mark: "mark"}); err != nil {
+		t.Fatal(err)
+	}
+	_, err := e(ctx, WatermarkRequest{TicketID: "t1", Mark: "other"})
+	var unprocessable *wmerrors.Unprocessable
+	if !errors.As(err, &unprocessable) {
+		t.Fatalf("got %v, want an unprocessable error", err)
+	}
+	if status := wmerrors.HTTPStatus(err); status != http.StatusUnprocessableEntity {
+		t.Fatalf("status %d, want %d", status, http.StatusUnprocessableEntity)
+	}
+}
+
+func TestIdempotentScopes(t *testing.T) {
+	next := &countingEndpoint{}
+	store := NewIdempotencyStore(time.Hour, 10)
+	req := WatermarkRequest{TicketID: "t1", Mark: "mark"}
+	ctx := ContextWithIdempotencyKey(context.Background(), "key")
+	for _, call := range []struct {
+		name string
+		ctx  context.Context
+	}{
+		{"Watermark", ctx},
+		{"CreateDocument", ctx},
+		{"Watermark", auth.ContextWithPrincipal(ctx, auth.Principal{Subject: "alice"})},
+		{"Watermark", auth.ContextWithPrincipal(ctx, auth.Principal{Subject: "bob"})},
+	} {
+		if _, err := Idempotent(store, call.name)(next.serve)(call.ctx, req); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if next.calls != 4 {
+		t.Fatalf("endpoint called %d times, want every scope to run once", next.calls)
+	}
+}
+
+func TestIdempotentFailuresAreNotSaved(t *testing.T) {
+	next := &countingEndpoint{err: errors.New("failed")}
+	e := Idempotent(NewIdempotencyStore(time.Hour, 10), "Watermark")(next.serve)
+	ctx := ContextWithIdempotencyKey(context.Background(), "key")
+	req := WatermarkRequest{TicketID: "t1", Mark: "mark"}
+	if _, err := e(ctx, req); err == nil {
+		t.Fatal("no error")
+	}
+	next.err = nil
+	response, err := e(ctx, req)
+	if err != nil || response != 2 {
+		t.Fatalf("retry returned %v, %v, want it to run again", response, err)
+	}
+}
+
+func TestIdempotencyStoreInFlight(t *testing.T) {
+	store := NewIdempotencyStore(time.Hour, 10)
+	if _, err := store.Reserve("key", "fp"); err != nil {
+		t.Fatal(err)
+	}
+	_, err := store.Reserve("key", "fp")
+	var conflict *wmerrors.Conflict
+	if !errors.As(err, &conflict) {
+		t.Fatalf("got %v, want a conflict", err)
+	}
+	store.Release("key")
+	if response, err := store.Reserve("key", "fp"); response != nil || err != nil {
+		t.Fatalf("released key reserved with %v, %v", response, err)
+	}
+}
+
+func TestIdempotencyStoreExpiry(t *testing.T) {
+	now := time.Unix(0, 0)
+	store := NewIdempotencyStore(time.Minute, 10).(*idempotencyStore)
+	store.now = func() time.Time { return now }
+
+	for _, key := range []string{"a", "b"} {
+		store.Reserve(key, "fp")
+		store.Save(key, key)
+		now = now.Add(30 * time.Second)
+	}
+	// a expires at 60s, b at 90s
+	now = time.Unix(59, 0)
+	if response, _ := store.Reserve("a", "fp"); response != "a" {
+		t.Fatalf("a returned %v before expiring", response)
+	}
+	now = time.Unix(61, 0)
+	if response, err := store.Reserve("a", "fp"); response != nil || err != nil {
+		t.Fatalf("expired key reserved with %v, %v", response, err)
+	}
+	if response, _ := store.Reserve("b", "fp"); response != "b" {
+		t.Fatalf("b returned %v before expiring", response)
+	}
+	if len(store.entries) != 2 || store.saved.Len() != 1 {
+		t.Fatalf("%d entries, %d saved, want 2, 1", len(store.entries), store.saved.Len())
+	}
+}
+
+func TestIdempotencyStoreCap(t *testing.T) {
+	store := NewIdempotencyStore(time.Hour, 2).(*idempotencyStore)
+	for _, key := range []string{"a", "b", "c"} {
+		if _, err := store.Reserve(key, "fp"); err != nil {
+			t.Fatal(err)
+		}
+		store.Save(key, key)
+	}
+	if _, ok := store.entries["a"]; ok {
+		t.Fatal("the oldest key was not forgotten")
+	}
+	if len(store.entries) != 2 || store.saved.Len() != 2 {
+		t.Fatalf("%d entries, %d saved, want 2, 2", len(store.entries), store.saved.Len())
+	}
+
+	// keys in flight are never forgotten
+	inFlight := NewIdempotencyStore(time.Hour, 2)
+	inFlight.Reserve("a", "fp")
+	inFlight.Reserve("b", "fp")
+	_, err := inFlight.Reserve("c", "fp")
+	var unavailable *wmerrors.Unavailable
+	if !errors.As(err, &unavailable) {
+		t.Fatalf("got %v, want unavailable", err)
+	}
+}
@@ -0,0 +1,36 @@
+package endpoint
+
+import (
+	"context"
+	"time"
+
+	"github.com/go-kit/kit/endpoint"
+	"github.com/go-kit/kit/metrics"
+)
+
+// Metrics are recorded by InstrumentingMiddleware, labeled by "method".
+type Metrics struct {
+	Requests metrics.Counter
+	Errors   metrics.Counter
+	Duration metrics.Histogram // seconds
+}
+
+// InstrumentingMiddleware counts the requests and failures of the endpoint
+// and observes how long they take.
+func InstrumentingMiddleware(m Metrics, method string) endpoint.Middleware {
+	requests := m.Requests.With("method", method)
+	errors := m.Errors.With("method", method)
+	duration := m.Duration.With("method", method)
+	return func(next endpoint.Endpoint) endpoint.Endpoint {
+		return func(ctx context.Context, request interface{}) (response interface{}, err error) {
+			defer func(begin time.Time) {
+				requests.Add(1)
+				if err != nil {
+					errors.Add(1)
+				}
+				duration.Observe(time.Since(begin).Seconds())
+			}(time.Now())
+			return next(ctx, request)
+		}
+	}
+}
@@ -0,0 +1,51 @@
+package endpoint
+
+import (
+	"context"
+	"time"
+
+	"github.com/go-kit/kit/endpoint"
+	"github.com/go-kit/log"
+	"github.com/go-kit/log/level"
+	wmerrors "github.com/wzzfarewell/go-microservice-example/pkg/watermark/errors"
+	"github.com/wzzfarewell/go-microservice-example/pkg/watermark/logging"
+)
+
+// LoggingMiddleware logs every call of the endpoint with its outcome and
+// duration, failures the caller isn't to blame for at error level. The
+// ticket the request is about is put in the context, so everything logged
+// while handling it is tagged with it.
+func LoggingMiddleware(logger log.Logger, method string) endpoint.Middleware {
+	logger = log.With(logger, "method", method)
+	return func(next endpoint.Endpoint) endpoint.Endpoint {
+		return func(ctx context.Context, request interface{}) (response interface{}, err error) {
+			ctx = logging.ContextWithTicketID(ctx, requestTicketID(request))
+			defer func(begin time.Time) {
+				ctx := ctx
+				if r, ok := response.(CreateDocumentResponse); ok {
+					ctx = logging.ContextWithTicketID(ctx, r.TicketID)
+				}
+				l := logging.FromContext(ctx, logger)
+				switch {
+				case err == nil:
+					level.Info(l).Log("took", time.Since(begin))
+				case wmerrors.HTTPStatus(err) < 500:
+					level.Info(l).Log("took", time.Since(begin), "err", err)
+				default:
+					level.Error(l).Log("took", time.Since(begin), "err", err)
+				}
+			}(time.Now())
+			return next(ctx, request)
+		}
+	}
+}
+
+func requestTicketID(request interface{}) string {
+	switch r := request.(type) {
+	case StatusRequest:
+		return r.TicketID
+	case WatermarkRequest:
+		return r.TicketID
+	}
+	return ""
+}
@@ -3,7 +3,9 @@ package endpoint
 import "github.com/wzzfarewell/go-microservice-example/internal"
 
 type FindRequest struct {
-	Filters []internal.Filter `json:"filters,omitempty"`
+	Filters   []internal.Filter `json:"filters,omitempty"`
+	PageSize  int               `json:"page_size,omitempty"`
+	PageToken string            `json:"page_token,omitempty"`
 }
 
 type StatusRequest struct {
@@ -17,6 +19,14 @@ type WatermarkRequest struct {
 
 type CreateDocumentRequest struct {
 	Document *internal.Document `json:"document"`
+	Content  []byte             `json:"content"`
+
+	// Upload, if set, holds the content instead of Content
+	Upload *Upload `json:"upload,omitempty"`
 }
 
 type ServiceStatusRequest struct{}
+
+type ExtractRequest struct {
+	Content []byte `json:"content"`
+}
@@ -2,27 +2,32 @@ package endpoint
 
 import "github.com/wzzfarewell/go-microservice-example/internal"
 
+// Failed requests are reported through the error returned by the endpoint,
+// which the transports map to a status code, so the responses only carry the
+// result of a successful call.
+
 type FindResponse struct {
-	Documents []internal.Document `json:"documents"`
-	Err       string              `json:"err,omitempty"`
+	Documents     []internal.Document `json:"documents"`
+	NextPageToken string              `json:"next_page_token,omitempty"`
 }
 
 type StatusResponse struct {
 	Status internal.Status `json:"status"`
-	Err    string          `json:"err,omitempty"`
 }
 
 type WatermarkResponse struct {
-	Code int    `json:"code"`
-	Err  string `json:"err"`
+	Code int `json:"code"`
 }
 
 type CreateDocumentResponse struct {
 	TicketID string `json:"ticket_id"`
-	Err      string `json:"err,omitempty"`
 }
 
 type ServiceStatusResponse struct {
-	Code int    `json:"status"`
-	Err  string `json:"err,omitempty"`
+	Code int `json:"status"`
+}
+
+type ExtractResponse struct {
+	TicketID string `json:"ticket_id"`
+	Mark     string `json:"mark"`
 }
@@ -0,0 +1,54 @@
+package endpoint
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/wzzfarewell/go-microservice-example/internal/util"
+)
+
+// Upload is document content the transports spooled to a temporary file
+// instead of holding it in memory. Its digest stands for the content in the
+// fingerprint of idempotent requests.
+type Upload struct {
+	Digest string `json:"digest"`
+	Size   int64  `json:"size"`
+
+	file *os.File
+}
+
+// NewUpload copies r to a temporary file, failing if it holds more than limit
+// bytes. The caller must close the upload to remove the file.
+func NewUpload(r io.Reader, limit int64) (*Upload, error) {
+	f, err := os.CreateTemp("", "watermark-upload-*")
+	if err != nil {
+		return nil, err
+	}
+	u := &Upload{file: f}
+	h := sha256.New()
+	u.Size, err = io.Copy(io.MultiWriter(f, h), io.LimitReader(r, limit+1))
+	switch {
+	case err != nil:
+		u.Close()
+		return nil, err
+	case u.Size > limit:
+		u.Close()
+		return nil, fmt.Errorf("%w: content larger than %d bytes", util.ErrInvalidArgument, limit)
+	}
+	u.Digest = hex.EncodeToString(h.Sum(nil))
+	return u, nil
+}
+
+// Reader returns a reader of the content from its start.
+func (u *Upload) Reader() io.Reader {
+	return io.NewSectionReader(u.file, 0, u.Size)
+}
+
+// Close removes the temporary file.
+func (u *Upload) Close() error {
+	u.file.Close()
+	return os.Remove(u.file.Name())
+}
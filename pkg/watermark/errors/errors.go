@@ -0,0 +1,267 @@
+// Package errors defines the domain errors of the watermark service. Every
+// error knows the HTTP status code (go-kit's StatusCoder) and the gRPC status
+// it is reported with, so both transports map failures the same way.
+package errors
+
+import (
+	"errors"
+	"net/http"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// NotFound is returned when the requested document or ticket doesn't exist.
+type NotFound struct {
+	Message string
+	Err     error
+}
+
+func (e *NotFound) Error() string {
+	return message(e.Message, e.Err)
+}
+
+func (e *NotFound) Unwrap() error {
+	return e.Err
+}
+
+func (e *NotFound) StatusCode() int {
+	return http.StatusNotFound
+}
+
+func (e *NotFound) GRPCStatus() *status.Status {
+	return status.New(codes.NotFound, e.Error())
+}
+
+// InvalidArgument is returned when the request itself is malformed.
+type InvalidArgument struct {
+	Message string
+	Err     error
+}
+
+func (e *InvalidArgument) Error() string {
+	return message(e.Message, e.Err)
+}
+
+func (e *InvalidArgument) Unwrap() error {
+	return e.Err
+}
+
+func (e *InvalidArgument) StatusCode() int {
+	return http.StatusBadRequest
+}
+
+func (e *InvalidArgument) GRPCStatus() *status.Status {
+	return status.New(codes.InvalidArgument, e.Error())
+}
+
+// Conflict is returned when the request is valid but clashes with the current
+// state of a document, e.g. an illegal status transition.
+type Conflict struct {
+	Message string
+	Err     error
+}
+
+func (e *Conflict) Error() string {
+	return message(e.Message, e.Err)
+}
+
+func (e *Conflict) Unwrap() error {
+	return e.Err
+}
+
+func (e *Conflict) StatusCode() int {
+	return http.StatusConflict
+}
+
+func (e *Conflict) GRPCStatus() *status.Status {
+	return status.New(codes.FailedPrecondition, e.Error())
+}
+
+// Unavailable is returned when the service can't take the request right now
+// and the caller may retry later.
+type Unavailable struct {
+	Message string
+	Err     error
+}
+
+func (e *Unavailable) Error() string {
+	return message(e.Message, e.Err)
+}
+
+func (e *Unavailable) Unwrap() error {
+	return e.Err
+}
+
+func (e *Unavailable) StatusCode() int {
+	return http.StatusServiceUnavailable
+}
+
+func (e *Unavailable) GRPCStatus() *status.Status {
+	return status.New(codes.Unavailable, e.Error())
+}
+
+// Unprocessable is returned when a request is well formed but can't be
+// processed, e.g. an idempotency key reused with a different request.
+type Unprocessable struct {
+	Message string
+	Err     error
+}
+
+func (e *Unprocessable) Error() string {
+	return message(e.Message, e.Err)
+}
+
+func (e *Unprocessable) Unwrap() error {
+	return e.Err
+}
+
+func (e *Unprocessable) StatusCode() int {
+	return http.StatusUnprocessableEntity
+}
+
+func (e *Unprocessable) GRPCStatus() *status.Status {
+	return status.New(codes.AlreadyExists, e.Error())
+}
+
+// Unauthenticated is returned when a request carries no credentials, or
+// credentials that can't be verified.
+type Unauthenticated struct {
+	Message string
+	Err     error
+}
+
+func (e *Unauthenticated) Error() string {
+	return message(e.Message, e.Err)
+}
+
+func (e *Unauthenticated) Unwrap() error {
+	return e.Err
+}
+
+func (e *Unauthenticated) StatusCode() int {
+	return http.StatusUnauthorized
+}
+
+func (e *Unauthenticated) GRPCStatus() *status.Status {
+	return status.New(codes.Unauthenticated, e.Error())
+}
+
+// PermissionDenied is returned when the caller is authenticated but its
+// roles don't allow the call.
+type PermissionDenied struct {
+	Message string
+	Err     error
+}
+
+func (e *PermissionDenied) Error() string {
+	return message(e.Message, e.Err)
+}
+
+func (e *PermissionDenied) Unwrap() error {
+	return e.Err
+}
+
+func (e *PermissionDenied) StatusCode() int {
+	return http.StatusForbidden
+}
+
+func (e *PermissionDenied) GRPCStatus() *status.Status {
+	return status.New(codes.PermissionDenied, e.Error())
+}
+
+func message(msg string, err error) string {
+	switch {
+	case err == nil:
+		return msg
+	case msg == "":
+		return err.Error()
+	default:
+		return msg + ": " + err.Error()
+	}
+}
+
+// Body is the JSON body written for every failed HTTP request. Code holds
+// the name of the matching gRPC code so both transports report the same value.
+type Body struct {
+	Code    string `json:"code"`
+	Message string `json:"error"`
+}
+
+// HTTPStatus returns the HTTP status code for err, or 500 if err is not a
+// domain error.
+func HTTPStatus(err error) int {
+	var sc interface{ StatusCode() int }
+	if errors.As(err, &sc) {
+		return sc.StatusCode()
+	}
+	return http.StatusInternalServerError
+}
+
+// GRPCStatus returns the gRPC status for err, or codes.Internal if err is not
+// a domain error. The message is the one of err, including any context it was
+// wrapped with.
+func GRPCStatus(err error) *status.Status {
+	var gs interface{ GRPCStatus() *status.Status }
+	if errors.As(err, &gs) {
+		return status.New(gs.GRPCStatus().Code(), err.Error())
+	}
+	return status.New(codes.Internal, err.Error())
+}
+
+func NewBody(err error) Body {
+	return Body{Code: GRPCStatus(err).Code().String(), Message: err.Error()}
+}
+
+// FromHTTP turns an error body received with the given HTTP status code back
+// into a domain error.
+func FromHTTP(code int, body Body) error {
+	msg := body.Message
+	if msg == "" {
+		msg = http.StatusText(code)
+	}
+	switch code {
+	case http.StatusNotFound:
+		return &NotFound{Message: msg}
+	case http.StatusBadRequest:
+		return &InvalidArgument{Message: msg}
+	case http.StatusConflict:
+		return &Conflict{Message: msg}
+	case http.StatusServiceUnavailable:
+		return &Unavailable{Message: msg}
+	case http.StatusUnprocessableEntity:
+		return &Unprocessable{Message: msg}
+	case http.StatusUnauthorized:
+		return &Unauthenticated{Message: msg}
+	case http.StatusForbidden:
+		return &PermissionDenied{Message: msg}
+	default:
+		return errors.New(msg)
+	}
+}
+
+// FromGRPC turns an error returned by a gRPC call back into a domain error.
+func FromGRPC(err error) error {
+	st, ok := status.FromError(err)
+	if !ok {
+		return err
+	}
+	switch st.Code() {
+	case codes.NotFound:
+		return &NotFound{Message: st.Message()}
+	case codes.InvalidArgument:
+		return &InvalidArgument{Message: st.Message()}
+	case codes.FailedPrecondition:
+		return &Conflict{Message: st.Message()}
+	case codes.Unavailable:
+		return &Unavailable{Message: st.Message()}
+	case codes.AlreadyExists:
+		return &Unprocessable{Message: st.Message()}
+	case codes.Unauthenticated:
+		return &Unauthenticated{Message: st.Message()}
+	case codes.PermissionDenied:
+		return &PermissionDenied{Message: st.Message()}
+	default:
+		return err
+	}
+}
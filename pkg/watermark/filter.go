@@ -0,0 +1,85 @@
+package watermark
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"sort"
+
+	"github.com/wzzfarewell/go-microservice-example/internal"
+	"github.com/wzzfarewell/go-microservice-example/internal/util"
+)
+
+// filterFields maps every key accepted by internal.Filter to the document
+// field it selects.
+var filterFields = map[string]func(internal.Document) string{
+	"title":          func(d internal.Document) string { return d.Title },
+	"author":         func(d internal.Document) string { return d.Author },
+	"topic":          func(d internal.Document) string { return d.Topic },
+	"watermark":      func(d internal.Document) string { return d.Watermark },
+	"content_digest": func(d internal.Document) string { return d.ContentDigest },
+}
+
+// applyFilters keeps the documents matching every matcher and sorts the result
+// by sortKeys, both as returned by parseFilters. Ties are broken by ticket ID
+// so the order is always stable. The positions of the sorted documents are
+// returned along with them.
+func applyFilters(docs []internal.Document, matchers []internal.Filter, sortKeys []string) ([]internal.Document, []position) {
+	type positioned struct {
+		doc internal.Document
+		pos position
+	}
+	matched := make([]positioned, 0, len(docs))
+	for _, doc := range docs {
+		if matches(doc, matchers) {
+			matched = append(matched, positioned{doc: doc, pos: newPosition(doc, sortKeys)})
+		}
+	}
+	// positions are unique, no need for a stable sort
+	sort.Slice(matched, func(i, j int) bool { return matched[i].pos.less(matched[j].pos) })
+
+	result := make([]internal.Document, len(matched))
+	positions := make([]position, len(matched))
+	for i, m := range matched {
+		result[i], positions[i] = m.doc, m.pos
+	}
+	return result, positions
+}
+
+// parseFilters splits filters into the ones documents must match and the
+// keys to sort by, rejecting unknown keys. The content key, kept from before
+// the contents moved to the blob store, stands for the content digest: its
+// value is replaced by its SHA-256 digest.
+func parseFilters(filters []internal.Filter) ([]internal.Filter, []string, error) {
+	var (
+		matchers []internal.Filter
+		sortKeys []string
+	)
+	for _, f := range filters {
+		if f.Key == "content" {
+			f.Key = "content_digest"
+			if f.Value != "" {
+				sum := sha256.Sum256([]byte(f.Value))
+				f.Value = hex.EncodeToString(sum[:])
+			}
+		}
+		if _, ok := filterFields[f.Key]; !ok {
+			return nil, nil, fmt.Errorf("%w: unknown filter key %q", util.ErrInvalidArgument, f.Key)
+		}
+		if f.Value == "" {
+			sortKeys = append(sortKeys, f.Key)
+			continue
+		}
+		matchers = append(matchers, f)
+	}
+	return matchers, sortKeys, nil
+}
+
+func matches(doc internal.Document, filters []internal.Filter) bool {
+	for _, f := range filters {
+		if filterFields[f.Key](doc) != f.Value {
+			return false
+		}
+	}
+	return true
+}
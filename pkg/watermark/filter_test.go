@@ -0,0 +1,58 @@
+package watermark
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"testing"
+
+	"github.com/wzzfarewell/go-microservice-example/internal"
+	"github.com/wzzfarewell/go-microservice-example/internal/util"
+)
+
+func digestOf(content string) string {
+	sum := sha256.Sum256([]byte(content))
+	return hex.EncodeToString(sum[:])
+}
+
+func TestContentFilter(t *testing.T) {
+	docs := []internal.Document{
+		{TicketID: "t1", ContentDigest: digestOf("b")},
+		{TicketID: "t2", ContentDigest: digestOf("a")},
+		{TicketID: "t3", ContentDigest: digestOf("b")},
+	}
+	for _, tc := range []struct {
+		name    string
+		filters []internal.Filter
+		want    []string
+	}{
+		{"content", []internal.Filter{{Key: "content", Value: "b"}}, []string{"t1", "t3"}},
+		{"content digest", []internal.Filter{{Key: "content_digest", Value: digestOf("a")}}, []string{"t2"}},
+		{"no match", []internal.Filter{{Key: "content", Value: digestOf("a")}}, nil},
+		// sha256("a") = ca97..., sha256("b") = 3e23...
+		{"sort by content", []internal.Filter{{Key: "content"}}, []string{"t1", "t3", "t2"}},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			matchers, sortKeys, err := parseFilters(tc.filters)
+			if err != nil {
+				t.Fatal(err)
+			}
+			got, _ := applyFilters(docs, matchers, sortKeys)
+			if len(got) != len(tc.want) {
+				t.Fatalf("got %d documents, want %v", len(got), tc.want)
+			}
+			for i, doc := range got {
+				if doc.TicketID != tc.want[i] {
+					t.Fatalf("document %d is %s, want %v", i, doc.TicketID, tc.want)
+				}
+			}
+		})
+	}
+}
+
+func TestUnknownFilterKey(t *testing.T) {
+	_, _, err := parseFilters([]internal.Filter{{Key: "body", Value: "x"}})
+	if !errors.Is(err, util.ErrInvalidArgument) {
+		t.Fatalf("got %v, want an invalid argument", err)
+	}
+}
@@ -0,0 +1,106 @@
+package fs
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+
+	"github.com/wzzfarewell/go-microservice-example/internal/util"
+	"github.com/wzzfarewell/go-microservice-example/pkg/watermark"
+)
+
+// BlobStore is a watermark.BlobStore keeping every blob in its own file
+// below a directory on local disk, sharded by the first two characters of
+// the digest.
+type BlobStore struct {
+	dir string
+}
+
+func NewBlobStore(dir string) (*BlobStore, error) {
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return nil, err
+	}
+	return &BlobStore{dir: dir}, nil
+}
+
+// Put writes the content to a temporary file while hashing it, then moves it
+// to its final path so readers never see a partial blob.
+func (s *BlobStore) Put(_ context.Context, r io.Reader) (string, int64, error) {
+	tmp, err := os.CreateTemp(s.dir, "put-*")
+	if err != nil {
+		return "", 0, err
+	}
+	defer os.Remove(tmp.Name())
+	h := sha256.New()
+	size, err := io.Copy(io.MultiWriter(tmp, h), r)
+	if cerr := tmp.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		return "", 0, err
+	}
+
+	digest := hex.EncodeToString(h.Sum(nil))
+	path := s.path(digest)
+	if _, err := os.Stat(path); err == nil {
+		return digest, size, nil
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return "", 0, err
+	}
+	if err := os.Rename(tmp.Name(), path); err != nil {
+		return "", 0, err
+	}
+	return digest, size, nil
+}
+
+func (s *BlobStore) Get(_ context.Context, digest string) (io.ReadCloser, int64, error) {
+	if err := watermark.CheckDigest(digest); err != nil {
+		return nil, 0, err
+	}
+	f, err := os.Open(s.path(digest))
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, 0, util.ErrBlobNotFound
+	}
+	if err != nil {
+		return nil, 0, err
+	}
+	info, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return nil, 0, err
+	}
+	return f, info.Size(), nil
+}
+
+func (s *BlobStore) Delete(_ context.Context, digest string) error {
+	if err := watermark.CheckDigest(digest); err != nil {
+		return err
+	}
+	err := os.Remove(s.path(digest))
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil
+	}
+	return err
+}
+
+func (s *BlobStore) CheckHealth(_ context.Context) error {
+	info, err := os.Stat(s.dir)
+	if err != nil {
+		return err
+	}
+	if !info.IsDir() {
+		return fmt.Errorf("%s is not a directory", s.dir)
+	}
+	return nil
+}
+
+func (s *BlobStore) path(digest string) string {
+	return filepath.Join(s.dir, digest[:2], digest)
+}
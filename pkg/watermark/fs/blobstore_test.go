@@ -0,0 +1,105 @@
+package fs
+
+import (
+	"context"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/wzzfarewell/go-microservice-example/internal/util"
+)
+
+// sha256("content")
+const contentDigest = "ed7002b439e9ac845f22357d822bac1444730fbdb6016d3ec9432297b9ec9f73"
+
+func TestBlobStore(t *testing.T) {
+	ctx := context.Background()
+	dir := t.TempDir()
+	store, err := NewBlobStore(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	digest, size, err := store.Put(ctx, strings.NewReader("content"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if digest != contentDigest || size != 7 {
+		t.Fatalf("Put = %s, %d, want %s, 7", digest, size, contentDigest)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "ed", contentDigest)); err != nil {
+		t.Fatalf("blob not sharded by its digest: %v", err)
+	}
+
+	rc, size, err := store.Get(ctx, digest)
+	if err != nil {
+		t.Fatal(err)
+	}
+	content, err := io.ReadAll(rc)
+	rc.Close()
+	if err != nil || string(content) != "content" || size != 7 {
+		t.Fatalf("Get = %q, %d, %v", content, size, err)
+	}
+
+	if err := store.Delete(ctx, digest); err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := store.Get(ctx, digest); !errors.Is(err, util.ErrBlobNotFound) {
+		t.Fatalf("Get after Delete = %v, want %v", err, util.ErrBlobNotFound)
+	}
+	// deleting a missing blob is not an error
+	if err := store.Delete(ctx, digest); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestBlobStoreDedup(t *testing.T) {
+	ctx := context.Background()
+	dir := t.TempDir()
+	store, err := NewBlobStore(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 2; i++ {
+		digest, _, err := store.Put(ctx, strings.NewReader("content"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if digest != contentDigest {
+			t.Fatalf("Put %d = %s, want %s", i, digest, contentDigest)
+		}
+	}
+	// one shard holding one blob, and no temporary files left behind
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 1 || entries[0].Name() != "ed" {
+		t.Fatalf("store directory holds %v", entries)
+	}
+	blobs, err := os.ReadDir(filepath.Join(dir, "ed"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(blobs) != 1 {
+		t.Fatalf("shard holds %d blobs, want 1", len(blobs))
+	}
+}
+
+func TestBlobStoreRejectsMalformedDigests(t *testing.T) {
+	store, err := NewBlobStore(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, digest := range []string{"", "../../etc/passwd", strings.Repeat("A", 64)} {
+		if _, _, err := store.Get(context.Background(), digest); !errors.Is(err, util.ErrInvalidArgument) {
+			t.Errorf("Get(%q) = %v, want an invalid argument", digest, err)
+		}
+		if err := store.Delete(context.Background(), digest); !errors.Is(err, util.ErrInvalidArgument) {
+			t.Errorf("Delete(%q) = %v, want an invalid argument", digest, err)
+		}
+	}
+}
@@ -0,0 +1,117 @@
+package watermark
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// healthCheckTimeout bounds every HealthChecker, so a hung dependency shows
+// up as failing instead of blocking the probe.
+const healthCheckTimeout = 2 * time.Second
+
+const (
+	HealthOK   = "ok"
+	HealthFail = "fail"
+)
+
+// HealthChecker is implemented by the dependencies of the service that can
+// tell whether they are usable, e.g. the repository or the blob store.
+type HealthChecker interface {
+	CheckHealth(ctx context.Context) error
+}
+
+// HealthCheckerFunc turns a function into a HealthChecker.
+type HealthCheckerFunc func(ctx context.Context) error
+
+func (f HealthCheckerFunc) CheckHealth(ctx context.Context) error {
+	return f(ctx)
+}
+
+// ComponentHealth is the result of one HealthChecker.
+type ComponentHealth struct {
+	Name   string `json:"name"`
+	Status string `json:"status"`
+	Error  string `json:"error,omitempty"`
+}
+
+// HealthReport is the outcome of a readiness check. Status is HealthOK only
+// if the service is ready and every component is.
+type HealthReport struct {
+	Status     string            `json:"status"`
+	Components []ComponentHealth `json:"components"`
+}
+
+func (r HealthReport) OK() bool {
+	return r.Status == HealthOK
+}
+
+type namedChecker struct {
+	name    string
+	checker HealthChecker
+}
+
+// Health aggregates the HealthCheckers the readiness of the service depends
+// on, on top of its Readiness flag.
+type Health struct {
+	ready    *Readiness
+	mtx      sync.RWMutex
+	checkers []namedChecker
+}
+
+func NewHealth(ready *Readiness) *Health {
+	return &Health{ready: ready}
+}
+
+// Register adds a checker reported under name.
+func (h *Health) Register(name string, checker HealthChecker) {
+	h.mtx.Lock()
+	defer h.mtx.Unlock()
+	h.checkers = append(h.checkers, namedChecker{name: name, checker: checker})
+}
+
+// Check runs every checker concurrently and reports them in the order they
+// were registered.
+func (h *Health) Check(ctx context.Context) HealthReport {
+	h.mtx.RLock()
+	checkers := h.checkers
+	h.mtx.RUnlock()
+
+	ctx, cancel := context.WithTimeout(ctx, healthCheckTimeout)
+	defer cancel()
+	report := HealthReport{Status: HealthOK, Components: make([]ComponentHealth, len(checkers)+1)}
+	report.Components[0] = ComponentHealth{Name: "service", Status: HealthOK}
+	if !h.ready.Ready() {
+		report.Components[0] = ComponentHealth{Name: "service", Status: HealthFail, Error: "not ready"}
+	}
+	type result struct {
+		i   int
+		err error
+	}
+	results := make(chan result, len(checkers))
+	for i, c := range checkers {
+		report.Components[i+1] = ComponentHealth{Name: c.name, Status: HealthFail, Error: "timed out"}
+		go func(i int, c HealthChecker) {
+			results <- result{i: i, err: c.CheckHealth(ctx)}
+		}(i, c.checker)
+	}
+wait:
+	for range checkers {
+		select {
+		case r := <-results:
+			c := &report.Components[r.i+1]
+			c.Status, c.Error = HealthOK, ""
+			if r.err != nil {
+				c.Status, c.Error = HealthFail, r.err.Error()
+			}
+		case <-ctx.Done():
+			break wait
+		}
+	}
+	for _, c := range report.Components {
+		if c.Status != HealthOK {
+			report.Status = HealthFail
+		}
+	}
+	return report
+}
@@ -0,0 +1,109 @@
+package watermark
+
+import (
+	"sync"
+
+	"github.com/wzzfarewell/go-microservice-example/internal"
+)
+
+// subscriberBuffer is the number of events a subscriber may fall behind
+// before new events are dropped for it. A ticket goes through at most a
+// handful of transitions, so this is only reached by stuck subscribers.
+const subscriberBuffer = 16
+
+// StatusEvent is published every time a ticket changes status.
+type StatusEvent struct {
+	TicketID string          `json:"ticket_id"`
+	Status   internal.Status `json:"status"`
+
+	// Previous is the status the ticket left, empty for a new ticket. It is
+	// only of use to observers and isn't sent to the clients.
+	Previous internal.Status `json:"-"`
+}
+
+// Hub is an in-process pub/sub hub for status events. Publishing never
+// blocks: a subscriber that doesn't keep up misses events.
+type Hub struct {
+	mtx       sync.RWMutex
+	subs      map[string]map[chan StatusEvent]struct{}
+	observers []func(StatusEvent)
+	closed    bool
+}
+
+func NewHub() *Hub {
+	return &Hub{subs: make(map[string]map[chan StatusEvent]struct{})}
+}
+
+// Subscribe returns a channel receiving the status events of ticketID.
+// Calling cancel unsubscribes and closes the channel. The channel is closed
+// right away once the hub is closed.
+func (h *Hub) Subscribe(ticketID string) (<-chan StatusEvent, func()) {
+	ch := make(chan StatusEvent, subscriberBuffer)
+	h.mtx.Lock()
+	if h.closed {
+		h.mtx.Unlock()
+		close(ch)
+		return ch, func() {}
+	}
+	if h.subs[ticketID] == nil {
+		h.subs[ticketID] = make(map[chan StatusEvent]struct{})
+	}
+	h.subs[ticketID][ch] = struct{}{}
+	h.mtx.Unlock()
+
+	var once sync.Once
+	cancel := func() {
+		once.Do(func() {
+			h.mtx.Lock()
+			defer h.mtx.Unlock()
+			if _, ok := h.subs[ticketID][ch]; !ok {
+				return // closed by Close
+			}
+			delete(h.subs[ticketID], ch)
+			if len(h.subs[ticketID]) == 0 {
+				delete(h.subs, ticketID)
+			}
+			close(ch)
+		})
+	}
+	return ch, cancel
+}
+
+// Observe calls fn with the events of every ticket, synchronously from
+// Publish, so fn must not block.
+func (h *Hub) Observe(fn func(StatusEvent)) {
+	h.mtx.Lock()
+	defer h.mtx.Unlock()
+	h.observers = append(h.observers, fn)
+}
+
+func (h *Hub) Publish(event StatusEvent) {
+	h.mtx.RLock()
+	defer h.mtx.RUnlock()
+	for _, fn := range h.observers {
+		fn(event)
+	}
+	for ch := range h.subs[event.TicketID] {
+		select {
+		case ch <- event:
+		default:
+		}
+	}
+}
+
+// Close closes every subscription, which ends the status streams so they
+// don't hold up a graceful shutdown.
+func (h *Hub) Close() {
+	h.mtx.Lock()
+	defer h.mtx.Unlock()
+	if h.closed {
+		return
+	}
+	h.closed = true
+	for _, chans := range h.subs {
+		for ch := range chans {
+			close(ch)
+		}
+	}
+	h.subs = nil
+}
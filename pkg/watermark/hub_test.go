@@ -0,0 +1,99 @@
+package watermark
+
+import (
+	"testing"
+	"time"
+
+	"github.com/wzzfarewell/go-microservice-example/internal"
+)
+
+func receive(t *testing.T, ch <-chan StatusEvent) StatusEvent {
+	t.Helper()
+	select {
+	case event := <-ch:
+		return event
+	case <-time.After(5 * time.Second):
+		t.Fatal("no event received")
+		return StatusEvent{}
+	}
+}
+
+func TestHubPublish(t *testing.T) {
+	h := NewHub()
+	first, cancelFirst := h.Subscribe("t1")
+	defer cancelFirst()
+	second, cancelSecond := h.Subscribe("t1")
+	defer cancelSecond()
+	other, cancelOther := h.Subscribe("t2")
+	defer cancelOther()
+
+	event := StatusEvent{TicketID: "t1", Status: internal.Started, Previous: internal.Pending}
+	h.Publish(event)
+	for _, ch := range []<-chan StatusEvent{first, second} {
+		if got := receive(t, ch); got != event {
+			t.Fatalf("got %+v, want %+v", got, event)
+		}
+	}
+	select {
+	case got := <-other:
+		t.Fatalf("subscriber of t2 got %+v", got)
+	default:
+	}
+}
+
+func TestHubUnsubscribe(t *testing.T) {
+	h := NewHub()
+	ch, cancel := h.Subscribe("t1")
+	cancel()
+	cancel() // cancelling twice is harmless
+	if _, ok := <-ch; ok {
+		t.Fatal("channel still open after cancel")
+	}
+	if len(h.subs) != 0 {
+		t.Fatalf("subscriptions left: %v", h.subs)
+	}
+	// publishing to a ticket without subscribers doesn't block
+	h.Publish(StatusEvent{TicketID: "t1", Status: internal.Started})
+}
+
+func TestHubSlowSubscriber(t *testing.T) {
+	h := NewHub()
+	ch, cancel := h.Subscribe("t1")
+	defer cancel()
+	for i := 0; i < subscriberBuffer+1; i++ {
+		h.Publish(StatusEvent{TicketID: "t1", Status: internal.Started})
+	}
+	if len(ch) != subscriberBuffer {
+		t.Fatalf("%d events buffered, want %d", len(ch), subscriberBuffer)
+	}
+}
+
+func TestHubClose(t *testing.T) {
+	h := NewHub()
+	ch, cancel := h.Subscribe("t1")
+	h.Close()
+	if _, ok := <-ch; ok {
+		t.Fatal("channel still open after Close")
+	}
+	cancel() // doesn't close the channel again
+	late, _ := h.Subscribe("t1")
+	if _, ok := <-late; ok {
+		t.Fatal("subscription to a closed hub is open")
+	}
+}
+
+func TestHubObserve(t *testing.T) {
+	h := NewHub()
+	var observed []StatusEvent
+	h.Observe(func(e StatusEvent) { observed = append(observed, e) })
+	events := []StatusEvent{
+		{TicketID: "t1", Status: internal.Started},
+		{TicketID: "t2", Status: internal.Failed},
+	}
+	for _, e := range events {
+		h.Publish(e)
+	}
+	if len(observed) != len(events) || observed[0] != events[0] || observed[1] != events[1] {
+		t.Fatalf("observed %+v, want %+v", observed, events)
+	}
+}
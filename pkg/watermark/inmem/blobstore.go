@@ -0,0 +1,64 @@
+package inmem
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"sync"
+
+	"github.com/wzzfarewell/go-microservice-example/internal/util"
+	"github.com/wzzfarewell/go-microservice-example/pkg/watermark"
+)
+
+type blobStore struct {
+	mtx   sync.RWMutex
+	blobs map[string][]byte
+}
+
+func NewBlobStore() watermark.BlobStore {
+	return &blobStore{
+		blobs: make(map[string][]byte),
+	}
+}
+
+func (s *blobStore) Put(_ context.Context, r io.Reader) (string, int64, error) {
+	content, err := io.ReadAll(r)
+	if err != nil {
+		return "", 0, err
+	}
+	sum := sha256.Sum256(content)
+	digest := hex.EncodeToString(sum[:])
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+	if _, ok := s.blobs[digest]; !ok {
+		s.blobs[digest] = content
+	}
+	return digest, int64(len(content)), nil
+}
+
+func (s *blobStore) Get(_ context.Context, digest string) (io.ReadCloser, int64, error) {
+	if err := watermark.CheckDigest(digest); err != nil {
+		return nil, 0, err
+	}
+	s.mtx.RLock()
+	defer s.mtx.RUnlock()
+	content, ok := s.blobs[digest]
+	if !ok {
+		return nil, 0, util.ErrBlobNotFound
+	}
+	// blobs are never modified in place, so the slice can be shared
+	return io.NopCloser(bytes.NewReader(content)), int64(len(content)), nil
+}
+
+func (s *blobStore) Delete(_ context.Context, digest string) error {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+	delete(s.blobs, digest)
+	return nil
+}
+
+func (s *blobStore) CheckHealth(_ context.Context) error {
+	return nil
+}
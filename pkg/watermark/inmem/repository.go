@@ -0,0 +1,87 @@
+package inmem
+
+import (
+	"context"
+	"sort"
+	"sync"
+
+	"github.com/wzzfarewell/go-microservice-example/internal"
+	"github.com/wzzfarewell/go-microservice-example/internal/util"
+	"github.com/wzzfarewell/go-microservice-example/pkg/watermark"
+)
+
+type documentRepository struct {
+	mtx  sync.RWMutex
+	docs map[string]internal.Document
+}
+
+func NewRepository() watermark.Repository {
+	return &documentRepository{
+		docs: make(map[string]internal.Document),
+	}
+}
+
+func (r *documentRepository) Create(_ context.Context, doc *internal.Document) error {
+	r.mtx.Lock()
+	defer r.mtx.Unlock()
+	if _, ok := r.docs[doc.TicketID]; ok {
+		return util.ErrDocumentExists
+	}
+	r.docs[doc.TicketID] = *doc
+	return nil
+}
+
+func (r *documentRepository) Get(_ context.Context, ticketID string) (*internal.Document, error) {
+	r.mtx.RLock()
+	defer r.mtx.RUnlock()
+	doc, ok := r.docs[ticketID]
+	if !ok {
+		return nil, util.ErrDocumentNotFound
+	}
+	return &doc, nil
+}
+
+func (r *documentRepository) Update(_ context.Context, doc *internal.Document) error {
+	r.mtx.Lock()
+	defer r.mtx.Unlock()
+	if _, ok := r.docs[doc.TicketID]; !ok {
+		return util.ErrDocumentNotFound
+	}
+	r.docs[doc.TicketID] = *doc
+	return nil
+}
+
+func (r *documentRepository) List(_ context.Context) ([]internal.Document, error) {
+	r.mtx.RLock()
+	defer r.mtx.RUnlock()
+	docs := make([]internal.Document, 0, len(r.docs))
+	for _, doc := range r.docs {
+		docs = append(docs, doc)
+	}
+	// keep the same ordering as the on-disk repositories, which iterate by key
+	sort.Slice(docs, func(i, j int) bool { return docs[i].TicketID < docs[j].TicketID })
+	return docs, nil
+}
+
+func (r *documentRepository) Walk(ctx context.Context, fn func(internal.Document) error) error {
+	return r.WalkAfter(ctx, "", fn)
+}
+
+func (r *documentRepository) WalkAfter(ctx context.Context, after string, fn func(internal.Document) error) error {
+	// walk a snapshot so fn can't block writers
+	docs, err := r.List(ctx)
+	if err != nil {
+		return err
+	}
+	start := sort.Search(len(docs), func(i int) bool { return docs[i].TicketID > after })
+	for _, doc := range docs[start:] {
+		if err := fn(doc); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (r *documentRepository) CheckHealth(_ context.Context) error {
+	return nil
+}
@@ -0,0 +1,41 @@
+package watermark
+
+import "sync"
+
+// ticketLocks hands out one mutex per ticket, so calls on different tickets
+// don't wait for each other. A mutex is dropped once nobody holds or waits
+// for it. The zero value is ready to use.
+type ticketLocks struct {
+	mtx   sync.Mutex
+	locks map[string]*ticketLock
+}
+
+type ticketLock struct {
+	sync.Mutex
+	refs int // holders and waiters
+}
+
+// lock locks the mutex of ticketID and returns the function unlocking it.
+func (l *ticketLocks) lock(ticketID string) (unlock func()) {
+	l.mtx.Lock()
+	if l.locks == nil {
+		l.locks = make(map[string]*ticketLock)
+	}
+	tl, ok := l.locks[ticketID]
+	if !ok {
+		tl = &ticketLock{}
+		l.locks[ticketID] = tl
+	}
+	tl.refs++
+	l.mtx.Unlock()
+
+	tl.Lock()
+	return func() {
+		tl.Unlock()
+		l.mtx.Lock()
+		defer l.mtx.Unlock()
+		if tl.refs--; tl.refs == 0 {
+			delete(l.locks, ticketID)
+		}
+	}
+}
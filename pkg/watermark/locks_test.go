@@ -0,0 +1,43 @@
+package watermark
+
+import (
+	"testing"
+	"time"
+)
+
+func TestTicketLocks(t *testing.T) {
+	var locks ticketLocks
+	unlockA := locks.lock("a")
+
+	// other tickets don't wait
+	done := make(chan struct{})
+	go func() {
+		locks.lock("b")()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("locking b waited for a")
+	}
+
+	// the same ticket does
+	locked := make(chan func())
+	go func() { locked <- locks.lock("a") }()
+	select {
+	case <-locked:
+		t.Fatal("a locked twice")
+	case <-time.After(50 * time.Millisecond):
+	}
+	unlockA()
+	select {
+	case unlock := <-locked:
+		unlock()
+	case <-time.After(5 * time.Second):
+		t.Fatal("a not locked once unlocked")
+	}
+
+	if len(locks.locks) != 0 {
+		t.Fatalf("%d mutexes kept after every unlock", len(locks.locks))
+	}
+}
@@ -0,0 +1,102 @@
+// Package logging builds the logger shared by the packages of the service and
+// carries the IDs every line about a request or a ticket is tagged with.
+package logging
+
+import (
+	"context"
+	"fmt"
+	"io"
+
+	"github.com/go-kit/log"
+	"github.com/go-kit/log/level"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// New returns a logger writing timestamped lines to w in format, logfmt or
+// json, that drops the ones below lvl: debug, info, warn or error.
+func New(w io.Writer, format, lvl string) (log.Logger, error) {
+	var logger log.Logger
+	switch format {
+	case "logfmt":
+		logger = log.NewLogfmtLogger(log.NewSyncWriter(w))
+	case "json":
+		logger = log.NewJSONLogger(log.NewSyncWriter(w))
+	default:
+		return nil, fmt.Errorf("logging: unknown format %q", format)
+	}
+	option, err := levelOption(lvl)
+	if err != nil {
+		return nil, err
+	}
+	logger = level.NewFilter(logger, option)
+	return log.With(logger, "ts", log.DefaultTimestampUTC), nil
+}
+
+func levelOption(lvl string) (level.Option, error) {
+	switch lvl {
+	case "debug":
+		return level.AllowDebug(), nil
+	case "info":
+		return level.AllowInfo(), nil
+	case "warn":
+		return level.AllowWarn(), nil
+	case "error":
+		return level.AllowError(), nil
+	}
+	return nil, fmt.Errorf("logging: unknown level %q", lvl)
+}
+
+type contextKey int
+
+const (
+	requestIDKey contextKey = iota
+	ticketIDKey
+)
+
+// ContextWithRequestID returns a copy of ctx carrying the ID of the request
+// it serves.
+func ContextWithRequestID(ctx context.Context, id string) context.Context {
+	if id == "" {
+		return ctx
+	}
+	return context.WithValue(ctx, requestIDKey, id)
+}
+
+// RequestIDFromContext returns the request ID carried by ctx, if any.
+func RequestIDFromContext(ctx context.Context) string {
+	id, _ := ctx.Value(requestIDKey).(string)
+	return id
+}
+
+// ContextWithTicketID returns a copy of ctx carrying the ticket it is about.
+func ContextWithTicketID(ctx context.Context, id string) context.Context {
+	if id == "" {
+		return ctx
+	}
+	return context.WithValue(ctx, ticketIDKey, id)
+}
+
+// TicketIDFromContext returns the ticket ID carried by ctx, if any.
+func TicketIDFromContext(ctx context.Context) string {
+	id, _ := ctx.Value(ticketIDKey).(string)
+	return id
+}
+
+// FromContext returns logger tagged with the request ID, trace ID and ticket
+// ID ctx carries.
+func FromContext(ctx context.Context, logger log.Logger) log.Logger {
+	var keyvals []interface{}
+	if id := RequestIDFromContext(ctx); id != "" {
+		keyvals = append(keyvals, "requestID", id)
+	}
+	if sc := trace.SpanContextFromContext(ctx); sc.HasTraceID() {
+		keyvals = append(keyvals, "traceID", sc.TraceID().String())
+	}
+	if id := TicketIDFromContext(ctx); id != "" {
+		keyvals = append(keyvals, "ticketID", id)
+	}
+	if len(keyvals) == 0 {
+		return logger
+	}
+	return log.With(logger, keyvals...)
+}
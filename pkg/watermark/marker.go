@@ -0,0 +1,75 @@
+package watermark
+
+import (
+	"bytes"
+	"fmt"
+	"mime"
+
+	"github.com/wzzfarewell/go-microservice-example/internal/util"
+)
+
+const (
+	ContentTypeText = "text/plain"
+	ContentTypePDF  = "application/pdf"
+	ContentTypePNG  = "image/png"
+	ContentTypeJPEG = "image/jpeg"
+)
+
+// Marker applies a visible watermark to document content of one content type.
+type Marker interface {
+	Mark(content []byte, mark string) ([]byte, error)
+}
+
+// Markers selects the Marker for a document by its content type.
+type Markers map[string]Marker
+
+// DefaultMarkers returns a Markers handling plain text, PDF, PNG and JPEG.
+func DefaultMarkers() Markers {
+	return Markers{
+		ContentTypeText: TextMarker{},
+		ContentTypePDF:  PDFMarker{},
+		ContentTypePNG:  ImageMarker{},
+		ContentTypeJPEG: ImageMarker{},
+	}
+}
+
+// For returns the Marker for contentType. Parameters such as the charset are
+// ignored and an empty content type is treated as plain text.
+func (m Markers) For(contentType string) (Marker, error) {
+	mediaType, err := normalizeContentType(contentType)
+	if err != nil {
+		return nil, err
+	}
+	marker, ok := m[mediaType]
+	if !ok {
+		return nil, fmt.Errorf("%w: unsupported content type %q", util.ErrInvalidArgument, contentType)
+	}
+	return marker, nil
+}
+
+func normalizeContentType(contentType string) (string, error) {
+	if contentType == "" {
+		return ContentTypeText, nil
+	}
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return "", fmt.Errorf("%w: malformed content type %q", util.ErrInvalidArgument, contentType)
+	}
+	return mediaType, nil
+}
+
+// TextMarker puts the mark on its own line above and below plain text.
+type TextMarker struct{}
+
+func (TextMarker) Mark(content []byte, mark string) ([]byte, error) {
+	line := []byte("[" + mark + "]\n")
+	var buf bytes.Buffer
+	buf.Grow(len(content) + 2*len(line) + 1)
+	buf.Write(line)
+	buf.Write(content)
+	if len(content) > 0 && content[len(content)-1] != '\n' {
+		buf.WriteByte('\n')
+	}
+	buf.Write(line)
+	return buf.Bytes(), nil
+}
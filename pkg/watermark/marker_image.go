@@ -0,0 +1,91 @@
+package watermark
+
+import (
+	"bytes"
+	"fmt"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"image/png"
+
+	"github.com/wzzfarewell/go-microservice-example/internal/util"
+
+	"golang.org/x/image/draw"
+	"golang.org/x/image/font"
+	"golang.org/x/image/font/basicfont"
+	"golang.org/x/image/math/fixed"
+)
+
+// maxImagePixels bounds the images that are decoded: a small file can declare
+// dimensions that take gigabytes to decode.
+const maxImagePixels = 32 << 20
+
+// checkImageSize reads the dimensions from the header of the image, without
+// decoding it, and rejects images with more than maxImagePixels.
+func checkImageSize(content []byte) error {
+	cfg, _, err := image.DecodeConfig(bytes.NewReader(content))
+	if err != nil {
+		return err
+	}
+	if cfg.Width <= 0 || cfg.Height <= 0 || int64(cfg.Width)*int64(cfg.Height) > maxImagePixels {
+		return fmt.Errorf("%w: image of %dx%d pixels, at most %d pixels are supported", util.ErrInvalidArgument, cfg.Width, cfg.Height, maxImagePixels)
+	}
+	return nil
+}
+
+// ImageMarker overlays the mark on the center of a PNG or JPEG image. The
+// image is written back in the format it was read in.
+type ImageMarker struct{}
+
+func (ImageMarker) Mark(content []byte, mark string) ([]byte, error) {
+	if err := checkImageSize(content); err != nil {
+		return nil, err
+	}
+	src, format, err := image.Decode(bytes.NewReader(content))
+	if err != nil {
+		return nil, err
+	}
+	bounds := src.Bounds()
+	dst := image.NewRGBA(bounds)
+	draw.Draw(dst, bounds, src, bounds.Min, draw.Src)
+
+	// render the mark with the built-in bitmap font and scale it up to half
+	// the width of the image
+	face := basicfont.Face7x13
+	text := image.NewAlpha(image.Rect(0, 0, font.MeasureString(face, mark).Ceil(), face.Height))
+	d := font.Drawer{
+		Dst:  text,
+		Src:  image.Opaque,
+		Face: face,
+		Dot:  fixed.P(0, face.Ascent),
+	}
+	d.DrawString(mark)
+	if text.Bounds().Dx() == 0 {
+		return nil, fmt.Errorf("empty mark")
+	}
+	width := bounds.Dx() / 2
+	height := width * text.Bounds().Dy() / text.Bounds().Dx()
+	if width == 0 || height == 0 {
+		width, height = text.Bounds().Dx(), text.Bounds().Dy()
+	}
+	mask := image.NewAlpha(image.Rect(0, 0, width, height))
+	draw.ApproxBiLinear.Scale(mask, mask.Bounds(), text, text.Bounds(), draw.Src, nil)
+
+	at := image.Pt(bounds.Min.X+(bounds.Dx()-width)/2, bounds.Min.Y+(bounds.Dy()-height)/2)
+	ink := image.NewUniform(color.NRGBA{R: 128, G: 128, B: 128, A: 128})
+	draw.DrawMask(dst, mask.Bounds().Add(at), ink, image.Point{}, mask, image.Point{}, draw.Over)
+
+	var out bytes.Buffer
+	switch format {
+	case "png":
+		err = png.Encode(&out, dst)
+	case "jpeg":
+		err = jpeg.Encode(&out, dst, &jpeg.Options{Quality: 90})
+	default:
+		err = fmt.Errorf("unsupported image format %q", format)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return out.Bytes(), nil
+}
@@ -0,0 +1,87 @@
+package watermark
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"hash/crc32"
+	"image"
+	"image/png"
+	"testing"
+
+	"github.com/wzzfarewell/go-microservice-example/internal/util"
+)
+
+func encodePNG(t *testing.T, width, height int) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, image.NewNRGBA(image.Rect(0, 0, width, height))); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+// hugePNG returns a small PNG whose header claims width x height pixels.
+func hugePNG(t *testing.T, width, height uint32) []byte {
+	b := encodePNG(t, 1, 1)
+	// the IHDR chunk follows the 8 byte signature: length, type, width,
+	// height, ..., then the CRC of type and data
+	binary.BigEndian.PutUint32(b[16:], width)
+	binary.BigEndian.PutUint32(b[20:], height)
+	binary.BigEndian.PutUint32(b[29:], crc32.ChecksumIEEE(b[12:29]))
+	return b
+}
+
+func TestImageSizeLimit(t *testing.T) {
+	huge := hugePNG(t, 100000, 100000)
+	if _, err := png.DecodeConfig(bytes.NewReader(huge)); err != nil {
+		t.Fatalf("the crafted header doesn't parse: %v", err)
+	}
+	for name, decode := range map[string]func([]byte) error{
+		"ImageMarker.Mark": func(b []byte) error {
+			_, err := ImageMarker{}.Mark(b, "mark")
+			return err
+		},
+		"PNGEmbedder.Embed": func(b []byte) error {
+			_, err := PNGEmbedder{}.Embed(b, []byte("payload"))
+			return err
+		},
+		"PNGEmbedder.Extract": func(b []byte) error {
+			_, err := PNGEmbedder{}.Extract(b)
+			return err
+		},
+	} {
+		if err := decode(huge); !errors.Is(err, util.ErrInvalidArgument) {
+			t.Errorf("%s of a 100000x100000 image = %v, want an invalid argument", name, err)
+		}
+	}
+}
+
+func TestImageMarkerKeepsSize(t *testing.T) {
+	out, err := ImageMarker{}.Mark(encodePNG(t, 64, 32), "mark")
+	if err != nil {
+		t.Fatal(err)
+	}
+	img, err := png.Decode(bytes.NewReader(out))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if img.Bounds() != image.Rect(0, 0, 64, 32) {
+		t.Fatalf("marked image is %v, want 64x32", img.Bounds())
+	}
+}
+
+func TestPNGEmbedderRoundTrip(t *testing.T) {
+	payload := []byte("ticket and mark")
+	out, err := PNGEmbedder{}.Embed(encodePNG(t, 32, 32), payload)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := PNGEmbedder{}.Extract(out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, payload) {
+		t.Fatalf("extracted %q, want %q", got, payload)
+	}
+}
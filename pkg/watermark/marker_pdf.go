@@ -0,0 +1,34 @@
+package watermark
+
+import (
+	"bytes"
+
+	"github.com/pdfcpu/pdfcpu/pkg/api"
+	"github.com/pdfcpu/pdfcpu/pkg/pdfcpu/model"
+	"github.com/pdfcpu/pdfcpu/pkg/pdfcpu/types"
+)
+
+func init() {
+	// pdfcpu otherwise creates, and exits the process if it can't create, a
+	// configuration directory in the home of the user running the service
+	api.DisableConfigDir()
+}
+
+// pdfStampDescription places the mark diagonally across the middle of every
+// page, in grey and half transparent.
+const pdfStampDescription = "font:Helvetica, points:48, rot:45, fillcolor:#808080, opacity:0.5, scalefactor:0.6 rel"
+
+// PDFMarker stamps the mark on every page of a PDF document.
+type PDFMarker struct{}
+
+func (PDFMarker) Mark(content []byte, mark string) ([]byte, error) {
+	wm, err := api.TextWatermark(mark, pdfStampDescription, true, false, types.POINTS)
+	if err != nil {
+		return nil, err
+	}
+	var out bytes.Buffer
+	if err := api.AddWatermarks(bytes.NewReader(content), &out, nil, wm, model.NewDefaultConfiguration()); err != nil {
+		return nil, err
+	}
+	return out.Bytes(), nil
+}
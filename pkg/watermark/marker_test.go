@@ -0,0 +1,79 @@
+package watermark
+
+import (
+	"bytes"
+	"io"
+	"testing"
+
+	"github.com/pdfcpu/pdfcpu/pkg/api"
+	"github.com/pdfcpu/pdfcpu/pkg/pdfcpu/model"
+)
+
+func TestTextMarker(t *testing.T) {
+	for _, tc := range []struct {
+		name, content, want string
+	}{
+		{"empty", "", "[mark]\n[mark]\n"},
+		{"trailing newline", "one\ntwo\n", "[mark]\none\ntwo\n[mark]\n"},
+		{"no trailing newline", "one\ntwo", "[mark]\none\ntwo\n[mark]\n"},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			got, err := TextMarker{}.Mark([]byte(tc.content), "mark")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(got) != tc.want {
+				t.Fatalf("got %q, want %q", got, tc.want)
+			}
+		})
+	}
+}
+
+// testPDF returns a PDF of one page per image.
+func testPDF(t *testing.T, pages int) []byte {
+	t.Helper()
+	imgs := make([]io.Reader, pages)
+	for i := range imgs {
+		imgs[i] = bytes.NewReader(encodePNG(t, 20, 10))
+	}
+	var buf bytes.Buffer
+	if err := api.ImportImages(nil, &buf, imgs, nil, model.NewDefaultConfiguration()); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func TestPDFMarker(t *testing.T) {
+	content := testPDF(t, 2)
+	if marked, err := api.HasWatermarks(bytes.NewReader(content), model.NewDefaultConfiguration()); err != nil || marked {
+		t.Fatalf("the test PDF has watermarks: %v, %v", marked, err)
+	}
+
+	got, err := PDFMarker{}.Mark(content, "mark")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := api.Validate(bytes.NewReader(got), model.NewDefaultConfiguration()); err != nil {
+		t.Fatalf("invalid marked PDF: %v", err)
+	}
+	marked, err := api.HasWatermarks(bytes.NewReader(got), model.NewDefaultConfiguration())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !marked {
+		t.Fatal("the marked PDF has no watermark")
+	}
+	pages, err := api.PageCount(bytes.NewReader(got), model.NewDefaultConfiguration())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if pages != 2 {
+		t.Fatalf("%d pages, want 2", pages)
+	}
+}
+
+func TestPDFMarkerInvalidContent(t *testing.T) {
+	if _, err := (PDFMarker{}).Mark([]byte("not a PDF"), "mark"); err == nil {
+		t.Fatal("marked content that isn't a PDF")
+	}
+}
@@ -0,0 +1,95 @@
+// Package metrics exposes the service to Prometheus: per endpoint and per
+// gRPC method request metrics, and gauges on the state of the service.
+package metrics
+
+import (
+	"context"
+
+	kitprometheus "github.com/go-kit/kit/metrics/prometheus"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/wzzfarewell/go-microservice-example/internal"
+	"github.com/wzzfarewell/go-microservice-example/pkg/watermark"
+	"github.com/wzzfarewell/go-microservice-example/pkg/watermark/endpoint"
+)
+
+const namespace = "watermark"
+
+// durationBuckets spans fast lookups to large uploads.
+var durationBuckets = []float64{.005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10, 30}
+
+// statuses lists every internal.Status, so tickets reports a zero for the
+// ones no ticket is in instead of omitting them.
+var statuses = []internal.Status{
+	internal.Pending, internal.Started, internal.InProgress, internal.Finished, internal.Failed,
+}
+
+// NewEndpointMetrics registers the metrics of endpoint.InstrumentingMiddleware
+// with reg.
+func NewEndpointMetrics(reg prometheus.Registerer) endpoint.Metrics {
+	requests := prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Subsystem: "endpoint",
+		Name:      "requests_total",
+		Help:      "Number of requests handled by an endpoint.",
+	}, []string{"method"})
+	errors := prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Subsystem: "endpoint",
+		Name:      "errors_total",
+		Help:      "Number of requests an endpoint failed.",
+	}, []string{"method"})
+	duration := prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Subsystem: "endpoint",
+		Name:      "request_duration_seconds",
+		Help:      "Time an endpoint took to handle a request.",
+		Buckets:   durationBuckets,
+	}, []string{"method"})
+	reg.MustRegister(requests, errors, duration)
+	return endpoint.Metrics{
+		Requests: kitprometheus.NewCounter(requests),
+		Errors:   kitprometheus.NewCounter(errors),
+		Duration: kitprometheus.NewHistogram(duration),
+	}
+}
+
+// RegisterQueueDepth reports the number of jobs waiting in the queue of
+// workers.
+func RegisterQueueDepth(reg prometheus.Registerer, workers *watermark.WorkerPool) {
+	reg.MustRegister(prometheus.NewGaugeFunc(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "queue_depth",
+		Help:      "Number of watermark jobs waiting for a worker.",
+	}, func() float64 {
+		return float64(workers.QueueDepth())
+	}))
+}
+
+// RegisterTickets reports the number of tickets in each status. The gauges
+// are seeded by counting the tickets in repo, and then follow the status
+// events published to events, so it must be called before any is.
+func RegisterTickets(ctx context.Context, reg prometheus.Registerer, repo watermark.Repository, events *watermark.Hub) error {
+	tickets := prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "tickets",
+		Help:      "Number of tickets in each status.",
+	}, []string{"status"})
+	for _, s := range statuses {
+		tickets.WithLabelValues(string(s))
+	}
+	err := repo.Walk(ctx, func(doc internal.Document) error {
+		tickets.WithLabelValues(string(doc.Status)).Inc()
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	events.Observe(func(event watermark.StatusEvent) {
+		if event.Previous != "" {
+			tickets.WithLabelValues(string(event.Previous)).Dec()
+		}
+		tickets.WithLabelValues(string(event.Status)).Inc()
+	})
+	reg.MustRegister(tickets)
+	return nil
+}
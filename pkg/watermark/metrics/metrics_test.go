@@ -0,0 +1,64 @@
+package metrics
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/wzzfarewell/go-microservice-example/internal"
+	"github.com/wzzfarewell/go-microservice-example/pkg/watermark"
+	"github.com/wzzfarewell/go-microservice-example/pkg/watermark/inmem"
+)
+
+func TestTickets(t *testing.T) {
+	ctx := context.Background()
+	repo := inmem.NewRepository()
+	for _, doc := range []internal.Document{
+		{TicketID: "t1", Status: internal.Pending},
+		{TicketID: "t2", Status: internal.Pending},
+		{TicketID: "t3", Status: internal.Finished},
+	} {
+		doc := doc
+		if err := repo.Create(ctx, &doc); err != nil {
+			t.Fatal(err)
+		}
+	}
+	reg := prometheus.NewRegistry()
+	events := watermark.NewHub()
+	if err := RegisterTickets(ctx, reg, repo, events); err != nil {
+		t.Fatal(err)
+	}
+	expect := func(want string) {
+		t.Helper()
+		want = `
+# HELP watermark_tickets Number of tickets in each status.
+# TYPE watermark_tickets gauge
+` + want
+		if err := testutil.GatherAndCompare(reg, strings.NewReader(want), "watermark_tickets"); err != nil {
+			t.Fatal(err)
+		}
+	}
+	expect(`
+watermark_tickets{status="Failed"} 0
+watermark_tickets{status="Finished"} 1
+watermark_tickets{status="InProgress"} 0
+watermark_tickets{status="Pending"} 2
+watermark_tickets{status="Started"} 0
+`)
+
+	// the repository is not read again, the events alone move the gauges
+	events.Publish(watermark.StatusEvent{TicketID: "t4", Status: internal.Pending})
+	events.Publish(watermark.StatusEvent{TicketID: "t1", Status: internal.Started, Previous: internal.Pending})
+	events.Publish(watermark.StatusEvent{TicketID: "t1", Status: internal.InProgress, Previous: internal.Started})
+	events.Publish(watermark.StatusEvent{TicketID: "t2", Status: internal.Started, Previous: internal.Pending})
+	events.Publish(watermark.StatusEvent{TicketID: "t2", Status: internal.Failed, Previous: internal.Started})
+	expect(`
+watermark_tickets{status="Failed"} 1
+watermark_tickets{status="Finished"} 1
+watermark_tickets{status="InProgress"} 1
+watermark_tickets{status="Pending"} 1
+watermark_tickets{status="Started"} 0
+`)
+}
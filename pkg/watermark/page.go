@@ -0,0 +1,154 @@
+package watermark
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+
+	"github.com/wzzfarewell/go-microservice-example/internal"
+	"github.com/wzzfarewell/go-microservice-example/internal/util"
+)
+
+const (
+	defaultPageSize = 100
+	maxPageSize     = 1000
+)
+
+// position is where a document sits in a sorted Find result: the values of
+// the sort keys followed by the ticket ID, which makes it unique.
+//
+// Page tokens are opaque encodings of the position of the last document
+// returned. The next page starts strictly after that position rather than at
+// an offset, so documents inserted while a client is paging never cause
+// already seen documents to be repeated or unseen ones to be skipped.
+type position struct {
+	Keys     []string `json:"k,omitempty"`
+	Values   []string `json:"v,omitempty"`
+	TicketID string   `json:"id"`
+}
+
+func newPosition(doc internal.Document, sortKeys []string) position {
+	p := position{Keys: sortKeys, TicketID: doc.TicketID}
+	for _, key := range sortKeys {
+		p.Values = append(p.Values, filterFields[key](doc))
+	}
+	return p
+}
+
+func (p position) less(o position) bool {
+	for i := range p.Values {
+		if p.Values[i] != o.Values[i] {
+			return p.Values[i] < o.Values[i]
+		}
+	}
+	return p.TicketID < o.TicketID
+}
+
+func (p position) token() string {
+	b, _ := json.Marshal(p)
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+func parsePageToken(token string, sortKeys []string) (position, error) {
+	var p position
+	b, err := base64.RawURLEncoding.DecodeString(token)
+	if err == nil {
+		err = json.Unmarshal(b, &p)
+	}
+	if err != nil {
+		return p, fmt.Errorf("%w: malformed page token", util.ErrInvalidArgument)
+	}
+	if len(p.Keys) != len(sortKeys) || len(p.Values) != len(sortKeys) {
+		return p, fmt.Errorf("%w: page token does not match the requested sort keys", util.ErrInvalidArgument)
+	}
+	for i := range sortKeys {
+		if p.Keys[i] != sortKeys[i] {
+			return p, fmt.Errorf("%w: page token does not match the requested sort keys", util.ErrInvalidArgument)
+		}
+	}
+	return p, nil
+}
+
+func pageSize(page internal.Page) (int, error) {
+	switch {
+	case page.Size < 0:
+		return 0, fmt.Errorf("%w: negative page size", util.ErrInvalidArgument)
+	case page.Size == 0:
+		return defaultPageSize, nil
+	case page.Size > maxPageSize:
+		return maxPageSize, nil
+	}
+	return page.Size, nil
+}
+
+// paginate returns the page of the sorted docs described by page together
+// with the token of the page that follows it, which is empty on the last page.
+// positions are the positions of docs.
+func paginate(docs []internal.Document, positions []position, sortKeys []string, page internal.Page) ([]internal.Document, string, error) {
+	size, err := pageSize(page)
+	if err != nil {
+		return nil, "", err
+	}
+
+	start := 0
+	if page.Token != "" {
+		after, err := parsePageToken(page.Token, sortKeys)
+		if err != nil {
+			return nil, "", err
+		}
+		start = sort.Search(len(positions), func(i int) bool {
+			return after.less(positions[i])
+		})
+	}
+
+	end := start + size
+	if end >= len(docs) {
+		return docs[start:], "", nil
+	}
+	return docs[start:end], positions[end-1].token(), nil
+}
+
+// errPageFull stops the walk of findInOrder once the page is full.
+var errPageFull = errors.New("page is full")
+
+// findInOrder pages through the documents matching matchers in ticket ID
+// order, the order the repository walks them in. Unlike paginate, it only
+// reads the documents up to the end of the page, starting at the token.
+func findInOrder(ctx context.Context, repo Repository, matchers []internal.Filter, page internal.Page) ([]internal.Document, string, error) {
+	size, err := pageSize(page)
+	if err != nil {
+		return nil, "", err
+	}
+	var after string
+	if page.Token != "" {
+		p, err := parsePageToken(page.Token, nil)
+		if err != nil {
+			return nil, "", err
+		}
+		after = p.TicketID
+	}
+
+	// one more document than fits tells whether there is a next page
+	docs := make([]internal.Document, 0, size+1)
+	err = repo.WalkAfter(ctx, after, func(doc internal.Document) error {
+		if !matches(doc, matchers) {
+			return nil
+		}
+		docs = append(docs, doc)
+		if len(docs) > size {
+			return errPageFull
+		}
+		return nil
+	})
+	if err != nil && !errors.Is(err, errPageFull) {
+		return nil, "", err
+	}
+	if len(docs) <= size {
+		return docs, "", nil
+	}
+	docs = docs[:size]
+	return docs, newPosition(docs[size-1], nil).token(), nil
+}
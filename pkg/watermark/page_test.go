@@ -0,0 +1,255 @@
+package watermark
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"sync"
+	"testing"
+
+	"github.com/wzzfarewell/go-microservice-example/internal"
+	"github.com/wzzfarewell/go-microservice-example/internal/util"
+)
+
+// memRepository is a minimal Repository, the inmem one can't be imported
+// from here.
+type memRepository struct {
+	mtx  sync.Mutex
+	docs map[string]internal.Document
+}
+
+func newMemRepository(docs ...internal.Document) *memRepository {
+	r := &memRepository{docs: make(map[string]internal.Document)}
+	for _, doc := range docs {
+		r.docs[doc.TicketID] = doc
+	}
+	return r
+}
+
+func (r *memRepository) Create(_ context.Context, doc *internal.Document) error {
+	r.mtx.Lock()
+	defer r.mtx.Unlock()
+	if _, ok := r.docs[doc.TicketID]; ok {
+		return util.ErrDocumentExists
+	}
+	r.docs[doc.TicketID] = *doc
+	return nil
+}
+
+func (r *memRepository) Get(_ context.Context, ticketID string) (*internal.Document, error) {
+	r.mtx.Lock()
+	defer r.mtx.Unlock()
+	doc, ok := r.docs[ticketID]
+	if !ok {
+		return nil, util.ErrDocumentNotFound
+	}
+	return &doc, nil
+}
+
+func (r *memRepository) Update(_ context.Context, doc *internal.Document) error {
+	r.mtx.Lock()
+	defer r.mtx.Unlock()
+	if _, ok := r.docs[doc.TicketID]; !ok {
+		return util.ErrDocumentNotFound
+	}
+	r.docs[doc.TicketID] = *doc
+	return nil
+}
+
+func (r *memRepository) List(_ context.Context) ([]internal.Document, error) {
+	r.mtx.Lock()
+	defer r.mtx.Unlock()
+	docs := make([]internal.Document, 0, len(r.docs))
+	for _, doc := range r.docs {
+		docs = append(docs, doc)
+	}
+	sort.Slice(docs, func(i, j int) bool { return docs[i].TicketID < docs[j].TicketID })
+	return docs, nil
+}
+
+func (r *memRepository) Walk(ctx context.Context, fn func(internal.Document) error) error {
+	return r.WalkAfter(ctx, "", fn)
+}
+
+func (r *memRepository) WalkAfter(ctx context.Context, after string, fn func(internal.Document) error) error {
+	docs, _ := r.List(ctx)
+	for _, doc := range docs {
+		if doc.TicketID <= after {
+			continue
+		}
+		if err := fn(doc); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func testDocuments(n int) []internal.Document {
+	docs := make([]internal.Document, n)
+	for i := range docs {
+		// even IDs leave room to insert before and after every document
+		docs[i] = internal.Document{
+			TicketID: fmt.Sprintf("t%03d", 2*i),
+			Title:    fmt.Sprintf("title %02d", (n-i)%7),
+			Author:   fmt.Sprintf("author %d", i%2),
+		}
+	}
+	return docs
+}
+
+// findFunc returns one page, the way Find does either with or without sort
+// keys.
+type findFunc func(repo Repository, page internal.Page) ([]internal.Document, string, error)
+
+func findSorted(filters ...internal.Filter) findFunc {
+	return func(repo Repository, page internal.Page) ([]internal.Document, string, error) {
+		matchers, sortKeys, err := parseFilters(filters)
+		if err != nil {
+			return nil, "", err
+		}
+		docs, err := repo.List(context.Background())
+		if err != nil {
+			return nil, "", err
+		}
+		docs, positions := applyFilters(docs, matchers, sortKeys)
+		return paginate(docs, positions, sortKeys, page)
+	}
+}
+
+func findUnsorted(matchers ...internal.Filter) findFunc {
+	return func(repo Repository, page internal.Page) ([]internal.Document, string, error) {
+		return findInOrder(context.Background(), repo, matchers, page)
+	}
+}
+
+func TestPaginationWithConcurrentInserts(t *testing.T) {
+	for _, tc := range []struct {
+		name string
+		find findFunc
+	}{
+		{"ticket ID order", findUnsorted()},
+		{"sorted by title", findSorted(internal.Filter{Key: "title"})},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			original := testDocuments(25)
+			repo := newMemRepository(original...)
+			seen := make(map[string]int)
+			page := internal.Page{Size: 4}
+			for i := 0; ; i++ {
+				docs, next, err := tc.find(repo, page)
+				if err != nil {
+					t.Fatal(err)
+				}
+				for _, doc := range docs {
+					seen[doc.TicketID]++
+				}
+				if next == "" {
+					break
+				}
+				if i > 100 {
+					t.Fatal("pagination doesn't end")
+				}
+				// insert documents before the first and after the last one
+				// of the page, between the requests for the pages
+				for _, id := range []string{fmt.Sprintf("a%03d", i), fmt.Sprintf("u%03d", i)} {
+					doc := internal.Document{TicketID: id, Title: fmt.Sprintf("title %02d", i%9)}
+					if err := repo.Create(context.Background(), &doc); err != nil {
+						t.Fatal(err)
+					}
+				}
+				page.Token = next
+			}
+			for id, n := range seen {
+				if n > 1 {
+					t.Errorf("document %s returned %d times", id, n)
+				}
+			}
+			for _, doc := range original {
+				if seen[doc.TicketID] != 1 {
+					t.Errorf("document %s skipped", doc.TicketID)
+				}
+			}
+		})
+	}
+}
+
+func TestPaginationPages(t *testing.T) {
+	docs := testDocuments(10)
+	for _, tc := range []struct {
+		name string
+		find findFunc
+		want []string
+	}{
+		{"ticket ID order", findUnsorted(), []string{"t000", "t002", "t004", "t006", "t008", "t010", "t012", "t014", "t016", "t018"}},
+		{"filtered", findUnsorted(internal.Filter{Key: "author", Value: "author 1"}), []string{"t002", "t006", "t010", "t014", "t018"}},
+		{"sorted by title", findSorted(internal.Filter{Key: "title"}), []string{"t006", "t004", "t018", "t002", "t016", "t000", "t014", "t012", "t010", "t008"}},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			repo := newMemRepository(docs...)
+			var got []string
+			page := internal.Page{Size: 3}
+			for {
+				docs, next, err := tc.find(repo, page)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if len(docs) > page.Size {
+					t.Fatalf("page of %d documents, want at most %d", len(docs), page.Size)
+				}
+				for _, doc := range docs {
+					got = append(got, doc.TicketID)
+				}
+				if next == "" {
+					break
+				}
+				page.Token = next
+			}
+			if fmt.Sprint(got) != fmt.Sprint(tc.want) {
+				t.Fatalf("got %v, want %v", got, tc.want)
+			}
+		})
+	}
+}
+
+func TestPageSize(t *testing.T) {
+	for _, tc := range []struct {
+		size, want int
+	}{
+		{0, defaultPageSize},
+		{5, 5},
+		{maxPageSize + 1, maxPageSize},
+	} {
+		if got, err := pageSize(internal.Page{Size: tc.size}); err != nil || got != tc.want {
+			t.Errorf("pageSize(%d) = %d, %v, want %d", tc.size, got, err, tc.want)
+		}
+	}
+	if _, err := pageSize(internal.Page{Size: -1}); !errors.Is(err, util.ErrInvalidArgument) {
+		t.Errorf("pageSize(-1) = %v, want an invalid argument", err)
+	}
+}
+
+func TestInvalidPageTokens(t *testing.T) {
+	repo := newMemRepository(testDocuments(5)...)
+	_, sortedToken, err := findSorted(internal.Filter{Key: "title"})(repo, internal.Page{Size: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, tc := range []struct {
+		name  string
+		find  findFunc
+		token string
+	}{
+		{"malformed", findUnsorted(), "%%%"},
+		{"not JSON", findUnsorted(), "bm90IGpzb24"},
+		{"other sort keys", findUnsorted(), sortedToken},
+		{"other sort keys, sorted", findSorted(internal.Filter{Key: "author"}), sortedToken},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			_, _, err := tc.find(repo, internal.Page{Token: tc.token})
+			if !errors.Is(err, util.ErrInvalidArgument) {
+				t.Fatalf("got %v, want an invalid argument", err)
+			}
+		})
+	}
+}
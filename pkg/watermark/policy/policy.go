@@ -0,0 +1,162 @@
+// Package policy authorizes the calls of authenticated principals by role,
+// and confines them to the documents of their tenant.
+package policy
+
+import (
+	"context"
+	"errors"
+	"io"
+
+	"github.com/wzzfarewell/go-microservice-example/internal"
+	"github.com/wzzfarewell/go-microservice-example/pkg/watermark"
+	"github.com/wzzfarewell/go-microservice-example/pkg/watermark/auth"
+	wmerrors "github.com/wzzfarewell/go-microservice-example/pkg/watermark/errors"
+)
+
+// The roles a principal can be granted. Each one allows what the previous
+// one does: readers can look documents up, submitters can also create and
+// watermark them, and admins can do so across all tenants.
+const (
+	RoleReader    = "reader"
+	RoleSubmitter = "submitter"
+	RoleAdmin     = auth.RoleAdmin
+)
+
+// grants lists the roles that allow a call needing a role.
+var grants = map[string][]string{
+	RoleReader:    {RoleReader, RoleSubmitter, RoleAdmin},
+	RoleSubmitter: {RoleSubmitter, RoleAdmin},
+	RoleAdmin:     {RoleAdmin},
+}
+
+// authorize checks that the principal of ctx has role, and returns ctx
+// scoped to the documents of its tenant.
+func authorize(ctx context.Context, role string) (context.Context, error) {
+	p, ok := auth.PrincipalFromContext(ctx)
+	if !ok {
+		return ctx, auth.ErrMissingCredentials
+	}
+	if !allowed(p, role) {
+		return ctx, &wmerrors.PermissionDenied{Message: "the " + role + " role is required"}
+	}
+	// the authenticator rejects such principals already, but an empty tenant
+	// must never reach the repository: it would match every tenantless document
+	if p.NeedsTenant() {
+		return ctx, &wmerrors.PermissionDenied{Message: "the principal belongs to no tenant"}
+	}
+	scope := watermark.Scope{TenantID: p.TenantID, AllTenants: p.HasRole(RoleAdmin)}
+	return watermark.ContextWithScope(ctx, scope), nil
+}
+
+func allowed(p auth.Principal, role string) bool {
+	for _, r := range grants[role] {
+		if p.HasRole(r) {
+			return true
+		}
+	}
+	return false
+}
+
+type service struct {
+	next watermark.Service
+}
+
+// NewService authorizes the calls to next. It expects the endpoints to have
+// authenticated the caller.
+func NewService(next watermark.Service) watermark.Service {
+	return &service{next: next}
+}
+
+func (s *service) Find(ctx context.Context, page internal.Page, filters ...internal.Filter) ([]internal.Document, string, error) {
+	ctx, err := authorize(ctx, RoleReader)
+	if err != nil {
+		return nil, "", err
+	}
+	return s.next.Find(ctx, page, filters...)
+}
+
+func (s *service) Status(ctx context.Context, ticketID string) (internal.Status, error) {
+	ctx, err := authorize(ctx, RoleReader)
+	if err != nil {
+		return "", err
+	}
+	return s.next.Status(ctx, ticketID)
+}
+
+func (s *service) Watermark(ctx context.Context, ticketID, mark string) (int, error) {
+	ctx, err := authorize(ctx, RoleSubmitter)
+	if err != nil {
+		return wmerrors.HTTPStatus(err), err
+	}
+	return s.next.Watermark(ctx, ticketID, mark)
+}
+
+func (s *service) CreateDocument(ctx context.Context, doc *internal.Document, content io.Reader) (string, error) {
+	ctx, err := authorize(ctx, RoleSubmitter)
+	if err != nil {
+		return "", err
+	}
+	// admins see every tenant but create documents in their own
+	scope, _ := watermark.ScopeFromContext(ctx)
+	scope.AllTenants = false
+	return s.next.CreateDocument(watermark.ContextWithScope(ctx, scope), doc, content)
+}
+
+// ServiceStatus is open to any authenticated caller.
+func (s *service) ServiceStatus(ctx context.Context) (int, error) {
+	return s.next.ServiceStatus(ctx)
+}
+
+// Extract only reports tickets the caller could look up itself, so a copy of
+// another tenant's document is reported as carrying no watermark.
+func (s *service) Extract(ctx context.Context, content []byte) (string, string, error) {
+	ctx, err := authorize(ctx, RoleReader)
+	if err != nil {
+		return "", "", err
+	}
+	ticketID, mark, err := s.next.Extract(ctx, content)
+	if err != nil {
+		return "", "", err
+	}
+	if _, err := s.next.Status(ctx, ticketID); err != nil {
+		var notFound *wmerrors.NotFound
+		if errors.As(err, &notFound) {
+			return "", "", &wmerrors.NotFound{Message: "no watermark found"}
+		}
+		return "", "", err
+	}
+	return ticketID, mark, nil
+}
+
+type streamer struct {
+	next watermark.Streamer
+}
+
+// NewStreamer authorizes the calls of the streams like NewService does.
+func NewStreamer(next watermark.Streamer) watermark.Streamer {
+	return &streamer{next: next}
+}
+
+func (s *streamer) WatchStatus(ctx context.Context, ticketID string) (<-chan internal.Status, error) {
+	ctx, err := authorize(ctx, RoleReader)
+	if err != nil {
+		return nil, err
+	}
+	return s.next.WatchStatus(ctx, ticketID)
+}
+
+func (s *streamer) FindStream(ctx context.Context, send func(internal.Document) error, filters ...internal.Filter) error {
+	ctx, err := authorize(ctx, RoleReader)
+	if err != nil {
+		return err
+	}
+	return s.next.FindStream(ctx, send, filters...)
+}
+
+func (s *streamer) OpenContent(ctx context.Context, ticketID string, watermarked bool) (*watermark.Content, error) {
+	ctx, err := authorize(ctx, RoleReader)
+	if err != nil {
+		return nil, err
+	}
+	return s.next.OpenContent(ctx, ticketID, watermarked)
+}
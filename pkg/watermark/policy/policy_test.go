@@ -0,0 +1,137 @@
+package policy
+
+import (
+	"context"
+	"errors"
+	"io"
+	"strings"
+	"testing"
+
+	"github.com/go-kit/log"
+	"github.com/wzzfarewell/go-microservice-example/internal"
+	"github.com/wzzfarewell/go-microservice-example/pkg/watermark"
+	"github.com/wzzfarewell/go-microservice-example/pkg/watermark/auth"
+	wmerrors "github.com/wzzfarewell/go-microservice-example/pkg/watermark/errors"
+	"github.com/wzzfarewell/go-microservice-example/pkg/watermark/inmem"
+)
+
+func TestAuthorize(t *testing.T) {
+	for _, tc := range []struct {
+		name      string
+		principal *auth.Principal
+		role      string
+		want      watermark.Scope
+		wantErr   interface{}
+	}{
+		{"no principal", nil, RoleReader, watermark.Scope{}, new(*wmerrors.Unauthenticated)},
+		{"reader reads", &auth.Principal{Roles: []string{RoleReader}, TenantID: "red"}, RoleReader, watermark.Scope{TenantID: "red"}, nil},
+		{"reader submits", &auth.Principal{Roles: []string{RoleReader}, TenantID: "red"}, RoleSubmitter, watermark.Scope{}, new(*wmerrors.PermissionDenied)},
+		{"submitter reads", &auth.Principal{Roles: []string{RoleSubmitter}, TenantID: "red"}, RoleReader, watermark.Scope{TenantID: "red"}, nil},
+		{"no roles", &auth.Principal{TenantID: "red"}, RoleReader, watermark.Scope{}, new(*wmerrors.PermissionDenied)},
+		{"admin", &auth.Principal{Roles: []string{RoleAdmin}, TenantID: "red"}, RoleSubmitter, watermark.Scope{TenantID: "red", AllTenants: true}, nil},
+		{"admin without tenant", &auth.Principal{Roles: []string{RoleAdmin}}, RoleReader, watermark.Scope{AllTenants: true}, nil},
+		// would otherwise see every tenantless document
+		{"reader without tenant", &auth.Principal{Roles: []string{RoleReader}}, RoleReader, watermark.Scope{}, new(*wmerrors.PermissionDenied)},
+		{"submitter without tenant", &auth.Principal{Roles: []string{RoleSubmitter}}, RoleSubmitter, watermark.Scope{}, new(*wmerrors.PermissionDenied)},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			ctx := context.Background()
+			if tc.principal != nil {
+				ctx = auth.ContextWithPrincipal(ctx, *tc.principal)
+			}
+			ctx, err := authorize(ctx, tc.role)
+			if tc.wantErr != nil {
+				if !errors.As(err, tc.wantErr) {
+					t.Fatalf("got %v, want %T", err, tc.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if scope, ok := watermark.ScopeFromContext(ctx); !ok || scope != tc.want {
+				t.Fatalf("scope %+v, want %+v", scope, tc.want)
+			}
+		})
+	}
+}
+
+// markedCopy has the worker pool watermark a text document of the blue
+// tenant and returns the marked content, with the service it can be
+// extracted with, wired like in main.
+func markedCopy(t *testing.T) ([]byte, watermark.Service) {
+	t.Helper()
+	ctx := context.Background()
+	repo := inmem.NewRepository()
+	blobs := inmem.NewBlobStore()
+	events := watermark.NewHub()
+	workers := watermark.NewWorkerPool(repo, blobs, events, watermark.DefaultMarkers(), watermark.DefaultEmbedders(), 1, 1, log.NewNopLogger())
+
+	digest, size, err := blobs.Put(ctx, strings.NewReader("text\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	doc := internal.Document{TicketID: "b1", TenantID: "blue", Status: internal.Started, ContentType: watermark.ContentTypeText, ContentDigest: digest, ContentSize: size}
+	if err := repo.Create(ctx, &doc); err != nil {
+		t.Fatal(err)
+	}
+	if err := workers.Enqueue(watermark.Job{TicketID: "b1", Mark: "mark"}); err != nil {
+		t.Fatal(err)
+	}
+	go workers.Run()
+	if err := workers.Shutdown(ctx); err != nil {
+		t.Fatal(err)
+	}
+	marked, err := repo.Get(ctx, "b1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if marked.Status != internal.Finished {
+		t.Fatalf("b1 is %s, want %s", marked.Status, internal.Finished)
+	}
+	r, _, err := blobs.Get(ctx, marked.MarkedDigest)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+	content, err := io.ReadAll(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	svc := watermark.NewService(watermark.NewScopedRepository(repo), blobs, workers, events, new(watermark.Readiness), log.NewNopLogger())
+	return content, NewService(svc)
+}
+
+func TestExtractTenantIsolation(t *testing.T) {
+	content, svc := markedCopy(t)
+	for _, tc := range []struct {
+		name      string
+		principal auth.Principal
+		found     bool
+	}{
+		{"same tenant", auth.Principal{Roles: []string{RoleReader}, TenantID: "blue"}, true},
+		{"other tenant", auth.Principal{Roles: []string{RoleReader}, TenantID: "red"}, false},
+		{"admin of another tenant", auth.Principal{Roles: []string{RoleAdmin}, TenantID: "red"}, true},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			ctx := auth.ContextWithPrincipal(context.Background(), tc.principal)
+			ticketID, mark, err := svc.Extract(ctx, content)
+			if !tc.found {
+				var notFound *wmerrors.NotFound
+				if !errors.As(err, &notFound) || ticketID != "" || mark != "" {
+					t.Fatalf("got %q, %q, %v, want a NotFound error", ticketID, mark, err)
+				}
+				// the same error as a copy without any watermark
+				_, _, plain := svc.Extract(ctx, []byte("text\n"))
+				if err.Error() != plain.Error() {
+					t.Fatalf("got %q, a copy without watermark gets %q", err, plain)
+				}
+				return
+			}
+			if err != nil || ticketID != "b1" || mark != "mark" {
+				t.Fatalf("got %q, %q, %v", ticketID, mark, err)
+			}
+		})
+	}
+}
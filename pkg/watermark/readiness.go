@@ -0,0 +1,19 @@
+package watermark
+
+import "sync/atomic"
+
+// Readiness tells whether the service should be sent new requests. It
+// starts out not ready, and is flipped back to not ready when a graceful
+// shutdown begins so load balancers stop routing to the instance while it
+// drains.
+type Readiness struct {
+	ready atomic.Bool
+}
+
+func (r *Readiness) SetReady(ready bool) {
+	r.ready.Store(ready)
+}
+
+func (r *Readiness) Ready() bool {
+	return r.ready.Load()
+}
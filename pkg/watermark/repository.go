@@ -0,0 +1,23 @@
+package watermark
+
+import (
+	"context"
+
+	"github.com/wzzfarewell/go-microservice-example/internal"
+)
+
+// Repository stores documents keyed by their ticket ID.
+type Repository interface {
+	Create(ctx context.Context, doc *internal.Document) error
+	Get(ctx context.Context, ticketID string) (*internal.Document, error)
+	Update(ctx context.Context, doc *internal.Document) error
+	List(ctx context.Context) ([]internal.Document, error)
+
+	// Walk calls fn for every document in ticket ID order, stopping at the
+	// first error fn returns.
+	Walk(ctx context.Context, fn func(internal.Document) error) error
+
+	// WalkAfter is Walk starting after the document with the ticket ID
+	// after, which doesn't need to exist, rather than at the first one.
+	WalkAfter(ctx context.Context, after string, fn func(internal.Document) error) error
+}
@@ -0,0 +1,149 @@
+package s3
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+
+	"github.com/minio/minio-go/v7"
+	"github.com/minio/minio-go/v7/pkg/credentials"
+	"github.com/wzzfarewell/go-microservice-example/internal/util"
+	"github.com/wzzfarewell/go-microservice-example/pkg/watermark"
+)
+
+// Config locates the bucket blobs are stored in. Any S3 compatible server
+// works, e.g. a local MinIO, as buckets are addressed by path.
+type Config struct {
+	Endpoint  string // host[:port], without scheme
+	Bucket    string
+	Region    string
+	AccessKey string
+	SecretKey string
+	Secure    bool // use https
+
+	// Prefix is prepended to the digest to form the object key.
+	Prefix string
+}
+
+// BlobStore is a watermark.BlobStore backed by an S3 bucket, with one object
+// per blob keyed by its digest.
+type BlobStore struct {
+	client *minio.Client
+	bucket string
+	prefix string
+}
+
+// NewBlobStore connects to the server and creates the bucket if it doesn't
+// exist yet.
+func NewBlobStore(ctx context.Context, cfg Config) (*BlobStore, error) {
+	client, err := minio.New(cfg.Endpoint, &minio.Options{
+		Creds:        credentials.NewStaticV4(cfg.AccessKey, cfg.SecretKey, ""),
+		Secure:       cfg.Secure,
+		Region:       cfg.Region,
+		BucketLookup: minio.BucketLookupPath,
+	})
+	if err != nil {
+		return nil, err
+	}
+	exists, err := client.BucketExists(ctx, cfg.Bucket)
+	if err != nil {
+		return nil, err
+	}
+	if !exists {
+		if err := client.MakeBucket(ctx, cfg.Bucket, minio.MakeBucketOptions{Region: cfg.Region}); err != nil {
+			return nil, err
+		}
+	}
+	return &BlobStore{client: client, bucket: cfg.Bucket, prefix: cfg.Prefix}, nil
+}
+
+// Put spools the content to a temporary file while hashing it, as the object
+// key is only known once the whole content has been read, and uploads it
+// unless an object with the same digest already exists.
+func (s *BlobStore) Put(ctx context.Context, r io.Reader) (string, int64, error) {
+	tmp, err := os.CreateTemp("", "watermark-blob-*")
+	if err != nil {
+		return "", 0, err
+	}
+	defer os.Remove(tmp.Name())
+	defer tmp.Close()
+	h := sha256.New()
+	size, err := io.Copy(io.MultiWriter(tmp, h), r)
+	if err != nil {
+		return "", 0, err
+	}
+	digest := hex.EncodeToString(h.Sum(nil))
+
+	_, err = s.client.StatObject(ctx, s.bucket, s.key(digest), minio.StatObjectOptions{})
+	switch {
+	case err == nil:
+		return digest, size, nil
+	case !isNotFound(err):
+		return "", 0, err
+	}
+	if _, err := tmp.Seek(0, io.SeekStart); err != nil {
+		return "", 0, err
+	}
+	_, err = s.client.PutObject(ctx, s.bucket, s.key(digest), tmp, size, minio.PutObjectOptions{
+		ContentType: "application/octet-stream",
+	})
+	if err != nil {
+		return "", 0, err
+	}
+	return digest, size, nil
+}
+
+func (s *BlobStore) Get(ctx context.Context, digest string) (io.ReadCloser, int64, error) {
+	if err := watermark.CheckDigest(digest); err != nil {
+		return nil, 0, err
+	}
+	obj, err := s.client.GetObject(ctx, s.bucket, s.key(digest), minio.GetObjectOptions{})
+	if err != nil {
+		return nil, 0, err
+	}
+	// GetObject is lazy, Stat issues the request and reports a missing key
+	info, err := obj.Stat()
+	if err != nil {
+		obj.Close()
+		if isNotFound(err) {
+			return nil, 0, util.ErrBlobNotFound
+		}
+		return nil, 0, err
+	}
+	return obj, info.Size, nil
+}
+
+func (s *BlobStore) Delete(ctx context.Context, digest string) error {
+	if err := watermark.CheckDigest(digest); err != nil {
+		return err
+	}
+	err := s.client.RemoveObject(ctx, s.bucket, s.key(digest), minio.RemoveObjectOptions{})
+	if err != nil && !isNotFound(err) {
+		return err
+	}
+	return nil
+}
+
+func (s *BlobStore) CheckHealth(ctx context.Context) error {
+	exists, err := s.client.BucketExists(ctx, s.bucket)
+	if err != nil {
+		return err
+	}
+	if !exists {
+		return fmt.Errorf("bucket %q does not exist", s.bucket)
+	}
+	return nil
+}
+
+func (s *BlobStore) key(digest string) string {
+	return s.prefix + digest
+}
+
+func isNotFound(err error) bool {
+	resp := minio.ToErrorResponse(err)
+	return resp.StatusCode == http.StatusNotFound || resp.Code == "NoSuchKey"
+}
@@ -0,0 +1,209 @@
+package s3
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/wzzfarewell/go-microservice-example/internal/util"
+)
+
+// fakeS3 serves the few S3 calls the blob store makes from memory: HEAD and
+// PUT of buckets, and HEAD, GET, PUT and DELETE of objects, addressed by path.
+type fakeS3 struct {
+	mtx     sync.Mutex
+	buckets map[string]bool
+	objects map[string][]byte
+	puts    int
+}
+
+func newFakeS3(t *testing.T) (*fakeS3, string) {
+	f := &fakeS3{buckets: make(map[string]bool), objects: make(map[string][]byte)}
+	server := httptest.NewServer(f)
+	t.Cleanup(server.Close)
+	return f, strings.TrimPrefix(server.URL, "http://")
+}
+
+func (f *fakeS3) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.mtx.Lock()
+	defer f.mtx.Unlock()
+	bucket, key, _ := strings.Cut(strings.TrimPrefix(r.URL.Path, "/"), "/")
+	if key == "" {
+		switch r.Method {
+		case http.MethodHead:
+			if !f.buckets[bucket] {
+				w.WriteHeader(http.StatusNotFound)
+			}
+		case http.MethodPut:
+			f.buckets[bucket] = true
+		default:
+			w.WriteHeader(http.StatusNotImplemented)
+		}
+		return
+	}
+	if !f.buckets[bucket] {
+		writeS3Error(w, http.StatusNotFound, "NoSuchBucket")
+		return
+	}
+	object := bucket + "/" + key
+	switch r.Method {
+	case http.MethodHead, http.MethodGet:
+		content, ok := f.objects[object]
+		if !ok {
+			writeS3Error(w, http.StatusNotFound, "NoSuchKey")
+			return
+		}
+		w.Header().Set("Content-Length", strconv.Itoa(len(content)))
+		w.Header().Set("ETag", `"etag"`)
+		w.Header().Set("Last-Modified", time.Now().UTC().Format(http.TimeFormat))
+		if r.Method == http.MethodGet {
+			w.Write(content)
+		}
+	case http.MethodPut:
+		content, err := readS3Body(r)
+		if err != nil {
+			writeS3Error(w, http.StatusBadRequest, "IncompleteBody")
+			return
+		}
+		f.objects[object] = content
+		f.puts++
+		w.Header().Set("ETag", `"etag"`)
+	case http.MethodDelete:
+		delete(f.objects, object)
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		w.WriteHeader(http.StatusNotImplemented)
+	}
+}
+
+// readS3Body decodes the aws-chunked encoding the client uses over plain
+// HTTP: every chunk is preceded by a line of its hex size and signature.
+func readS3Body(r *http.Request) ([]byte, error) {
+	if !strings.HasPrefix(r.Header.Get("X-Amz-Content-Sha256"), "STREAMING-") {
+		return io.ReadAll(r.Body)
+	}
+	var content bytes.Buffer
+	br := bufio.NewReader(r.Body)
+	for {
+		line, err := br.ReadString('\n')
+		if err != nil {
+			return nil, err
+		}
+		sizeHex, _, _ := strings.Cut(strings.TrimSpace(line), ";")
+		size, err := strconv.ParseInt(sizeHex, 16, 64)
+		if err != nil {
+			return nil, err
+		}
+		if size == 0 {
+			return content.Bytes(), nil
+		}
+		if _, err := io.CopyN(&content, br, size); err != nil {
+			return nil, err
+		}
+		if _, err := br.Discard(2); err != nil { // \r\n
+			return nil, err
+		}
+	}
+}
+
+func writeS3Error(w http.ResponseWriter, status int, code string) {
+	w.Header().Set("Content-Type", "application/xml")
+	w.WriteHeader(status)
+	fmt.Fprintf(w, "<Error><Code>%s</Code><Message>%s</Message></Error>", code, code)
+}
+
+func newTestBlobStore(t *testing.T) (*BlobStore, *fakeS3) {
+	t.Helper()
+	fake, endpoint := newFakeS3(t)
+	store, err := NewBlobStore(context.Background(), Config{
+		Endpoint:  endpoint,
+		Bucket:    "blobs",
+		Region:    "us-east-1",
+		AccessKey: "access",
+		SecretKey: "secret",
+		Prefix:    "docs/",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return store, fake
+}
+
+func TestNewBlobStoreCreatesBucket(t *testing.T) {
+	store, fake := newTestBlobStore(t)
+	if !fake.buckets["blobs"] {
+		t.Fatal("bucket not created")
+	}
+	if err := store.CheckHealth(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestBlobStore(t *testing.T) {
+	ctx := context.Background()
+	store, fake := newTestBlobStore(t)
+
+	digest, size, err := store.Put(ctx, strings.NewReader("content"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	// sha256("content")
+	const want = "ed7002b439e9ac845f22357d822bac1444730fbdb6016d3ec9432297b9ec9f73"
+	if digest != want || size != 7 {
+		t.Fatalf("Put = %s, %d, want %s, 7", digest, size, want)
+	}
+	if _, ok := fake.objects["blobs/docs/"+digest]; !ok {
+		t.Fatalf("no object at the prefixed key, got %v", fake.objects)
+	}
+
+	rc, size, err := store.Get(ctx, digest)
+	if err != nil {
+		t.Fatal(err)
+	}
+	content, err := io.ReadAll(rc)
+	rc.Close()
+	if err != nil || string(content) != "content" || size != 7 {
+		t.Fatalf("Get = %q, %d, %v", content, size, err)
+	}
+
+	// the same content is not uploaded twice
+	if _, _, err := store.Put(ctx, strings.NewReader("content")); err != nil {
+		t.Fatal(err)
+	}
+	if fake.puts != 1 {
+		t.Fatalf("%d uploads, want 1", fake.puts)
+	}
+
+	if err := store.Delete(ctx, digest); err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := store.Get(ctx, digest); !errors.Is(err, util.ErrBlobNotFound) {
+		t.Fatalf("Get after Delete = %v, want %v", err, util.ErrBlobNotFound)
+	}
+	// deleting a missing blob is not an error
+	if err := store.Delete(ctx, digest); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestBlobStoreRejectsMalformedDigests(t *testing.T) {
+	store, _ := newTestBlobStore(t)
+	for _, digest := range []string{"", "../etc/passwd", strings.Repeat("A", 64)} {
+		if _, _, err := store.Get(context.Background(), digest); !errors.Is(err, util.ErrInvalidArgument) {
+			t.Errorf("Get(%q) = %v, want an invalid argument", digest, err)
+		}
+		if err := store.Delete(context.Background(), digest); !errors.Is(err, util.ErrInvalidArgument) {
+			t.Errorf("Delete(%q) = %v, want an invalid argument", digest, err)
+		}
+	}
+}
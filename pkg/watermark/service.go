@@ -2,14 +2,16 @@ package watermark
 
 import (
 	"context"
+	"io"
 
 	"github.com/wzzfarewell/go-microservice-example/internal"
 )
 
 type Service interface {
-	Find(ctx context.Context, filters ...internal.Filter) ([]internal.Document, error)
+	Find(ctx context.Context, page internal.Page, filters ...internal.Filter) ([]internal.Document, string, error)
 	Status(ctx context.Context, ticketID string) (internal.Status, error)
 	Watermark(ctx context.Context, ticketID, mark string) (int, error)
-	CreateDocument(ctx context.Context, doc *internal.Document) (string, error)
+	CreateDocument(ctx context.Context, doc *internal.Document, content io.Reader) (string, error)
 	ServiceStatus(ctx context.Context) (int, error)
+	Extract(ctx context.Context, content []byte) (ticketID string, mark string, err error)
 }
@@ -0,0 +1,140 @@
+package watermark
+
+import (
+	"context"
+	"fmt"
+	"io"
+
+	"github.com/wzzfarewell/go-microservice-example/internal"
+	"github.com/wzzfarewell/go-microservice-example/pkg/watermark/errors"
+)
+
+// Streamer pushes results to the caller as they become available instead of
+// answering once. It backs the streaming transports only, so unlike Service
+// it has no client implementation.
+type Streamer interface {
+	// WatchStatus sends the current status of the ticket followed by every
+	// transition until the ticket reaches a terminal status or ctx is done,
+	// then closes the channel.
+	WatchStatus(ctx context.Context, ticketID string) (<-chan internal.Status, error)
+
+	// FindStream calls send for every document matching the filters. Without
+	// sort keys the documents are sent as they are read from the repository,
+	// in ticket ID order; sorting requires reading every match first.
+	FindStream(ctx context.Context, send func(internal.Document) error, filters ...internal.Filter) error
+
+	// OpenContent opens the original content of the document, or the
+	// watermarked one once the ticket is Finished. The caller must close it.
+	OpenContent(ctx context.Context, ticketID string, watermarked bool) (*Content, error)
+}
+
+// Content is an open document body.
+type Content struct {
+	io.ReadCloser
+	ContentType string
+	Size        int64
+}
+
+type streamer struct {
+	repo   Repository
+	blobs  BlobStore
+	events *Hub
+}
+
+func NewStreamer(repo Repository, blobs BlobStore, events *Hub) Streamer {
+	return &streamer{repo: repo, blobs: blobs, events: events}
+}
+
+func (s *streamer) WatchStatus(ctx context.Context, ticketID string) (<-chan internal.Status, error) {
+	// subscribe before reading the current status so no transition is missed
+	events, cancel := s.events.Subscribe(ticketID)
+	doc, err := s.repo.Get(ctx, ticketID)
+	if err != nil {
+		cancel()
+		return nil, err
+	}
+
+	statuses := make(chan internal.Status)
+	go func() {
+		defer close(statuses)
+		defer cancel()
+		last := doc.Status
+		select {
+		case statuses <- last:
+		case <-ctx.Done():
+			return
+		}
+		for !last.Terminal() {
+			select {
+			case event, ok := <-events:
+				if !ok {
+					return
+				}
+				if event.Status == last {
+					continue
+				}
+				last = event.Status
+				select {
+				case statuses <- last:
+				case <-ctx.Done():
+					return
+				}
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+	return statuses, nil
+}
+
+func (s *streamer) FindStream(ctx context.Context, send func(internal.Document) error, filters ...internal.Filter) error {
+	matchers, sortKeys, err := parseFilters(filters)
+	if err != nil {
+		return err
+	}
+	if len(sortKeys) > 0 {
+		docs, err := s.repo.List(ctx)
+		if err != nil {
+			return err
+		}
+		docs, _ = applyFilters(docs, matchers, sortKeys)
+		for _, doc := range docs {
+			if err := send(doc); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	return s.repo.Walk(ctx, func(doc internal.Document) error {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		if !matches(doc, matchers) {
+			return nil
+		}
+		return send(doc)
+	})
+}
+
+func (s *streamer) OpenContent(ctx context.Context, ticketID string, watermarked bool) (*Content, error) {
+	doc, err := s.repo.Get(ctx, ticketID)
+	if err != nil {
+		return nil, err
+	}
+	contentType, err := normalizeContentType(doc.ContentType)
+	if err != nil {
+		return nil, err
+	}
+	digest := doc.ContentDigest
+	if watermarked {
+		if doc.Status != internal.Finished {
+			return nil, &errors.Conflict{Message: fmt.Sprintf("ticket is %s, the watermarked content is available once it is %s", doc.Status, internal.Finished)}
+		}
+		digest = doc.MarkedDigest
+	}
+	rc, size, err := s.blobs.Get(ctx, digest)
+	if err != nil {
+		return nil, err
+	}
+	return &Content{ReadCloser: rc, ContentType: contentType, Size: size}, nil
+}
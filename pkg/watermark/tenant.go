@@ -0,0 +1,126 @@
+package watermark
+
+import (
+	"context"
+
+	"github.com/wzzfarewell/go-microservice-example/internal"
+	"github.com/wzzfarewell/go-microservice-example/internal/util"
+)
+
+// Scope restricts the documents a call can see to those of one tenant,
+// unless AllTenants is set. Calls without a Scope, e.g. the ones of the
+// worker pool, see every document.
+type Scope struct {
+	TenantID   string
+	AllTenants bool
+}
+
+func (s Scope) allows(doc *internal.Document) bool {
+	return s.AllTenants || doc.TenantID == s.TenantID
+}
+
+type scopeContextKey struct{}
+
+// ContextWithScope returns a copy of ctx restricted to scope.
+func ContextWithScope(ctx context.Context, scope Scope) context.Context {
+	return context.WithValue(ctx, scopeContextKey{}, scope)
+}
+
+// ScopeFromContext returns the scope ctx is restricted to, if any.
+func ScopeFromContext(ctx context.Context) (Scope, bool) {
+	scope, ok := ctx.Value(scopeContextKey{}).(Scope)
+	return scope, ok
+}
+
+type scopedRepository struct {
+	next Repository
+}
+
+// NewScopedRepository restricts every query to repo to the Scope of its
+// context. Documents of other tenants are reported as not found, so their
+// existence doesn't leak, and new documents are created in the tenant of
+// the scope.
+func NewScopedRepository(repo Repository) Repository {
+	return &scopedRepository{next: repo}
+}
+
+func (r *scopedRepository) Create(ctx context.Context, doc *internal.Document) error {
+	if scope, ok := ScopeFromContext(ctx); ok {
+		doc.TenantID = scope.TenantID
+	}
+	return r.next.Create(ctx, doc)
+}
+
+func (r *scopedRepository) Get(ctx context.Context, ticketID string) (*internal.Document, error) {
+	doc, err := r.next.Get(ctx, ticketID)
+	if err != nil {
+		return nil, err
+	}
+	if scope, ok := ScopeFromContext(ctx); ok && !scope.allows(doc) {
+		return nil, util.ErrDocumentNotFound
+	}
+	return doc, nil
+}
+
+// Update checks the stored document rather than doc, so a document can't be
+// moved into or out of the scope either.
+func (r *scopedRepository) Update(ctx context.Context, doc *internal.Document) error {
+	if scope, ok := ScopeFromContext(ctx); ok {
+		stored, err := r.next.Get(ctx, doc.TicketID)
+		if err != nil {
+			return err
+		}
+		if !scope.allows(stored) {
+			return util.ErrDocumentNotFound
+		}
+		doc.TenantID = stored.TenantID
+	}
+	return r.next.Update(ctx, doc)
+}
+
+func (r *scopedRepository) List(ctx context.Context) ([]internal.Document, error) {
+	docs, err := r.next.List(ctx)
+	if err != nil {
+		return nil, err
+	}
+	scope, ok := ScopeFromContext(ctx)
+	if !ok || scope.AllTenants {
+		return docs, nil
+	}
+	visible := docs[:0]
+	for _, doc := range docs {
+		if scope.allows(&doc) {
+			visible = append(visible, doc)
+		}
+	}
+	return visible, nil
+}
+
+func (r *scopedRepository) Walk(ctx context.Context, fn func(internal.Document) error) error {
+	return r.next.Walk(ctx, scoped(ctx, fn))
+}
+
+func (r *scopedRepository) WalkAfter(ctx context.Context, after string, fn func(internal.Document) error) error {
+	return r.next.WalkAfter(ctx, after, scoped(ctx, fn))
+}
+
+// scoped skips the documents outside the scope of ctx before calling fn.
+func scoped(ctx context.Context, fn func(internal.Document) error) func(internal.Document) error {
+	scope, ok := ScopeFromContext(ctx)
+	if !ok || scope.AllTenants {
+		return fn
+	}
+	return func(doc internal.Document) error {
+		if !scope.allows(&doc) {
+			return nil
+		}
+		return fn(doc)
+	}
+}
+
+func (r *scopedRepository) CheckHealth(ctx context.Context) error {
+	if checker, ok := r.next.(HealthChecker); ok {
+		return checker.CheckHealth(ctx)
+	}
+	return nil
+}
@@ -0,0 +1,152 @@
+package watermark
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"testing"
+
+	"github.com/wzzfarewell/go-microservice-example/internal"
+	"github.com/wzzfarewell/go-microservice-example/internal/util"
+)
+
+func newTenantRepository() (*memRepository, Repository) {
+	repo := newMemRepository(
+		internal.Document{TicketID: "b1", TenantID: "blue", Title: "blue"},
+		internal.Document{TicketID: "r1", TenantID: "red", Title: "red"},
+		internal.Document{TicketID: "r2", TenantID: "red", Title: "red"},
+	)
+	return repo, NewScopedRepository(repo)
+}
+
+// visibleIDs returns the ticket IDs List, Walk and WalkAfter("") return in
+// ctx, failing if they don't agree.
+func visibleIDs(t *testing.T, ctx context.Context, repo Repository) []string {
+	t.Helper()
+	docs, err := repo.List(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var listed, walked, walkedAfter []string
+	for _, doc := range docs {
+		listed = append(listed, doc.TicketID)
+	}
+	collect := func(ids *[]string) func(internal.Document) error {
+		return func(doc internal.Document) error {
+			*ids = append(*ids, doc.TicketID)
+			return nil
+		}
+	}
+	if err := repo.Walk(ctx, collect(&walked)); err != nil {
+		t.Fatal(err)
+	}
+	if err := repo.WalkAfter(ctx, "", collect(&walkedAfter)); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(listed, walked) || !reflect.DeepEqual(listed, walkedAfter) {
+		t.Fatalf("List %v, Walk %v and WalkAfter %v differ", listed, walked, walkedAfter)
+	}
+	return listed
+}
+
+func TestScopedRepositoryVisibility(t *testing.T) {
+	_, repo := newTenantRepository()
+	for _, tc := range []struct {
+		name string
+		ctx  context.Context
+		want []string
+	}{
+		{"red", ContextWithScope(context.Background(), Scope{TenantID: "red"}), []string{"r1", "r2"}},
+		{"blue", ContextWithScope(context.Background(), Scope{TenantID: "blue"}), []string{"b1"}},
+		{"other tenant", ContextWithScope(context.Background(), Scope{TenantID: "green"}), nil},
+		{"all tenants", ContextWithScope(context.Background(), Scope{TenantID: "red", AllTenants: true}), []string{"b1", "r1", "r2"}},
+		{"no scope", context.Background(), []string{"b1", "r1", "r2"}},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			if got := visibleIDs(t, tc.ctx, repo); !reflect.DeepEqual(got, tc.want) {
+				t.Fatalf("got %v, want %v", got, tc.want)
+			}
+			var walked []string
+			err := repo.WalkAfter(tc.ctx, "b1", func(doc internal.Document) error {
+				walked = append(walked, doc.TicketID)
+				return nil
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+			var want []string
+			for _, id := range tc.want {
+				if id > "b1" {
+					want = append(want, id)
+				}
+			}
+			if !reflect.DeepEqual(walked, want) {
+				t.Fatalf("WalkAfter(b1) got %v, want %v", walked, want)
+			}
+			for _, id := range []string{"b1", "r1"} {
+				_, err := repo.Get(tc.ctx, id)
+				visible := false
+				for _, v := range tc.want {
+					visible = visible || v == id
+				}
+				if visible && err != nil {
+					t.Errorf("Get(%s) = %v", id, err)
+				}
+				if !visible && !errors.Is(err, util.ErrDocumentNotFound) {
+					t.Errorf("Get(%s) = %v, want %v", id, err, util.ErrDocumentNotFound)
+				}
+			}
+		})
+	}
+}
+
+func TestScopedRepositoryUpdate(t *testing.T) {
+	mem, repo := newTenantRepository()
+	red := ContextWithScope(context.Background(), Scope{TenantID: "red"})
+
+	err := repo.Update(red, &internal.Document{TicketID: "b1", TenantID: "red", Title: "stolen"})
+	if !errors.Is(err, util.ErrDocumentNotFound) {
+		t.Fatalf("cross-tenant Update = %v, want %v", err, util.ErrDocumentNotFound)
+	}
+	if doc, _ := mem.Get(context.Background(), "b1"); doc.Title != "blue" || doc.TenantID != "blue" {
+		t.Fatalf("cross-tenant Update changed %+v", doc)
+	}
+
+	// a document can't be moved out of its tenant either
+	if err := repo.Update(red, &internal.Document{TicketID: "r1", TenantID: "blue", Title: "moved"}); err != nil {
+		t.Fatal(err)
+	}
+	if doc, _ := mem.Get(context.Background(), "r1"); doc.Title != "moved" || doc.TenantID != "red" {
+		t.Fatalf("updated document is %+v", doc)
+	}
+
+	all := ContextWithScope(context.Background(), Scope{AllTenants: true})
+	if err := repo.Update(all, &internal.Document{TicketID: "b1", Title: "admin"}); err != nil {
+		t.Fatal(err)
+	}
+	if doc, _ := mem.Get(context.Background(), "b1"); doc.Title != "admin" || doc.TenantID != "blue" {
+		t.Fatalf("updated document is %+v", doc)
+	}
+}
+
+func TestScopedRepositoryCreate(t *testing.T) {
+	mem, repo := newTenantRepository()
+	for _, tc := range []struct {
+		name, ticketID string
+		ctx            context.Context
+		want           string
+	}{
+		{"scope", "n1", ContextWithScope(context.Background(), Scope{TenantID: "red"}), "red"},
+		{"no scope", "n2", context.Background(), "blue"},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			// the tenant of the document itself is ignored under a scope
+			if err := repo.Create(tc.ctx, &internal.Document{TicketID: tc.ticketID, TenantID: "blue"}); err != nil {
+				t.Fatal(err)
+			}
+			if doc, _ := mem.Get(context.Background(), tc.ticketID); doc.TenantID != tc.want {
+				t.Fatalf("created in tenant %q, want %q", doc.TenantID, tc.want)
+			}
+		})
+	}
+}
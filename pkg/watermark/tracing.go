@@ -0,0 +1,89 @@
+package watermark
+
+import (
+	"context"
+
+	"github.com/wzzfarewell/go-microservice-example/internal"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// InstrumentationName names the tracer of this module's spans.
+const InstrumentationName = "github.com/wzzfarewell/go-microservice-example/pkg/watermark"
+
+// tracer delegates to the global TracerProvider, so it records nothing until
+// one has been installed.
+var tracer = otel.Tracer(InstrumentationName)
+
+// recordError marks the span as failed with err.
+func recordError(span trace.Span, err error) {
+	span.RecordError(err)
+	span.SetStatus(codes.Error, err.Error())
+}
+
+// endSpan records err, if any, on the span and ends it. Stopping a walk once
+// a page is full is no failure.
+func endSpan(span trace.Span, err error) {
+	if err != nil && err != errPageFull {
+		recordError(span, err)
+	}
+	span.End()
+}
+
+type tracingRepository struct {
+	next Repository
+}
+
+// NewTracingRepository wraps every call to repo in a span.
+func NewTracingRepository(repo Repository) Repository {
+	return &tracingRepository{next: repo}
+}
+
+func (r *tracingRepository) Create(ctx context.Context, doc *internal.Document) (err error) {
+	ctx, span := tracer.Start(ctx, "Repository.Create", trace.WithAttributes(attribute.String("ticket_id", doc.TicketID)))
+	defer func() { endSpan(span, err) }()
+	return r.next.Create(ctx, doc)
+}
+
+func (r *tracingRepository) Get(ctx context.Context, ticketID string) (_ *internal.Document, err error) {
+	ctx, span := tracer.Start(ctx, "Repository.Get", trace.WithAttributes(attribute.String("ticket_id", ticketID)))
+	defer func() { endSpan(span, err) }()
+	return r.next.Get(ctx, ticketID)
+}
+
+func (r *tracingRepository) Update(ctx context.Context, doc *internal.Document) (err error) {
+	ctx, span := tracer.Start(ctx, "Repository.Update", trace.WithAttributes(
+		attribute.String("ticket_id", doc.TicketID),
+		attribute.String("status", string(doc.Status)),
+	))
+	defer func() { endSpan(span, err) }()
+	return r.next.Update(ctx, doc)
+}
+
+func (r *tracingRepository) List(ctx context.Context) (_ []internal.Document, err error) {
+	ctx, span := tracer.Start(ctx, "Repository.List")
+	defer func() { endSpan(span, err) }()
+	return r.next.List(ctx)
+}
+
+func (r *tracingRepository) Walk(ctx context.Context, fn func(internal.Document) error) (err error) {
+	ctx, span := tracer.Start(ctx, "Repository.Walk")
+	defer func() { endSpan(span, err) }()
+	return r.next.Walk(ctx, fn)
+}
+
+func (r *tracingRepository) WalkAfter(ctx context.Context, after string, fn func(internal.Document) error) (err error) {
+	ctx, span := tracer.Start(ctx, "Repository.WalkAfter")
+	defer func() { endSpan(span, err) }()
+	return r.next.WalkAfter(ctx, after, fn)
+}
+
+// CheckHealth is not traced, the probes would drown out everything else.
+func (r *tracingRepository) CheckHealth(ctx context.Context) error {
+	if checker, ok := r.next.(HealthChecker); ok {
+		return checker.CheckHealth(ctx)
+	}
+	return nil
+}
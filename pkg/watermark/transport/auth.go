@@ -0,0 +1,104 @@
+package transport
+
+import (
+	"context"
+	"net/http"
+
+	"github.com/wzzfarewell/go-microservice-example/pkg/watermark/auth"
+	wmerrors "github.com/wzzfarewell/go-microservice-example/pkg/watermark/errors"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+)
+
+// authorizationKey is the gRPC metadata key matching the Authorization
+// header, with the same "<scheme> <token>" value.
+const authorizationKey = "authorization"
+
+// accessTokenName is the query parameter and cookie a bearer token can be
+// passed in to the event streams.
+const accessTokenName = "access_token"
+
+func credentialsFromHTTP(ctx context.Context, r *http.Request) context.Context {
+	if creds, ok := auth.ParseAuthorization(r.Header.Get("Authorization")); ok {
+		return auth.ContextWithCredentials(ctx, creds)
+	}
+	return ctx
+}
+
+// streamCredentialsFromHTTP falls back to a bearer token in the access_token
+// query parameter, then cookie, when the Authorization header has none, as
+// browsers can't set headers on an EventSource or a WebSocket. It is only
+// used by the routes of the event streams.
+func streamCredentialsFromHTTP(ctx context.Context, r *http.Request) context.Context {
+	if _, ok := auth.CredentialsFromContext(ctx); ok {
+		return ctx
+	}
+	token := r.URL.Query().Get(accessTokenName)
+	if token == "" {
+		if c, err := r.Cookie(accessTokenName); err == nil {
+			token = c.Value
+		}
+	}
+	if token == "" {
+		return ctx
+	}
+	return auth.ContextWithCredentials(ctx, auth.Credentials{Scheme: auth.SchemeBearer, Token: token})
+}
+
+func credentialsToHTTP(ctx context.Context, r *http.Request) context.Context {
+	if creds, ok := auth.CredentialsFromContext(ctx); ok {
+		r.Header.Set("Authorization", creds.String())
+	}
+	return ctx
+}
+
+func credentialsFromGRPC(ctx context.Context, md metadata.MD) context.Context {
+	if values := md.Get(authorizationKey); len(values) > 0 {
+		if creds, ok := auth.ParseAuthorization(values[0]); ok {
+			return auth.ContextWithCredentials(ctx, creds)
+		}
+	}
+	return ctx
+}
+
+func credentialsToGRPC(ctx context.Context, md *metadata.MD) context.Context {
+	if creds, ok := auth.CredentialsFromContext(ctx); ok {
+		md.Set(authorizationKey, creds.String())
+	}
+	return ctx
+}
+
+// peerFromHTTP puts the identity of the client certificate into the context,
+// once the TLS handshake verified it.
+func peerFromHTTP(ctx context.Context, r *http.Request) context.Context {
+	if r.TLS != nil && len(r.TLS.VerifiedChains) > 0 {
+		return auth.ContextWithPeer(ctx, auth.PeerIdentity(r.TLS.VerifiedChains[0][0]))
+	}
+	return ctx
+}
+
+func peerFromGRPC(ctx context.Context, _ metadata.MD) context.Context {
+	p, ok := peer.FromContext(ctx)
+	if !ok {
+		return ctx
+	}
+	if info, ok := p.AuthInfo.(credentials.TLSInfo); ok && len(info.State.VerifiedChains) > 0 {
+		return auth.ContextWithPeer(ctx, auth.PeerIdentity(info.State.VerifiedChains[0][0]))
+	}
+	return ctx
+}
+
+// RequireClientCert rejects the requests made without a verified client
+// certificate, but for the probes and metrics, which are scraped by clients
+// that usually have none. It is meant for a listener that only verifies the
+// certificates clients present.
+func RequireClientCert(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if probePaths[r.URL.Path] || (r.TLS != nil && len(r.TLS.VerifiedChains) > 0) {
+			next.ServeHTTP(w, r)
+			return
+		}
+		encodeError(r.Context(), &wmerrors.Unauthenticated{Message: "client certificate required"}, w)
+	})
+}
@@ -0,0 +1,165 @@
+package transport
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"mime/multipart"
+	"net/http"
+	"strconv"
+
+	"github.com/go-kit/log"
+	"github.com/go-kit/log/level"
+	"github.com/gorilla/mux"
+	"github.com/wzzfarewell/go-microservice-example/internal"
+	"github.com/wzzfarewell/go-microservice-example/internal/util"
+	"github.com/wzzfarewell/go-microservice-example/pkg/watermark"
+	"github.com/wzzfarewell/go-microservice-example/pkg/watermark/endpoint"
+	"github.com/wzzfarewell/go-microservice-example/pkg/watermark/logging"
+)
+
+// downloadChunkSize is the size of the chunks sent by DownloadDocument.
+const downloadChunkSize = 64 << 10
+
+type uploadsContextKey struct{}
+
+// trackUploads and removeUploads bracket the requests whose content is
+// spooled to temporary files, which are removed once the request is served.
+func trackUploads(ctx context.Context, _ *http.Request) context.Context {
+	return context.WithValue(ctx, uploadsContextKey{}, new([]*endpoint.Upload))
+}
+
+func removeUploads(ctx context.Context, _ int, _ *http.Request) {
+	if uploads, ok := ctx.Value(uploadsContextKey{}).(*[]*endpoint.Upload); ok {
+		for _, u := range *uploads {
+			u.Close()
+		}
+	}
+}
+
+// decodeMultipartDocument reads a document from a multipart/form-data body
+// made of the title, author, topic and content_type fields and a "file" part
+// holding the content, which is spooled to a temporary file of at most
+// maxUploadSize bytes. The content type falls back to the one of the file
+// part, and then to the one detected from the content.
+func decodeMultipartDocument(ctx context.Context, r *http.Request, maxUploadSize int64) (endpoint.CreateDocumentRequest, error) {
+	uploads, ok := ctx.Value(uploadsContextKey{}).(*[]*endpoint.Upload)
+	if !ok {
+		return endpoint.CreateDocumentRequest{}, errors.New("uploads are not tracked")
+	}
+	mr, err := r.MultipartReader()
+	if err != nil {
+		return endpoint.CreateDocumentRequest{}, fmt.Errorf("%w: %v", util.ErrInvalidArgument, err)
+	}
+	doc := &internal.Document{}
+	var (
+		upload      *endpoint.Upload
+		contentType string
+	)
+	for {
+		part, err := mr.NextPart()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return endpoint.CreateDocumentRequest{}, fmt.Errorf("%w: %v", util.ErrInvalidArgument, err)
+		}
+		switch part.FormName() {
+		case "file":
+			if upload != nil {
+				err = fmt.Errorf("%w: more than one file part", util.ErrInvalidArgument)
+				break
+			}
+			upload, err = endpoint.NewUpload(part, maxUploadSize)
+			if err == nil {
+				*uploads = append(*uploads, upload)
+			}
+			contentType = part.Header.Get("Content-Type")
+		case "title":
+			doc.Title, err = readField(part)
+		case "author":
+			doc.Author, err = readField(part)
+		case "topic":
+			doc.Topic, err = readField(part)
+		case "content_type":
+			doc.ContentType, err = readField(part)
+		}
+		part.Close()
+		if err != nil {
+			return endpoint.CreateDocumentRequest{}, err
+		}
+	}
+	if upload == nil {
+		return endpoint.CreateDocumentRequest{}, fmt.Errorf("%w: missing file part", util.ErrInvalidArgument)
+	}
+	if doc.ContentType == "" {
+		doc.ContentType = contentType
+	}
+	if doc.ContentType == "" || doc.ContentType == "application/octet-stream" {
+		// DetectContentType considers at most the first 512 bytes
+		head, err := io.ReadAll(io.LimitReader(upload.Reader(), 512))
+		if err != nil {
+			return endpoint.CreateDocumentRequest{}, err
+		}
+		doc.ContentType = http.DetectContentType(head)
+	}
+	return endpoint.CreateDocumentRequest{Document: doc, Upload: upload}, nil
+}
+
+func readPart(part *multipart.Part, limit int64) ([]byte, error) {
+	var buf bytes.Buffer
+	n, err := io.Copy(&buf, io.LimitReader(part, limit+1))
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", util.ErrInvalidArgument, err)
+	}
+	if n > limit {
+		return nil, fmt.Errorf("%w: %s larger than %d bytes", util.ErrInvalidArgument, part.FormName(), limit)
+	}
+	return buf.Bytes(), nil
+}
+
+func readField(part *multipart.Part) (string, error) {
+	value, err := readPart(part, 1<<10)
+	return string(value), err
+}
+
+func isMultipart(r *http.Request) bool {
+	mediaType, _, err := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	return err == nil && mediaType == "multipart/form-data"
+}
+
+// serveContent streams the content of a document. The variant query
+// parameter selects the "original" (default) or "watermarked" content.
+func serveContent(streamer watermark.Streamer, logger log.Logger) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var watermarked bool
+		switch variant := r.URL.Query().Get("variant"); variant {
+		case "", "original":
+		case "watermarked":
+			watermarked = true
+		default:
+			encodeError(r.Context(), fmt.Errorf("%w: unknown variant %q", util.ErrInvalidArgument, variant), w)
+			return
+		}
+		ticketID := mux.Vars(r)["id"]
+		ctx := logging.ContextWithTicketID(r.Context(), ticketID)
+		content, err := streamer.OpenContent(ctx, ticketID, watermarked)
+		if err != nil {
+			encodeError(r.Context(), err, w)
+			return
+		}
+		defer content.Close()
+
+		w.Header().Set("Content-Type", content.ContentType)
+		w.Header().Set("Content-Length", strconv.FormatInt(content.Size, 10))
+		w.WriteHeader(http.StatusOK)
+		if _, err := io.Copy(w, content); err != nil {
+			// the client is gone or the blob store failed mid-stream, the
+			// status has been sent already
+			level.Warn(logging.FromContext(ctx, logger)).Log("handler", "content", "err", err)
+		}
+	})
+}
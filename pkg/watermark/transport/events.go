@@ -0,0 +1,164 @@
+package transport
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/gorilla/websocket"
+	"github.com/wzzfarewell/go-microservice-example/internal/util"
+	"github.com/wzzfarewell/go-microservice-example/pkg/watermark"
+	wmerrors "github.com/wzzfarewell/go-microservice-example/pkg/watermark/errors"
+)
+
+// sseHeartbeat is how often an SSE comment is written to keep idle
+// connections from being closed by proxies.
+const sseHeartbeat = 15 * time.Second
+
+var upgrader = websocket.Upgrader{
+	ReadBufferSize:  1024,
+	WriteBufferSize: 1024,
+}
+
+// serveStatusEvents streams the status transitions of one ticket as
+// Server-Sent Events until the ticket reaches a terminal status.
+func serveStatusEvents(streamer watermark.Streamer) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		flusher, ok := w.(http.Flusher)
+		if !ok {
+			encodeError(r.Context(), errors.New("streaming is not supported"), w)
+			return
+		}
+		ticketID := mux.Vars(r)["id"]
+		statuses, err := streamer.WatchStatus(r.Context(), ticketID)
+		if err != nil {
+			encodeError(r.Context(), err, w)
+			return
+		}
+
+		w.Header().Set("Content-Type", "text/event-stream")
+		w.Header().Set("Cache-Control", "no-cache")
+		w.Header().Set("Connection", "keep-alive")
+		w.WriteHeader(http.StatusOK)
+		flusher.Flush()
+
+		heartbeat := time.NewTicker(sseHeartbeat)
+		defer heartbeat.Stop()
+		for {
+			select {
+			case status, ok := <-statuses:
+				if !ok {
+					return
+				}
+				data, _ := json.Marshal(watermark.StatusEvent{TicketID: ticketID, Status: status})
+				fmt.Fprintf(w, "event: status\ndata: %s\n\n", data)
+			case <-heartbeat.C:
+				fmt.Fprint(w, ": heartbeat\n\n")
+			}
+			flusher.Flush()
+		}
+	})
+}
+
+// wsRequest is sent by WebSocket clients to change the set of tickets they
+// receive status events for.
+type wsRequest struct {
+	Action    string   `json:"action"` // "subscribe" or "unsubscribe"
+	TicketIDs []string `json:"ticket_ids"`
+}
+
+// wsError is sent to WebSocket clients when a request can't be served.
+type wsError struct {
+	TicketID string        `json:"ticket_id,omitempty"`
+	Error    wmerrors.Body `json:"error"`
+}
+
+// serveStatusWebSocket upgrades the connection to a WebSocket over which
+// the client subscribes to any number of tickets and receives a
+// watermark.StatusEvent for each of their transitions.
+func serveStatusWebSocket(streamer watermark.Streamer) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		conn, err := upgrader.Upgrade(w, r, nil)
+		if err != nil {
+			// the upgrader has already replied with an error
+			return
+		}
+		defer conn.Close()
+
+		ctx, cancel := context.WithCancel(r.Context())
+		var (
+			wg      sync.WaitGroup
+			out     = make(chan interface{})
+			watches = make(map[string]context.CancelFunc)
+		)
+		send := func(msg interface{}) {
+			select {
+			case out <- msg:
+			case <-ctx.Done():
+			}
+		}
+
+		go func() {
+			for {
+				select {
+				case msg := <-out:
+					if err := conn.WriteJSON(msg); err != nil {
+						cancel()
+						return
+					}
+				case <-ctx.Done():
+					return
+				}
+			}
+		}()
+
+		for ctx.Err() == nil {
+			var req wsRequest
+			if err := conn.ReadJSON(&req); err != nil {
+				break
+			}
+			switch req.Action {
+			case "subscribe":
+				for _, ticketID := range req.TicketIDs {
+					if stop, ok := watches[ticketID]; ok {
+						stop()
+					}
+					watchCtx, stop := context.WithCancel(ctx)
+					statuses, err := streamer.WatchStatus(watchCtx, ticketID)
+					if err != nil {
+						stop()
+						delete(watches, ticketID)
+						send(wsError{TicketID: ticketID, Error: wmerrors.NewBody(err)})
+						continue
+					}
+					watches[ticketID] = stop
+					wg.Add(1)
+					go func(ticketID string) {
+						defer wg.Done()
+						for status := range statuses {
+							send(watermark.StatusEvent{TicketID: ticketID, Status: status})
+						}
+					}(ticketID)
+				}
+			case "unsubscribe":
+				for _, ticketID := range req.TicketIDs {
+					if stop, ok := watches[ticketID]; ok {
+						stop()
+						delete(watches, ticketID)
+					}
+				}
+			default:
+				err := fmt.Errorf("%w: unknown action %q", util.ErrInvalidArgument, req.Action)
+				send(wsError{Error: wmerrors.NewBody(err)})
+			}
+		}
+
+		cancel()
+		wg.Wait()
+	})
+}
@@ -0,0 +1,294 @@
+package transport
+
+import (
+	"bufio"
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/go-kit/log"
+	"github.com/gorilla/websocket"
+	"github.com/wzzfarewell/go-microservice-example/internal"
+	"github.com/wzzfarewell/go-microservice-example/pkg/watermark"
+	"github.com/wzzfarewell/go-microservice-example/pkg/watermark/auth"
+	"github.com/wzzfarewell/go-microservice-example/pkg/watermark/endpoint"
+	wmerrors "github.com/wzzfarewell/go-microservice-example/pkg/watermark/errors"
+	"github.com/wzzfarewell/go-microservice-example/pkg/watermark/inmem"
+)
+
+// watchingStreamer records the credentials of every watch and reports the
+// ticket ID of every watch that ends.
+type watchingStreamer struct {
+	watermark.Streamer
+
+	mtx   sync.Mutex
+	creds []auth.Credentials
+	ended chan string
+}
+
+func (s *watchingStreamer) WatchStatus(ctx context.Context, ticketID string) (<-chan internal.Status, error) {
+	creds, _ := auth.CredentialsFromContext(ctx)
+	s.mtx.Lock()
+	s.creds = append(s.creds, creds)
+	s.mtx.Unlock()
+	go func() {
+		<-ctx.Done()
+		s.ended <- ticketID
+	}()
+	return s.Streamer.WatchStatus(ctx, ticketID)
+}
+
+func (s *watchingStreamer) lastCredentials() auth.Credentials {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+	return s.creds[len(s.creds)-1]
+}
+
+func (s *watchingStreamer) waitEnded(t *testing.T, ticketID string) {
+	t.Helper()
+	select {
+	case got := <-s.ended:
+		if got != ticketID {
+			t.Fatalf("watch of %s ended, want %s", got, ticketID)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatalf("watch of %s still running", ticketID)
+	}
+}
+
+// newEventsServer serves the streams of the Pending tickets t1 and t2.
+func newEventsServer(t *testing.T) (*httptest.Server, *watermark.Hub, *watchingStreamer) {
+	t.Helper()
+	repo := inmem.NewRepository()
+	for _, ticketID := range []string{"t1", "t2"} {
+		if err := repo.Create(context.Background(), &internal.Document{TicketID: ticketID, Status: internal.Pending}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	hub := watermark.NewHub()
+	streamer := &watchingStreamer{
+		Streamer: watermark.NewStreamer(repo, inmem.NewBlobStore(), hub),
+		ended:    make(chan string, 10),
+	}
+	server := httptest.NewServer(NewHTTPHandler(endpoint.NewEndpointSet(&stubService{}), streamer, nil, testMaxUploadSize, log.NewNopLogger()))
+	t.Cleanup(server.Close)
+	t.Cleanup(hub.Close)
+	return server, hub, streamer
+}
+
+// sseData returns the data of the next event of an SSE stream, or "" at its
+// end.
+func sseData(t *testing.T, r *bufio.Reader) string {
+	t.Helper()
+	for {
+		line, err := r.ReadString('\n')
+		if err != nil {
+			return ""
+		}
+		if data, ok := strings.CutPrefix(strings.TrimSpace(line), "data: "); ok {
+			return data
+		}
+	}
+}
+
+func openSSE(t *testing.T, ctx context.Context, url string) *bufio.Reader {
+	t.Helper()
+	req, err := http.NewRequestWithContext(ctx, "GET", url, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { resp.Body.Close() })
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("status %d, want %d", resp.StatusCode, http.StatusOK)
+	}
+	if ct := resp.Header.Get("Content-Type"); ct != "text/event-stream" {
+		t.Fatalf("content type %q", ct)
+	}
+	return bufio.NewReader(resp.Body)
+}
+
+func TestSSEStatusEvents(t *testing.T) {
+	server, hub, _ := newEventsServer(t)
+	events := openSSE(t, context.Background(), server.URL+"/api/v1/watermark/documents/t1/events")
+
+	if got, want := sseData(t, events), `{"ticket_id":"t1","status":"Pending"}`; got != want {
+		t.Fatalf("got %s, want %s", got, want)
+	}
+	// only the events of t1 are streamed, until it is Finished
+	hub.Publish(watermark.StatusEvent{TicketID: "t2", Status: internal.Started})
+	for _, status := range []internal.Status{internal.Started, internal.InProgress, internal.Finished} {
+		hub.Publish(watermark.StatusEvent{TicketID: "t1", Status: status})
+	}
+	for _, want := range []string{
+		`{"ticket_id":"t1","status":"Started"}`,
+		`{"ticket_id":"t1","status":"InProgress"}`,
+		`{"ticket_id":"t1","status":"Finished"}`,
+		"",
+	} {
+		if got := sseData(t, events); got != want {
+			t.Fatalf("got %q, want %q", got, want)
+		}
+	}
+}
+
+func TestSSEDisconnect(t *testing.T) {
+	server, _, streamer := newEventsServer(t)
+	ctx, cancel := context.WithCancel(context.Background())
+	events := openSSE(t, ctx, server.URL+"/api/v1/watermark/documents/t1/events")
+	sseData(t, events)
+	cancel()
+	streamer.waitEnded(t, "t1")
+}
+
+func TestSSEUnknownTicket(t *testing.T) {
+	server, _, _ := newEventsServer(t)
+	resp, err := http.Get(server.URL + "/api/v1/watermark/documents/missing/events")
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusNotFound {
+		t.Fatalf("status %d, want %d", resp.StatusCode, http.StatusNotFound)
+	}
+}
+
+type wsMessage struct {
+	TicketID string          `json:"ticket_id"`
+	Status   internal.Status `json:"status"`
+	Error    *wmerrors.Body  `json:"error"`
+}
+
+func readWS(t *testing.T, conn *websocket.Conn) wsMessage {
+	t.Helper()
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	var msg wsMessage
+	if err := conn.ReadJSON(&msg); err != nil {
+		t.Fatal(err)
+	}
+	return msg
+}
+
+func dialWS(t *testing.T, server *httptest.Server, path string, header http.Header) *websocket.Conn {
+	t.Helper()
+	conn, _, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(server.URL, "http")+path, header)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	return conn
+}
+
+func TestWebSocketStatusEvents(t *testing.T) {
+	server, hub, streamer := newEventsServer(t)
+	conn := dialWS(t, server, "/api/v1/watermark/events", nil)
+
+	if err := conn.WriteJSON(wsRequest{Action: "subscribe", TicketIDs: []string{"t1", "t2"}}); err != nil {
+		t.Fatal(err)
+	}
+	current := map[string]internal.Status{}
+	for i := 0; i < 2; i++ {
+		msg := readWS(t, conn)
+		current[msg.TicketID] = msg.Status
+	}
+	if current["t1"] != internal.Pending || current["t2"] != internal.Pending {
+		t.Fatalf("current statuses %v", current)
+	}
+
+	if err := conn.WriteJSON(wsRequest{Action: "subscribe", TicketIDs: []string{"missing"}}); err != nil {
+		t.Fatal(err)
+	}
+	if msg := readWS(t, conn); msg.TicketID != "missing" || msg.Error == nil || msg.Error.Code != "NotFound" {
+		t.Fatalf("got %+v, want a NotFound error for missing", msg)
+	}
+	streamer.waitEnded(t, "missing")
+
+	if err := conn.WriteJSON(wsRequest{Action: "unsubscribe", TicketIDs: []string{"t2"}}); err != nil {
+		t.Fatal(err)
+	}
+	streamer.waitEnded(t, "t2")
+	hub.Publish(watermark.StatusEvent{TicketID: "t2", Status: internal.Started})
+	hub.Publish(watermark.StatusEvent{TicketID: "t1", Status: internal.Started})
+	if msg := readWS(t, conn); msg.TicketID != "t1" || msg.Status != internal.Started {
+		t.Fatalf("got %+v, want t1 Started", msg)
+	}
+
+	if err := conn.WriteJSON(wsRequest{Action: "watch"}); err != nil {
+		t.Fatal(err)
+	}
+	if msg := readWS(t, conn); msg.Error == nil || msg.Error.Code != "InvalidArgument" {
+		t.Fatalf("got %+v, want an InvalidArgument error", msg)
+	}
+
+	// closing the connection ends the remaining watches
+	conn.Close()
+	streamer.waitEnded(t, "t1")
+}
+
+func TestStreamCredentials(t *testing.T) {
+	for _, tc := range []struct {
+		name   string
+		query  string
+		header http.Header
+		want   auth.Credentials
+	}{
+		{"header", "", http.Header{"Authorization": {"ApiKey key"}}, auth.Credentials{Scheme: auth.SchemeAPIKey, Token: "key"}},
+		{"query", "?access_token=query", nil, auth.Credentials{Scheme: auth.SchemeBearer, Token: "query"}},
+		{"cookie", "", http.Header{"Cookie": {"access_token=cookie"}}, auth.Credentials{Scheme: auth.SchemeBearer, Token: "cookie"}},
+		{"query over cookie", "?access_token=query", http.Header{"Cookie": {"access_token=cookie"}}, auth.Credentials{Scheme: auth.SchemeBearer, Token: "query"}},
+		{"header over query", "?access_token=query", http.Header{"Authorization": {"Bearer header"}}, auth.Credentials{Scheme: auth.SchemeBearer, Token: "header"}},
+		{"none", "", nil, auth.Credentials{}},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			server, _, streamer := newEventsServer(t)
+
+			req, err := http.NewRequest("GET", server.URL+"/api/v1/watermark/documents/t1/events"+tc.query, nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			for k, v := range tc.header {
+				req.Header[k] = v
+			}
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+			resp, err := http.DefaultClient.Do(req.WithContext(ctx))
+			if err != nil {
+				t.Fatal(err)
+			}
+			resp.Body.Close()
+			if got := streamer.lastCredentials(); got != tc.want {
+				t.Fatalf("SSE got %+v, want %+v", got, tc.want)
+			}
+
+			streamer.waitEnded(t, "t1")
+
+			conn := dialWS(t, server, "/api/v1/watermark/events"+tc.query, tc.header)
+			if err := conn.WriteJSON(wsRequest{Action: "subscribe", TicketIDs: []string{"t1"}}); err != nil {
+				t.Fatal(err)
+			}
+			readWS(t, conn)
+			if got := streamer.lastCredentials(); got != tc.want {
+				t.Fatalf("WebSocket got %+v, want %+v", got, tc.want)
+			}
+		})
+	}
+}
+
+func TestRequestCredentialsIgnoreAccessToken(t *testing.T) {
+	req := httptest.NewRequest("GET", "/api/v1/watermark/documents?access_token=query", nil)
+	req.AddCookie(&http.Cookie{Name: accessTokenName, Value: "cookie"})
+	ctx := req.Context()
+	for _, f := range requestContextFromHTTP {
+		ctx = f(ctx, req)
+	}
+	if creds, ok := auth.CredentialsFromContext(ctx); ok {
+		t.Fatalf("got %+v outside of the event streams", creds)
+	}
+}
@@ -2,11 +2,21 @@ package transport
 
 import (
 	"context"
+	"fmt"
+	"io"
+	"strings"
 
+	"github.com/go-kit/kit/transport"
 	"github.com/go-kit/kit/transport/grpc"
+	"github.com/go-kit/log"
+	"github.com/go-kit/log/level"
 	"github.com/wzzfarewell/go-microservice-example/api/v1/pb/watermark"
 	"github.com/wzzfarewell/go-microservice-example/internal"
+	"github.com/wzzfarewell/go-microservice-example/internal/util"
+	wm "github.com/wzzfarewell/go-microservice-example/pkg/watermark"
 	"github.com/wzzfarewell/go-microservice-example/pkg/watermark/endpoint"
+	wmerrors "github.com/wzzfarewell/go-microservice-example/pkg/watermark/errors"
+	"google.golang.org/grpc/metadata"
 )
 
 type grpcServer struct {
@@ -16,22 +26,36 @@ type grpcServer struct {
 	serviceStatus  grpc.Handler
 	createDocument grpc.Handler
 	watermark      grpc.Handler
+	extract        grpc.Handler
+	streamer       wm.Streamer
+	maxUploadSize  int64
 }
 
-func NewGRPCServer(ep endpoint.Set) watermark.WatermarkServer {
+// NewGRPCServer serves the endpoints and streams. The content sent to
+// UploadDocument is limited to maxUploadSize bytes.
+func NewGRPCServer(ep endpoint.Set, streamer wm.Streamer, maxUploadSize int64, logger log.Logger) watermark.WatermarkServer {
+	options := []grpc.ServerOption{
+		grpc.ServerBefore(requestContextFromGRPC...),
+		// the endpoints log their errors already, this adds the failed decodes
+		grpc.ServerErrorHandler(transport.NewLogErrorHandler(level.Debug(logger))),
+	}
+	idempotent := append(options, grpc.ServerBefore(idempotencyKeyFromGRPC))
 	return &grpcServer{
-		find:           grpc.NewServer(ep.FindEndpoint, decodeGRPCGetRequest, encodeGRPCGetResponse),
-		status:         grpc.NewServer(ep.StatusEndpoint, decodeGRPCStatusRequest, encodeGRPCStatusResponse),
-		serviceStatus:  grpc.NewServer(ep.ServiceStatusEndpoint, decodeGRPCServiceStatusRequest, encodeGRPCServiceStatusResponse),
-		createDocument: grpc.NewServer(ep.CreateDocumentEndpoint, decodeGRPCCreateDocumentRequest, encodeGRPCCreateDocumentResponse),
-		watermark:      grpc.NewServer(ep.WatermarkEndpoint, decodeGRPCWatermarkRequest, encodeGRPCWatermarkResponse),
+		streamer:       streamer,
+		maxUploadSize:  maxUploadSize,
+		find:           grpc.NewServer(ep.FindEndpoint, decodeGRPCFindRequest, encodeGRPCFindResponse, options...),
+		status:         grpc.NewServer(ep.StatusEndpoint, decodeGRPCStatusRequest, encodeGRPCStatusResponse, options...),
+		serviceStatus:  grpc.NewServer(ep.ServiceStatusEndpoint, decodeGRPCServiceStatusRequest, encodeGRPCServiceStatusResponse, options...),
+		createDocument: grpc.NewServer(ep.CreateDocumentEndpoint, decodeGRPCCreateDocumentRequest, encodeGRPCCreateDocumentResponse, idempotent...),
+		watermark:      grpc.NewServer(ep.WatermarkEndpoint, decodeGRPCWatermarkRequest, encodeGRPCWatermarkResponse, idempotent...),
+		extract:        grpc.NewServer(ep.ExtractEndpoint, decodeGRPCExtractRequest, encodeGRPCExtractResponse, options...),
 	}
 }
 
 func (s *grpcServer) Find(ctx context.Context, request *watermark.FindRequest) (*watermark.FindReply, error) {
 	_, reply, err := s.find.ServeGRPC(ctx, request)
 	if err != nil {
-		return nil, err
+		return nil, encodeGRPCError(err)
 	}
 	return reply.(*watermark.FindReply), nil
 }
@@ -39,7 +63,7 @@ func (s *grpcServer) Find(ctx context.Context, request *watermark.FindRequest) (
 func (s *grpcServer) Watermark(ctx context.Context, request *watermark.WatermarkRequest) (*watermark.WatermarkReply, error) {
 	_, reply, err := s.watermark.ServeGRPC(ctx, request)
 	if err != nil {
-		return nil, err
+		return nil, encodeGRPCError(err)
 	}
 	return reply.(*watermark.WatermarkReply), nil
 }
@@ -47,7 +71,7 @@ func (s *grpcServer) Watermark(ctx context.Context, request *watermark.Watermark
 func (s *grpcServer) Status(ctx context.Context, request *watermark.StatusRequest) (*watermark.StatusReply, error) {
 	_, reply, err := s.status.ServeGRPC(ctx, request)
 	if err != nil {
-		return nil, err
+		return nil, encodeGRPCError(err)
 	}
 	return reply.(*watermark.StatusReply), nil
 }
@@ -55,7 +79,7 @@ func (s *grpcServer) Status(ctx context.Context, request *watermark.StatusReques
 func (s *grpcServer) CreateDocument(ctx context.Context, request *watermark.CreateDocumentRequest) (*watermark.CreateDocumentReply, error) {
 	_, reply, err := s.createDocument.ServeGRPC(ctx, request)
 	if err != nil {
-		return nil, err
+		return nil, encodeGRPCError(err)
 	}
 	return reply.(*watermark.CreateDocumentReply), nil
 }
@@ -63,18 +87,173 @@ func (s *grpcServer) CreateDocument(ctx context.Context, request *watermark.Crea
 func (s *grpcServer) ServiceStatus(ctx context.Context, request *watermark.ServiceStatusRequest) (*watermark.ServiceStatusReply, error) {
 	_, reply, err := s.serviceStatus.ServeGRPC(ctx, request)
 	if err != nil {
-		return nil, err
+		return nil, encodeGRPCError(err)
 	}
 	return reply.(*watermark.ServiceStatusReply), nil
 }
 
-func decodeGRPCGetRequest(_ context.Context, grpcReq interface{}) (interface{}, error) {
+func (s *grpcServer) Extract(ctx context.Context, request *watermark.ExtractRequest) (*watermark.ExtractReply, error) {
+	_, reply, err := s.extract.ServeGRPC(ctx, request)
+	if err != nil {
+		return nil, encodeGRPCError(err)
+	}
+	return reply.(*watermark.ExtractReply), nil
+}
+
+// The go-kit gRPC transport only supports unary calls, so the streaming RPCs
+// call the streamer directly.
+
+// requestContextFromGRPC moves what every call carries in its metadata into
+// the context.
+var requestContextFromGRPC = []grpc.ServerRequestFunc{traceContextFromGRPC, requestIDFromGRPC, credentialsFromGRPC, peerFromGRPC}
+
+// streamContext does for the streaming methods, which aren't go-kit
+// servers, what the ServerBefore options do for the others.
+func streamContext(ctx context.Context) context.Context {
+	md, _ := metadata.FromIncomingContext(ctx)
+	for _, f := range requestContextFromGRPC {
+		ctx = f(ctx, md)
+	}
+	return ctx
+}
+
+func (s *grpcServer) WatchStatus(request *watermark.StatusRequest, stream watermark.Watermark_WatchStatusServer) error {
+	statuses, err := s.streamer.WatchStatus(streamContext(stream.Context()), request.TicketID)
+	if err != nil {
+		return encodeGRPCError(err)
+	}
+	for status := range statuses {
+		if err := stream.Send(&watermark.StatusReply{Status: statusToPB(status)}); err != nil {
+			return err
+		}
+	}
+	return stream.Context().Err()
+}
+
+func (s *grpcServer) FindStream(request *watermark.FindRequest, stream watermark.Watermark_FindStreamServer) error {
+	ctx := streamContext(stream.Context())
+	req, _ := decodeGRPCFindRequest(ctx, request)
+	send := func(doc internal.Document) error {
+		return stream.Send(documentToPB(&doc))
+	}
+	if err := s.streamer.FindStream(ctx, send, req.(endpoint.FindRequest).Filters...); err != nil {
+		return encodeGRPCError(err)
+	}
+	return nil
+}
+
+// UploadDocument takes the metadata from the first message and spools the
+// chunks of the following ones to a temporary file as they arrive.
+func (s *grpcServer) UploadDocument(stream watermark.Watermark_UploadDocumentServer) error {
+	msg, err := stream.Recv()
+	if err == io.EOF {
+		return encodeGRPCError(fmt.Errorf("%w: missing metadata", util.ErrInvalidArgument))
+	}
+	if err != nil {
+		return err
+	}
+	metadata, ok := msg.Data.(*watermark.UploadDocumentRequest_Metadata)
+	if !ok {
+		return encodeGRPCError(fmt.Errorf("%w: metadata must be sent before the content", util.ErrInvalidArgument))
+	}
+	doc := metadata.Metadata
+	doc.Content = "" // the chunks are the content
+
+	pr, pw := io.Pipe()
+	go func() {
+		pw.CloseWithError(receiveChunks(stream, pw))
+	}()
+	upload, err := endpoint.NewUpload(pr, s.maxUploadSize)
+	// stops receiving if the upload failed early
+	pr.Close()
+	if err != nil {
+		return encodeGRPCError(err)
+	}
+	defer upload.Close()
+
+	_, reply, err := s.createDocument.ServeGRPC(stream.Context(), uploadedDocument{doc: doc, upload: upload})
+	if err != nil {
+		return encodeGRPCError(err)
+	}
+	return stream.SendAndClose(reply.(*watermark.CreateDocumentReply))
+}
+
+// receiveChunks writes the content chunks of an upload to w.
+func receiveChunks(stream watermark.Watermark_UploadDocumentServer, w io.Writer) error {
+	for {
+		msg, err := stream.Recv()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		switch data := msg.Data.(type) {
+		case *watermark.UploadDocumentRequest_Metadata:
+			return fmt.Errorf("%w: metadata sent twice", util.ErrInvalidArgument)
+		case *watermark.UploadDocumentRequest_Chunk:
+			if _, err := w.Write(data.Chunk); err != nil {
+				return err
+			}
+		}
+	}
+}
+
+// uploadedDocument is what UploadDocument passes to the CreateDocument
+// handler in place of a *watermark.CreateDocumentRequest.
+type uploadedDocument struct {
+	doc    *watermark.Document
+	upload *endpoint.Upload
+}
+
+func (s *grpcServer) DownloadDocument(request *watermark.DownloadDocumentRequest, stream watermark.Watermark_DownloadDocumentServer) error {
+	content, err := s.streamer.OpenContent(streamContext(stream.Context()), request.TicketID, request.Watermarked)
+	if err != nil {
+		return encodeGRPCError(err)
+	}
+	defer content.Close()
+	reply := &watermark.DownloadDocumentReply{ContentType: content.ContentType}
+	buf := make([]byte, downloadChunkSize)
+	for {
+		n, err := content.Read(buf)
+		if n > 0 {
+			reply.Chunk = buf[:n]
+			if err := stream.Send(reply); err != nil {
+				return err
+			}
+			reply = &watermark.DownloadDocumentReply{}
+		}
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return encodeGRPCError(err)
+		}
+	}
+	// empty content still reports its content type
+	if reply.ContentType != "" {
+		return stream.Send(reply)
+	}
+	return nil
+}
+
+// idempotencyKey is the gRPC metadata key matching the Idempotency-Key header.
+var idempotencyKey = strings.ToLower(endpoint.IdempotencyKeyHeader)
+
+func idempotencyKeyFromGRPC(ctx context.Context, md metadata.MD) context.Context {
+	if values := md.Get(idempotencyKey); len(values) > 0 {
+		return endpoint.ContextWithIdempotencyKey(ctx, values[0])
+	}
+	return ctx
+}
+
+func decodeGRPCFindRequest(_ context.Context, grpcReq interface{}) (interface{}, error) {
 	req := grpcReq.(*watermark.FindRequest)
 	var filters []internal.Filter
 	for _, f := range req.Filters {
 		filters = append(filters, internal.Filter{Key: f.Key, Value: f.Value})
 	}
-	return endpoint.FindRequest{Filters: filters}, nil
+	return endpoint.FindRequest{Filters: filters, PageSize: int(req.PageSize), PageToken: req.PageToken}, nil
 }
 
 func decodeGRPCStatusRequest(_ context.Context, grpcReq interface{}) (interface{}, error) {
@@ -88,53 +267,136 @@ func decodeGRPCWatermarkRequest(_ context.Context, grpcReq interface{}) (interfa
 }
 
 func decodeGRPCCreateDocumentRequest(_ context.Context, grpcReq interface{}) (interface{}, error) {
+	if uploaded, ok := grpcReq.(uploadedDocument); ok {
+		return endpoint.CreateDocumentRequest{Document: documentFromPB(uploaded.doc), Upload: uploaded.upload}, nil
+	}
 	req := grpcReq.(*watermark.CreateDocumentRequest)
-	doc := &internal.Document{
-		Content:   req.Document.Content,
-		Title:     req.Document.Title,
-		Author:    req.Document.Author,
-		Topic:     req.Document.Topic,
-		Watermark: req.Document.Watermark,
+	content := req.Content
+	if len(content) == 0 && req.Document != nil {
+		content = []byte(req.Document.Content) // sent inline by older clients
 	}
-	return endpoint.CreateDocumentRequest{Document: doc}, nil
+	return endpoint.CreateDocumentRequest{Document: documentFromPB(req.Document), Content: content}, nil
 }
 
 func decodeGRPCServiceStatusRequest(_ context.Context, grpcReq interface{}) (interface{}, error) {
 	return endpoint.ServiceStatusRequest{}, nil
 }
 
-func encodeGRPCGetResponse(_ context.Context, grpcReply interface{}) (interface{}, error) {
-	reply := grpcReply.(*watermark.FindReply)
-	var docs []internal.Document
-	for _, d := range reply.Documents {
-		doc := internal.Document{
-			Content:   d.Content,
-			Title:     d.Title,
-			Author:    d.Author,
-			Topic:     d.Topic,
-			Watermark: d.Watermark,
-		}
-		docs = append(docs, doc)
+func decodeGRPCExtractRequest(_ context.Context, grpcReq interface{}) (interface{}, error) {
+	req := grpcReq.(*watermark.ExtractRequest)
+	return endpoint.ExtractRequest{Content: req.Content}, nil
+}
+
+func encodeGRPCFindResponse(_ context.Context, response interface{}) (interface{}, error) {
+	resp := response.(endpoint.FindResponse)
+	docs := make([]*watermark.Document, 0, len(resp.Documents))
+	for i := range resp.Documents {
+		docs = append(docs, documentToPB(&resp.Documents[i]))
 	}
-	return endpoint.FindResponse{Documents: docs, Err: reply.Err}, nil
+	return &watermark.FindReply{Documents: docs, NextPageToken: resp.NextPageToken}, nil
 }
 
-func encodeGRPCStatusResponse(_ context.Context, grpcReply interface{}) (interface{}, error) {
-	reply := grpcReply.(*watermark.StatusReply)
-	return endpoint.StatusResponse{Status: internal.Status(reply.Status), Err: reply.Err}, nil
+func encodeGRPCStatusResponse(_ context.Context, response interface{}) (interface{}, error) {
+	resp := response.(endpoint.StatusResponse)
+	return &watermark.StatusReply{Status: statusToPB(resp.Status)}, nil
 }
 
-func encodeGRPCWatermarkResponse(ctx context.Context, grpcReply interface{}) (interface{}, error) {
-	reply := grpcReply.(*watermark.WatermarkReply)
-	return endpoint.WatermarkResponse{Code: int(reply.Code), Err: reply.Err}, nil
+func encodeGRPCWatermarkResponse(_ context.Context, response interface{}) (interface{}, error) {
+	resp := response.(endpoint.WatermarkResponse)
+	return &watermark.WatermarkReply{Code: int64(resp.Code)}, nil
 }
 
-func encodeGRPCCreateDocumentResponse(ctx context.Context, grpcReply interface{}) (interface{}, error) {
-	reply := grpcReply.(*watermark.CreateDocumentReply)
-	return endpoint.CreateDocumentResponse{TicketID: reply.TicketID, Err: reply.Err}, nil
+func encodeGRPCCreateDocumentResponse(_ context.Context, response interface{}) (interface{}, error) {
+	resp := response.(endpoint.CreateDocumentResponse)
+	return &watermark.CreateDocumentReply{TicketID: resp.TicketID}, nil
+}
+
+func encodeGRPCServiceStatusResponse(_ context.Context, response interface{}) (interface{}, error) {
+	resp := response.(endpoint.ServiceStatusResponse)
+	return &watermark.ServiceStatusReply{Code: int64(resp.Code)}, nil
+}
+
+func encodeGRPCExtractResponse(_ context.Context, response interface{}) (interface{}, error) {
+	resp := response.(endpoint.ExtractResponse)
+	return &watermark.ExtractReply{TicketID: resp.TicketID, Mark: resp.Mark}, nil
+}
+
+func documentToPB(doc *internal.Document) *watermark.Document {
+	if doc == nil {
+		return nil
+	}
+	return &watermark.Document{
+		TicketID:      doc.TicketID,
+		Status:        statusToPB(doc.Status),
+		ContentType:   doc.ContentType,
+		Title:         doc.Title,
+		Author:        doc.Author,
+		Topic:         doc.Topic,
+		Watermark:     doc.Watermark,
+		ContentDigest: doc.ContentDigest,
+		ContentSize:   doc.ContentSize,
+		MarkedDigest:  doc.MarkedDigest,
+		MarkedSize:    doc.MarkedSize,
+		RequestId:     doc.RequestID,
+		CreatedBy:     doc.CreatedBy,
+		TenantId:      doc.TenantID,
+	}
+}
+
+func documentFromPB(doc *watermark.Document) *internal.Document {
+	if doc == nil {
+		return nil
+	}
+	return &internal.Document{
+		TicketID:      doc.TicketID,
+		Status:        statusFromPB(doc.Status),
+		ContentType:   doc.ContentType,
+		Title:         doc.Title,
+		Author:        doc.Author,
+		Topic:         doc.Topic,
+		Watermark:     doc.Watermark,
+		ContentDigest: doc.ContentDigest,
+		ContentSize:   doc.ContentSize,
+		MarkedDigest:  doc.MarkedDigest,
+		MarkedSize:    doc.MarkedSize,
+		RequestID:     doc.RequestId,
+		CreatedBy:     doc.CreatedBy,
+		TenantID:      doc.TenantId,
+	}
+}
+
+// statusToPB and statusFromPB map internal.Status to the proto enum and back.
+// The proto enum has no "unset" value, so an empty status is sent as PENDING.
+func statusToPB(s internal.Status) watermark.StatusReply_Status {
+	switch s {
+	case internal.Started:
+		return watermark.StatusReply_STARTED
+	case internal.InProgress:
+		return watermark.StatusReply_IN_PROGRESS
+	case internal.Finished:
+		return watermark.StatusReply_FINISHED
+	case internal.Failed:
+		return watermark.StatusReply_FAILED
+	default:
+		return watermark.StatusReply_PENDING
+	}
+}
+
+func statusFromPB(s watermark.StatusReply_Status) internal.Status {
+	switch s {
+	case watermark.StatusReply_PENDING:
+		return internal.Pending
+	case watermark.StatusReply_STARTED:
+		return internal.Started
+	case watermark.StatusReply_IN_PROGRESS:
+		return internal.InProgress
+	case watermark.StatusReply_FINISHED:
+		return internal.Finished
+	default:
+		return internal.Failed
+	}
 }
 
-func encodeGRPCServiceStatusResponse(ctx context.Context, grpcReply interface{}) (interface{}, error) {
-	reply := grpcReply.(*watermark.ServiceStatusReply)
-	return endpoint.ServiceStatusResponse{Code: int(reply.Code), Err: reply.Err}, nil
+func encodeGRPCError(err error) error {
+	return wmerrors.GRPCStatus(err).Err()
 }
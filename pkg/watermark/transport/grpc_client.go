@@ -0,0 +1,155 @@
+package transport
+
+import (
+	"context"
+
+	kitendpoint "github.com/go-kit/kit/endpoint"
+	grpctransport "github.com/go-kit/kit/transport/grpc"
+	pb "github.com/wzzfarewell/go-microservice-example/api/v1/pb/watermark"
+	"github.com/wzzfarewell/go-microservice-example/internal"
+	"github.com/wzzfarewell/go-microservice-example/pkg/watermark"
+	"github.com/wzzfarewell/go-microservice-example/pkg/watermark/endpoint"
+	wmerrors "github.com/wzzfarewell/go-microservice-example/pkg/watermark/errors"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/metadata"
+)
+
+const grpcServiceName = "pb.Watermark"
+
+// NewGRPCClient returns a watermark.Service backed by the gRPC server on the
+// other end of conn. The caller is responsible for closing conn.
+func NewGRPCClient(conn *grpc.ClientConn) watermark.Service {
+	options := []grpctransport.ClientOption{grpctransport.ClientBefore(traceContextToGRPC, requestIDToGRPC, credentialsToGRPC)}
+	idempotent := append(options, grpctransport.ClientBefore(idempotencyKeyToGRPC))
+	return &endpoint.Set{
+		FindEndpoint: grpcClientEndpoint(grpctransport.NewClient(
+			conn, grpcServiceName, "Find",
+			encodeGRPCFindRequest,
+			decodeGRPCFindResponse,
+			pb.FindReply{},
+			options...,
+		)),
+		StatusEndpoint: grpcClientEndpoint(grpctransport.NewClient(
+			conn, grpcServiceName, "Status",
+			encodeGRPCStatusRequest,
+			decodeGRPCStatusResponse,
+			pb.StatusReply{},
+			options...,
+		)),
+		CreateDocumentEndpoint: grpcClientEndpoint(grpctransport.NewClient(
+			conn, grpcServiceName, "CreateDocument",
+			encodeGRPCCreateDocumentRequest,
+			decodeGRPCCreateDocumentResponse,
+			pb.CreateDocumentReply{},
+			idempotent...,
+		)),
+		WatermarkEndpoint: grpcClientEndpoint(grpctransport.NewClient(
+			conn, grpcServiceName, "Watermark",
+			encodeGRPCWatermarkRequest,
+			decodeGRPCWatermarkResponse,
+			pb.WatermarkReply{},
+			idempotent...,
+		)),
+		ServiceStatusEndpoint: grpcClientEndpoint(grpctransport.NewClient(
+			conn, grpcServiceName, "ServiceStatus",
+			encodeGRPCServiceStatusRequest,
+			decodeGRPCServiceStatusResponse,
+			pb.ServiceStatusReply{},
+			options...,
+		)),
+		ExtractEndpoint: grpcClientEndpoint(grpctransport.NewClient(
+			conn, grpcServiceName, "Extract",
+			encodeGRPCExtractRequest,
+			decodeGRPCExtractResponse,
+			pb.ExtractReply{},
+			options...,
+		)),
+	}
+}
+
+// grpcClientEndpoint turns the gRPC status errors returned by c back into
+// domain errors.
+func grpcClientEndpoint(c *grpctransport.Client) kitendpoint.Endpoint {
+	e := c.Endpoint()
+	return func(ctx context.Context, request interface{}) (interface{}, error) {
+		response, err := e(ctx, request)
+		if err != nil {
+			return nil, wmerrors.FromGRPC(err)
+		}
+		return response, nil
+	}
+}
+
+func idempotencyKeyToGRPC(ctx context.Context, md *metadata.MD) context.Context {
+	if key := endpoint.IdempotencyKeyFromContext(ctx); key != "" {
+		md.Set(idempotencyKey, key)
+	}
+	return ctx
+}
+
+func encodeGRPCFindRequest(_ context.Context, request interface{}) (interface{}, error) {
+	req := request.(endpoint.FindRequest)
+	var filters []*pb.FindRequest_Filters
+	for _, f := range req.Filters {
+		filters = append(filters, &pb.FindRequest_Filters{Key: f.Key, Value: f.Value})
+	}
+	return &pb.FindRequest{Filters: filters, PageSize: int32(req.PageSize), PageToken: req.PageToken}, nil
+}
+
+func encodeGRPCStatusRequest(_ context.Context, request interface{}) (interface{}, error) {
+	req := request.(endpoint.StatusRequest)
+	return &pb.StatusRequest{TicketID: req.TicketID}, nil
+}
+
+func encodeGRPCCreateDocumentRequest(_ context.Context, request interface{}) (interface{}, error) {
+	req := request.(endpoint.CreateDocumentRequest)
+	return &pb.CreateDocumentRequest{Document: documentToPB(req.Document), Content: req.Content}, nil
+}
+
+func encodeGRPCWatermarkRequest(_ context.Context, request interface{}) (interface{}, error) {
+	req := request.(endpoint.WatermarkRequest)
+	return &pb.WatermarkRequest{TicketID: req.TicketID, Mark: req.Mark}, nil
+}
+
+func encodeGRPCServiceStatusRequest(_ context.Context, _ interface{}) (interface{}, error) {
+	return &pb.ServiceStatusRequest{}, nil
+}
+
+func encodeGRPCExtractRequest(_ context.Context, request interface{}) (interface{}, error) {
+	req := request.(endpoint.ExtractRequest)
+	return &pb.ExtractRequest{Content: req.Content}, nil
+}
+
+func decodeGRPCFindResponse(_ context.Context, grpcReply interface{}) (interface{}, error) {
+	reply := grpcReply.(*pb.FindReply)
+	docs := make([]internal.Document, 0, len(reply.Documents))
+	for _, d := range reply.Documents {
+		docs = append(docs, *documentFromPB(d))
+	}
+	return endpoint.FindResponse{Documents: docs, NextPageToken: reply.NextPageToken}, nil
+}
+
+func decodeGRPCStatusResponse(_ context.Context, grpcReply interface{}) (interface{}, error) {
+	reply := grpcReply.(*pb.StatusReply)
+	return endpoint.StatusResponse{Status: statusFromPB(reply.Status)}, nil
+}
+
+func decodeGRPCCreateDocumentResponse(_ context.Context, grpcReply interface{}) (interface{}, error) {
+	reply := grpcReply.(*pb.CreateDocumentReply)
+	return endpoint.CreateDocumentResponse{TicketID: reply.TicketID}, nil
+}
+
+func decodeGRPCWatermarkResponse(_ context.Context, grpcReply interface{}) (interface{}, error) {
+	reply := grpcReply.(*pb.WatermarkReply)
+	return endpoint.WatermarkResponse{Code: int(reply.Code)}, nil
+}
+
+func decodeGRPCServiceStatusResponse(_ context.Context, grpcReply interface{}) (interface{}, error) {
+	reply := grpcReply.(*pb.ServiceStatusReply)
+	return endpoint.ServiceStatusResponse{Code: int(reply.Code)}, nil
+}
+
+func decodeGRPCExtractResponse(_ context.Context, grpcReply interface{}) (interface{}, error) {
+	reply := grpcReply.(*pb.ExtractReply)
+	return endpoint.ExtractResponse{TicketID: reply.TicketID, Mark: reply.Mark}, nil
+}
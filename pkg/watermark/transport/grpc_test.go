@@ -0,0 +1,395 @@
+package transport
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/go-kit/log"
+	pb "github.com/wzzfarewell/go-microservice-example/api/v1/pb/watermark"
+	"github.com/wzzfarewell/go-microservice-example/internal"
+	"github.com/wzzfarewell/go-microservice-example/pkg/watermark"
+	"github.com/wzzfarewell/go-microservice-example/pkg/watermark/endpoint"
+	wmerrors "github.com/wzzfarewell/go-microservice-example/pkg/watermark/errors"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/test/bufconn"
+)
+
+func TestStatusPB(t *testing.T) {
+	for _, tc := range []struct {
+		status internal.Status
+		pb     pb.StatusReply_Status
+	}{
+		{internal.Pending, pb.StatusReply_PENDING},
+		{internal.Started, pb.StatusReply_STARTED},
+		{internal.InProgress, pb.StatusReply_IN_PROGRESS},
+		{internal.Finished, pb.StatusReply_FINISHED},
+		{internal.Failed, pb.StatusReply_FAILED},
+	} {
+		if got := statusToPB(tc.status); got != tc.pb {
+			t.Errorf("statusToPB(%s) = %s, want %s", tc.status, got, tc.pb)
+		}
+		if got := statusFromPB(tc.pb); got != tc.status {
+			t.Errorf("statusFromPB(%s) = %s, want %s", tc.pb, got, tc.status)
+		}
+	}
+	if got := statusToPB(""); got != pb.StatusReply_PENDING {
+		t.Errorf("statusToPB(\"\") = %s, want PENDING", got)
+	}
+}
+
+func TestDocumentPB(t *testing.T) {
+	doc := &internal.Document{
+		TicketID:      "ticket",
+		Status:        internal.InProgress,
+		ContentType:   "image/png",
+		Title:         "title",
+		Author:        "author",
+		Topic:         "topic",
+		Watermark:     "mark",
+		ContentDigest: "digest",
+		ContentSize:   42,
+		MarkedDigest:  "marked digest",
+		MarkedSize:    43,
+		RequestID:     "request",
+		CreatedBy:     "subject",
+		TenantID:      "tenant",
+	}
+	if got := documentFromPB(documentToPB(doc)); !reflect.DeepEqual(got, doc) {
+		t.Fatalf("round trip = %+v, want %+v", got, doc)
+	}
+	if documentToPB(nil) != nil || documentFromPB(nil) != nil {
+		t.Fatal("nil documents aren't kept nil")
+	}
+}
+
+// stubService returns canned results and records what it was called with.
+type stubService struct {
+	err error
+
+	page     internal.Page
+	filters  []internal.Filter
+	ticketID string
+	mark     string
+	doc      *internal.Document
+	content  []byte
+}
+
+var stubDocuments = []internal.Document{
+	{TicketID: "t1", Status: internal.Finished, Title: "one", Watermark: "mark"},
+	{TicketID: "t2", Status: internal.Pending, Title: "two", ContentSize: 3},
+}
+
+func (s *stubService) Find(_ context.Context, page internal.Page, filters ...internal.Filter) ([]internal.Document, string, error) {
+	s.page, s.filters = page, filters
+	if s.err != nil {
+		return nil, "", s.err
+	}
+	return stubDocuments, "next", nil
+}
+
+func (s *stubService) Status(_ context.Context, ticketID string) (internal.Status, error) {
+	s.ticketID = ticketID
+	if s.err != nil {
+		return "", s.err
+	}
+	return internal.InProgress, nil
+}
+
+func (s *stubService) Watermark(_ context.Context, ticketID, mark string) (int, error) {
+	s.ticketID, s.mark = ticketID, mark
+	if s.err != nil {
+		return wmerrors.HTTPStatus(s.err), s.err
+	}
+	return http.StatusAccepted, nil
+}
+
+func (s *stubService) CreateDocument(_ context.Context, doc *internal.Document, content io.Reader) (string, error) {
+	s.doc = doc
+	s.content, _ = io.ReadAll(content)
+	if s.err != nil {
+		return "", s.err
+	}
+	return "created", nil
+}
+
+func (s *stubService) ServiceStatus(context.Context) (int, error) {
+	if s.err != nil {
+		return wmerrors.HTTPStatus(s.err), s.err
+	}
+	return http.StatusOK, nil
+}
+
+func (s *stubService) Extract(_ context.Context, content []byte) (string, string, error) {
+	s.content = content
+	if s.err != nil {
+		return "", "", s.err
+	}
+	return "extracted", "mark", nil
+}
+
+// testMaxUploadSize limits the uploads to the servers under test.
+const testMaxUploadSize = 16
+
+// newBufconnClient serves svc over an in-memory connection and returns a
+// client of it.
+func newBufconnClient(t *testing.T, svc watermark.Service) watermark.Service {
+	t.Helper()
+	return NewGRPCClient(newBufconnConn(t, svc))
+}
+
+func newBufconnConn(t *testing.T, svc watermark.Service) *grpc.ClientConn {
+	t.Helper()
+	lis := bufconn.Listen(1 << 20)
+	server := grpc.NewServer()
+	pb.RegisterWatermarkServer(server, NewGRPCServer(endpoint.NewEndpointSet(svc), nil, testMaxUploadSize, log.NewNopLogger()))
+	go server.Serve(lis)
+	t.Cleanup(server.Stop)
+
+	conn, err := grpc.Dial("bufconn",
+		grpc.WithContextDialer(func(ctx context.Context, _ string) (net.Conn, error) { return lis.DialContext(ctx) }),
+		grpc.WithTransportCredentials(insecure.NewCredentials()),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	return conn
+}
+
+func TestGRPCRoundTrip(t *testing.T) {
+	ctx := context.Background()
+	for _, tc := range []struct {
+		name string
+		call func(watermark.Service) (interface{}, error)
+		want interface{}
+		// check verifies what the service received
+		check func(*stubService) error
+	}{
+		{
+			name: "Find",
+			call: func(c watermark.Service) (interface{}, error) {
+				docs, next, err := c.Find(ctx, internal.Page{Size: 2, Token: "token"}, internal.Filter{Key: "title", Value: "one"}, internal.Filter{Key: "author"})
+				return []interface{}{docs, next}, err
+			},
+			want: []interface{}{stubDocuments, "next"},
+			check: func(s *stubService) error {
+				want := []internal.Filter{{Key: "title", Value: "one"}, {Key: "author"}}
+				if s.page != (internal.Page{Size: 2, Token: "token"}) || !reflect.DeepEqual(s.filters, want) {
+					return errors.New("wrong page or filters")
+				}
+				return nil
+			},
+		},
+		{
+			name: "Status",
+			call: func(c watermark.Service) (interface{}, error) { return c.Status(ctx, "t1") },
+			want: internal.InProgress,
+			check: func(s *stubService) error {
+				if s.ticketID != "t1" {
+					return errors.New("wrong ticket ID")
+				}
+				return nil
+			},
+		},
+		{
+			name: "Watermark",
+			call: func(c watermark.Service) (interface{}, error) { return c.Watermark(ctx, "t1", "mark") },
+			want: http.StatusAccepted,
+			check: func(s *stubService) error {
+				if s.ticketID != "t1" || s.mark != "mark" {
+					return errors.New("wrong ticket ID or mark")
+				}
+				return nil
+			},
+		},
+		{
+			name: "CreateDocument",
+			call: func(c watermark.Service) (interface{}, error) {
+				doc := &internal.Document{Title: "title", Author: "author", Topic: "topic", ContentType: "text/plain"}
+				return c.CreateDocument(ctx, doc, strings.NewReader("content"))
+			},
+			want: "created",
+			check: func(s *stubService) error {
+				if s.doc == nil || s.doc.Title != "title" || s.doc.Author != "author" || s.doc.Topic != "topic" || s.doc.ContentType != "text/plain" {
+					return errors.New("wrong document")
+				}
+				if string(s.content) != "content" {
+					return errors.New("wrong content")
+				}
+				return nil
+			},
+		},
+		{
+			name: "ServiceStatus",
+			call: func(c watermark.Service) (interface{}, error) { return c.ServiceStatus(ctx) },
+			want: http.StatusOK,
+		},
+		{
+			name: "Extract",
+			call: func(c watermark.Service) (interface{}, error) {
+				ticketID, mark, err := c.Extract(ctx, []byte("copy"))
+				return []string{ticketID, mark}, err
+			},
+			want: []string{"extracted", "mark"},
+			check: func(s *stubService) error {
+				if string(s.content) != "copy" {
+					return errors.New("wrong content")
+				}
+				return nil
+			},
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			svc := &stubService{}
+			got, err := tc.call(newBufconnClient(t, svc))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(got, tc.want) {
+				t.Fatalf("got %#v, want %#v", got, tc.want)
+			}
+			if tc.check != nil {
+				if err := tc.check(svc); err != nil {
+					t.Fatal(err)
+				}
+			}
+		})
+	}
+}
+
+func TestGRPCErrors(t *testing.T) {
+	ctx := context.Background()
+	for _, tc := range []struct {
+		err    error
+		target interface{}
+	}{
+		{&wmerrors.NotFound{Message: "no such ticket"}, new(*wmerrors.NotFound)},
+		{&wmerrors.InvalidArgument{Message: "bad"}, new(*wmerrors.InvalidArgument)},
+		{&wmerrors.Conflict{Message: "busy"}, new(*wmerrors.Conflict)},
+		{&wmerrors.Unavailable{Message: "down"}, new(*wmerrors.Unavailable)},
+		{&wmerrors.Unprocessable{Message: "no"}, new(*wmerrors.Unprocessable)},
+		{&wmerrors.Unauthenticated{Message: "who"}, new(*wmerrors.Unauthenticated)},
+		{&wmerrors.PermissionDenied{Message: "nope"}, new(*wmerrors.PermissionDenied)},
+	} {
+		client := newBufconnClient(t, &stubService{err: tc.err})
+		_, err := client.Status(ctx, "t1")
+		if !errors.As(err, tc.target) {
+			t.Errorf("Status failing with %T returned %T (%v)", tc.err, err, err)
+			continue
+		}
+		if err.Error() != tc.err.Error() {
+			t.Errorf("message %q, want %q", err.Error(), tc.err.Error())
+		}
+	}
+}
+
+func TestGRPCExtractErrors(t *testing.T) {
+	for _, want := range []error{
+		&wmerrors.NotFound{Message: "no watermark found"},
+		&wmerrors.InvalidArgument{Message: "can't extract watermarks from application/pdf"},
+	} {
+		client := newBufconnClient(t, &stubService{err: want})
+		_, _, err := client.Extract(context.Background(), []byte("copy"))
+		if wmerrors.HTTPStatus(err) != wmerrors.HTTPStatus(want) || err.Error() != want.Error() {
+			t.Errorf("got %v, want %v", err, want)
+		}
+	}
+}
+
+func TestGRPCUploadDocument(t *testing.T) {
+	tmp := t.TempDir()
+	t.Setenv("TMPDIR", tmp)
+	for _, tc := range []struct {
+		name    string
+		msgs    []*pb.UploadDocumentRequest
+		content string
+		wantErr bool
+	}{
+		{
+			name: "chunks",
+			msgs: []*pb.UploadDocumentRequest{
+				{Data: &pb.UploadDocumentRequest_Metadata{Metadata: &pb.Document{Title: "title", ContentType: "text/plain", Content: "ignored"}}},
+				{Data: &pb.UploadDocumentRequest_Chunk{Chunk: []byte("con")}},
+				{Data: &pb.UploadDocumentRequest_Chunk{Chunk: []byte("tent")}},
+			},
+			content: "content",
+		},
+		{
+			name: "at the limit",
+			msgs: []*pb.UploadDocumentRequest{
+				{Data: &pb.UploadDocumentRequest_Metadata{Metadata: &pb.Document{Title: "title"}}},
+				{Data: &pb.UploadDocumentRequest_Chunk{Chunk: []byte(strings.Repeat("x", testMaxUploadSize))}},
+			},
+			content: strings.Repeat("x", testMaxUploadSize),
+		},
+		{
+			name: "too large",
+			msgs: []*pb.UploadDocumentRequest{
+				{Data: &pb.UploadDocumentRequest_Metadata{Metadata: &pb.Document{Title: "title"}}},
+				{Data: &pb.UploadDocumentRequest_Chunk{Chunk: []byte(strings.Repeat("x", testMaxUploadSize))}},
+				{Data: &pb.UploadDocumentRequest_Chunk{Chunk: []byte("x")}},
+			},
+			wantErr: true,
+		},
+		{
+			name:    "missing metadata",
+			wantErr: true,
+		},
+		{
+			name: "chunk before metadata",
+			msgs: []*pb.UploadDocumentRequest{
+				{Data: &pb.UploadDocumentRequest_Chunk{Chunk: []byte("content")}},
+			},
+			wantErr: true,
+		},
+		{
+			name: "metadata twice",
+			msgs: []*pb.UploadDocumentRequest{
+				{Data: &pb.UploadDocumentRequest_Metadata{Metadata: &pb.Document{Title: "title"}}},
+				{Data: &pb.UploadDocumentRequest_Metadata{Metadata: &pb.Document{Title: "title"}}},
+			},
+			wantErr: true,
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			svc := &stubService{}
+			stream, err := pb.NewWatermarkClient(newBufconnConn(t, svc)).UploadDocument(context.Background())
+			if err != nil {
+				t.Fatal(err)
+			}
+			for _, msg := range tc.msgs {
+				// the server may already have given up on a failed upload
+				if err := stream.Send(msg); err == io.EOF {
+					break
+				} else if err != nil {
+					t.Fatal(err)
+				}
+			}
+			reply, err := stream.CloseAndRecv()
+			if tc.wantErr {
+				var invalid *wmerrors.InvalidArgument
+				if !errors.As(wmerrors.FromGRPC(err), &invalid) {
+					t.Fatalf("got %v, want an invalid argument", err)
+				}
+			} else {
+				if err != nil {
+					t.Fatal(err)
+				}
+				if reply.TicketID != "created" || svc.doc.Title != "title" || string(svc.content) != tc.content {
+					t.Fatalf("created %q with %+v and %q", reply.TicketID, svc.doc, svc.content)
+				}
+			}
+			if entries, _ := os.ReadDir(tmp); len(entries) != 0 {
+				t.Fatalf("%d spooled uploads left behind", len(entries))
+			}
+		})
+	}
+}
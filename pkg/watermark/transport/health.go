@@ -0,0 +1,58 @@
+package transport
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"time"
+
+	"github.com/wzzfarewell/go-microservice-example/pkg/watermark"
+	"google.golang.org/grpc/health"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+)
+
+// serveLiveness answers as long as the process can serve HTTP at all. It
+// checks no dependency, so a failing one never gets the process restarted.
+func serveLiveness() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json; charset=utf-8")
+		json.NewEncoder(w).Encode(map[string]string{"status": watermark.HealthOK})
+	})
+}
+
+// serveReadiness writes the health report, with 503 unless every component
+// is healthy.
+func serveReadiness(h *watermark.Health) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		report := h.Check(r.Context())
+		w.Header().Set("Content-Type", "application/json; charset=utf-8")
+		if !report.OK() {
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}
+		json.NewEncoder(w).Encode(report)
+	})
+}
+
+// UpdateGRPCHealth reports the readiness of the service through hs every
+// interval until ctx is done, both for the watermark service and for the
+// server as a whole (the empty service name).
+func UpdateGRPCHealth(ctx context.Context, h *watermark.Health, hs *health.Server, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		status := healthpb.HealthCheckResponse_SERVING
+		if report := h.Check(ctx); !report.OK() {
+			status = healthpb.HealthCheckResponse_NOT_SERVING
+		}
+		if ctx.Err() != nil {
+			return
+		}
+		hs.SetServingStatus("", status)
+		hs.SetServingStatus(grpcServiceName, status)
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+			return
+		}
+	}
+}
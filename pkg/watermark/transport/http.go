@@ -3,56 +3,123 @@ package transport
 import (
 	"context"
 	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
 	"net/http"
-	"os"
+	"strconv"
 
-	"github.com/go-kit/kit/log"
+	"github.com/go-kit/kit/transport"
 	httptransport "github.com/go-kit/kit/transport/http"
+	"github.com/go-kit/log"
+	"github.com/go-kit/log/level"
 	"github.com/gorilla/mux"
 	"github.com/julienschmidt/httprouter"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"github.com/wzzfarewell/go-microservice-example/internal"
 	"github.com/wzzfarewell/go-microservice-example/internal/util"
+	"github.com/wzzfarewell/go-microservice-example/pkg/watermark"
+	"github.com/wzzfarewell/go-microservice-example/pkg/watermark/auth"
 	"github.com/wzzfarewell/go-microservice-example/pkg/watermark/endpoint"
+	wmerrors "github.com/wzzfarewell/go-microservice-example/pkg/watermark/errors"
 )
 
-var logger log.Logger
+// maxExtractSize limits the size of the documents accepted by the extract route.
+const maxExtractSize = 32 << 20
 
-func init() {
-	logger = log.NewLogfmtLogger(log.NewSyncWriter(os.Stderr))
-	logger = log.With(logger, "ts", log.DefaultTimestampUTC)
-}
+// The routes of the probes and metrics, which need no credentials.
+const (
+	livezPath   = "/livez"
+	readyzPath  = "/readyz"
+	metricsPath = "/metrics"
+)
 
-func NewHTTPHandler(eps endpoint.Set) http.Handler {
+var probePaths = map[string]bool{livezPath: true, readyzPath: true, metricsPath: true}
+
+// NewHTTPHandler serves the endpoints and streams. Uploaded content is
+// limited to maxUploadSize bytes.
+func NewHTTPHandler(eps endpoint.Set, streamer watermark.Streamer, health *watermark.Health, maxUploadSize int64, logger log.Logger) http.Handler {
 	r := mux.NewRouter()
+	options := []httptransport.ServerOption{
+		httptransport.ServerErrorEncoder(encodeError),
+		// the endpoints log their errors already, this adds the failed decodes
+		httptransport.ServerErrorHandler(transport.NewLogErrorHandler(level.Debug(logger))),
+		httptransport.ServerBefore(requestContextFromHTTP...),
+		httptransport.ServerBefore(idempotencyKeyFromHTTP),
+		httptransport.ServerAfter(requestIDToHTTPResponse),
+	}
 
+	r.Methods("GET").Path(livezPath).Handler(serveLiveness())
+	r.Methods("GET").Path(readyzPath).Handler(serveReadiness(health))
+	r.Methods("GET").Path(metricsPath).Handler(promhttp.Handler())
 	r.Methods("GET").Path("/api/v1/watermark/healthz").Handler(httptransport.NewServer(
 		eps.ServiceStatusEndpoint,
 		decodeHTTPServiceStatusRequest,
 		encodeResponse,
+		options...,
 	))
 	r.Methods("GET").Path("/api/v1/watermark/documents/{id}/status").Handler(httptransport.NewServer(
 		eps.StatusEndpoint,
 		decodeHTTPStatusRequest,
 		encodeResponse,
+		options...,
 	))
+	r.Methods("GET").Path("/api/v1/watermark/documents/{id}/events").Handler(withRequestContext(serveStatusEvents(streamer), streamCredentialsFromHTTP))
+	r.Methods("GET").Path("/api/v1/watermark/documents/{id}/content").Handler(withRequestContext(serveContent(streamer, logger)))
+	r.Methods("GET").Path("/api/v1/watermark/events").Handler(withRequestContext(serveStatusWebSocket(streamer), streamCredentialsFromHTTP))
 	r.Methods("GET").Path("/api/v1/watermark/documents").Handler(httptransport.NewServer(
 		eps.FindEndpoint,
 		decodeHTTPFindRequest,
 		encodeResponse,
+		options...,
 	))
 	r.Methods("POST").Path("/api/v1/watermark/documents").Handler(httptransport.NewServer(
 		eps.CreateDocumentEndpoint,
-		decodeHTTPCreateDocumentRequest,
+		decodeHTTPCreateDocumentRequest(maxUploadSize),
 		encodeResponse,
+		append(options, httptransport.ServerBefore(trackUploads), httptransport.ServerFinalizer(removeUploads))...,
 	))
 	r.Methods("POST").Path("/api/v1/watermark/watermark").Handler(httptransport.NewServer(
 		eps.WatermarkEndpoint,
 		decodeHTTPWatermarkRequest,
 		encodeResponse,
+		options...,
+	))
+	r.Methods("POST").Path("/api/v1/watermark/extract").Handler(httptransport.NewServer(
+		eps.ExtractEndpoint,
+		decodeHTTPExtractRequest,
+		encodeResponse,
+		options...,
 	))
 
 	return r
 }
 
+func idempotencyKeyFromHTTP(ctx context.Context, r *http.Request) context.Context {
+	return endpoint.ContextWithIdempotencyKey(ctx, r.Header.Get(endpoint.IdempotencyKeyHeader))
+}
+
+// requestContextFromHTTP moves what every request carries in its headers
+// into the context.
+var requestContextFromHTTP = []httptransport.RequestFunc{traceContextFromHTTP, requestIDFromHTTP, credentialsFromHTTP, peerFromHTTP}
+
+// withRequestContext does for the handlers that aren't go-kit servers what
+// the ServerBefore and ServerAfter options do for the others. The before
+// functions run after the ones of every request.
+func withRequestContext(h http.Handler, before ...httptransport.RequestFunc) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+		for _, f := range requestContextFromHTTP {
+			ctx = f(ctx, r)
+		}
+		for _, f := range before {
+			ctx = f(ctx, r)
+		}
+		requestIDToHTTPResponse(ctx, w)
+		h.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
+
 func routerHandler(handler http.Handler) httprouter.Handle {
 	return func(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
 		handler.ServeHTTP(w, r)
@@ -61,13 +128,23 @@ func routerHandler(handler http.Handler) httprouter.Handle {
 
 func decodeHTTPFindRequest(_ context.Context, r *http.Request) (interface{}, error) {
 	var req endpoint.FindRequest
-	if r.ContentLength == 0 {
-		logger.Log("Get request with no body")
-		return req, nil
+	// the body, carrying the filters, is optional
+	if r.ContentLength != 0 {
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			return nil, fmt.Errorf("%w: %v", util.ErrInvalidArgument, err)
+		}
 	}
-	err := json.NewDecoder(r.Body).Decode(&req)
-	if err != nil {
-		return nil, err
+	// the paging parameters in the query string take precedence over the body
+	query := r.URL.Query()
+	if size := query.Get("page_size"); size != "" {
+		n, err := strconv.Atoi(size)
+		if err != nil {
+			return nil, fmt.Errorf("%w: page_size must be a number", util.ErrInvalidArgument)
+		}
+		req.PageSize = n
+	}
+	if token := query.Get("page_token"); token != "" {
+		req.PageToken = token
 	}
 	return req, nil
 }
@@ -83,18 +160,54 @@ func decodeHTTPWatermarkRequest(_ context.Context, r *http.Request) (interface{}
 	var req endpoint.WatermarkRequest
 	err := json.NewDecoder(r.Body).Decode(&req)
 	if err != nil {
-		return nil, err
+		return nil, fmt.Errorf("%w: %v", util.ErrInvalidArgument, err)
 	}
 	return req, nil
 }
 
-func decodeHTTPCreateDocumentRequest(_ context.Context, r *http.Request) (interface{}, error) {
-	var req endpoint.CreateDocumentRequest
-	err := json.NewDecoder(r.Body).Decode(&req)
+// decodeHTTPCreateDocumentRequest accepts either a JSON body or a
+// multipart/form-data upload, see decodeMultipartDocument. The JSON body,
+// content included, is limited to maxUploadSize bytes too.
+func decodeHTTPCreateDocumentRequest(maxUploadSize int64) httptransport.DecodeRequestFunc {
+	return func(ctx context.Context, r *http.Request) (interface{}, error) {
+		if isMultipart(r) {
+			return decodeMultipartDocument(ctx, r, maxUploadSize)
+		}
+		var body createDocumentBody
+		err := json.NewDecoder(http.MaxBytesReader(nil, r.Body, maxUploadSize)).Decode(&body)
+		var tooLarge *http.MaxBytesError
+		switch {
+		case errors.As(err, &tooLarge):
+			return nil, fmt.Errorf("%w: body larger than %d bytes", util.ErrInvalidArgument, maxUploadSize)
+		case err != nil:
+			return nil, fmt.Errorf("%w: %v", util.ErrInvalidArgument, err)
+		}
+		if body.Document == nil {
+			return endpoint.CreateDocumentRequest{}, nil
+		}
+		return endpoint.CreateDocumentRequest{Document: &body.Document.Document, Content: []byte(body.Document.Content)}, nil
+	}
+}
+
+// createDocumentBody is the JSON body of the create route, which takes the
+// content inline as a string next to the other fields of the document.
+type createDocumentBody struct {
+	Document *struct {
+		internal.Document
+		Content string `json:"content"`
+	} `json:"document"`
+}
+
+// decodeHTTPExtractRequest takes the suspect copy as the raw request body.
+func decodeHTTPExtractRequest(_ context.Context, r *http.Request) (interface{}, error) {
+	content, err := io.ReadAll(io.LimitReader(r.Body, maxExtractSize+1))
 	if err != nil {
 		return nil, err
 	}
-	return req, nil
+	if len(content) > maxExtractSize {
+		return nil, fmt.Errorf("%w: content larger than %d bytes", util.ErrInvalidArgument, maxExtractSize)
+	}
+	return endpoint.ExtractRequest{Content: content}, nil
 }
 
 func decodeHTTPServiceStatusRequest(_ context.Context, _ *http.Request) (interface{}, error) {
@@ -107,20 +220,18 @@ func encodeResponse(ctx context.Context, w http.ResponseWriter, response interfa
 		encodeError(ctx, e, w)
 		return nil
 	}
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
 	return json.NewEncoder(w).Encode(response)
 }
 
-func encodeError(_ context.Context, err error, w http.ResponseWriter) {
-	w.Header().Set("Content-Type", "application/json; charset=utf-8")
-	switch err {
-	case util.ErrPathParamNotFound:
-		w.WriteHeader(http.StatusNotFound)
-	case util.ErrInvalidArgument:
-		w.WriteHeader(http.StatusBadRequest)
-	default:
-		w.WriteHeader(http.StatusInternalServerError)
+// encodeError writes the shared error body with the status code of the
+// domain error, or 500 for anything else.
+func encodeError(ctx context.Context, err error, w http.ResponseWriter) {
+	requestIDToHTTPResponse(ctx, w)
+	if wmerrors.HTTPStatus(err) == http.StatusUnauthorized {
+		w.Header().Set("WWW-Authenticate", auth.SchemeBearer)
 	}
-	json.NewEncoder(w).Encode(map[string]interface{}{
-		"error": err.Error(),
-	})
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	w.WriteHeader(wmerrors.HTTPStatus(err))
+	json.NewEncoder(w).Encode(wmerrors.NewBody(err))
 }
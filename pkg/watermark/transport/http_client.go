@@ -0,0 +1,204 @@
+package transport
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"net/url"
+	"strings"
+
+	httptransport "github.com/go-kit/kit/transport/http"
+	"github.com/wzzfarewell/go-microservice-example/pkg/watermark"
+	"github.com/wzzfarewell/go-microservice-example/pkg/watermark/endpoint"
+	wmerrors "github.com/wzzfarewell/go-microservice-example/pkg/watermark/errors"
+)
+
+// NewHTTPClient returns a watermark.Service backed by the HTTP server running
+// at instance, e.g. "localhost:8081" or "https://watermark.example.com".
+func NewHTTPClient(instance string) (watermark.Service, error) {
+	if !strings.HasPrefix(instance, "http") {
+		instance = "http://" + instance
+	}
+	u, err := url.Parse(instance)
+	if err != nil {
+		return nil, err
+	}
+
+	options := []httptransport.ClientOption{httptransport.ClientBefore(traceContextToHTTP, requestIDToHTTP, credentialsToHTTP)}
+	idempotent := append(options, httptransport.ClientBefore(idempotencyKeyToHTTP))
+	return &endpoint.Set{
+		FindEndpoint: httptransport.NewClient(
+			"GET",
+			copyURL(u, "/api/v1/watermark/documents"),
+			encodeHTTPGenericRequest,
+			decodeHTTPFindResponse,
+			options...,
+		).Endpoint(),
+		StatusEndpoint: httptransport.NewClient(
+			"GET",
+			copyURL(u, "/api/v1/watermark/documents"),
+			encodeHTTPStatusRequest,
+			decodeHTTPStatusResponse,
+			options...,
+		).Endpoint(),
+		CreateDocumentEndpoint: httptransport.NewClient(
+			"POST",
+			copyURL(u, "/api/v1/watermark/documents"),
+			encodeHTTPCreateDocumentRequest,
+			decodeHTTPCreateDocumentResponse,
+			idempotent...,
+		).Endpoint(),
+		WatermarkEndpoint: httptransport.NewClient(
+			"POST",
+			copyURL(u, "/api/v1/watermark/watermark"),
+			encodeHTTPGenericRequest,
+			decodeHTTPWatermarkResponse,
+			idempotent...,
+		).Endpoint(),
+		ServiceStatusEndpoint: httptransport.NewClient(
+			"GET",
+			copyURL(u, "/api/v1/watermark/healthz"),
+			encodeHTTPGenericRequest,
+			decodeHTTPServiceStatusResponse,
+			options...,
+		).Endpoint(),
+		ExtractEndpoint: httptransport.NewClient(
+			"POST",
+			copyURL(u, "/api/v1/watermark/extract"),
+			encodeHTTPExtractRequest,
+			decodeHTTPExtractResponse,
+			options...,
+		).Endpoint(),
+	}, nil
+}
+
+func idempotencyKeyToHTTP(ctx context.Context, r *http.Request) context.Context {
+	if key := endpoint.IdempotencyKeyFromContext(ctx); key != "" {
+		r.Header.Set(endpoint.IdempotencyKeyHeader, key)
+	}
+	return ctx
+}
+
+func copyURL(base *url.URL, path string) *url.URL {
+	next := *base
+	next.Path = strings.TrimSuffix(base.Path, "/") + path
+	return &next
+}
+
+// encodeHTTPGenericRequest JSON encodes the request into the body, which is
+// all the server needs for every route but the status one.
+func encodeHTTPGenericRequest(_ context.Context, r *http.Request, request interface{}) error {
+	var buf bytes.Buffer
+	if err := json.NewEncoder(&buf).Encode(request); err != nil {
+		return err
+	}
+	r.Header.Set("Content-Type", "application/json; charset=utf-8")
+	r.Body = io.NopCloser(&buf)
+	return nil
+}
+
+func encodeHTTPStatusRequest(_ context.Context, r *http.Request, request interface{}) error {
+	req := request.(endpoint.StatusRequest)
+	if req.TicketID == "" {
+		return fmt.Errorf("empty ticket id")
+	}
+	// RawPath keeps a "/" in the ticket ID from being taken for a separator
+	r.URL.RawPath = fmt.Sprintf("%s/%s/status", r.URL.EscapedPath(), url.PathEscape(req.TicketID))
+	r.URL.Path = fmt.Sprintf("%s/%s/status", r.URL.Path, req.TicketID)
+	return nil
+}
+
+// encodeHTTPCreateDocumentRequest uploads the document as multipart/form-data
+// so binary content is sent as is.
+func encodeHTTPCreateDocumentRequest(_ context.Context, r *http.Request, request interface{}) error {
+	req := request.(endpoint.CreateDocumentRequest)
+	if req.Document == nil {
+		return fmt.Errorf("empty document")
+	}
+	var buf bytes.Buffer
+	mw := multipart.NewWriter(&buf)
+	fields := [][2]string{
+		{"title", req.Document.Title},
+		{"author", req.Document.Author},
+		{"topic", req.Document.Topic},
+		{"content_type", req.Document.ContentType},
+	}
+	for _, f := range fields {
+		if err := mw.WriteField(f[0], f[1]); err != nil {
+			return err
+		}
+	}
+	fw, err := mw.CreateFormFile("file", "content")
+	if err != nil {
+		return err
+	}
+	if _, err := fw.Write(req.Content); err != nil {
+		return err
+	}
+	if err := mw.Close(); err != nil {
+		return err
+	}
+	r.Header.Set("Content-Type", mw.FormDataContentType())
+	r.ContentLength = int64(buf.Len())
+	r.Body = io.NopCloser(&buf)
+	return nil
+}
+
+func encodeHTTPExtractRequest(_ context.Context, r *http.Request, request interface{}) error {
+	req := request.(endpoint.ExtractRequest)
+	r.Header.Set("Content-Type", "application/octet-stream")
+	r.ContentLength = int64(len(req.Content))
+	r.Body = io.NopCloser(bytes.NewReader(req.Content))
+	return nil
+}
+
+func decodeHTTPFindResponse(_ context.Context, r *http.Response) (interface{}, error) {
+	var resp endpoint.FindResponse
+	err := decodeHTTPResponse(r, &resp)
+	return resp, err
+}
+
+func decodeHTTPStatusResponse(_ context.Context, r *http.Response) (interface{}, error) {
+	var resp endpoint.StatusResponse
+	err := decodeHTTPResponse(r, &resp)
+	return resp, err
+}
+
+func decodeHTTPCreateDocumentResponse(_ context.Context, r *http.Response) (interface{}, error) {
+	var resp endpoint.CreateDocumentResponse
+	err := decodeHTTPResponse(r, &resp)
+	return resp, err
+}
+
+func decodeHTTPWatermarkResponse(_ context.Context, r *http.Response) (interface{}, error) {
+	var resp endpoint.WatermarkResponse
+	err := decodeHTTPResponse(r, &resp)
+	return resp, err
+}
+
+func decodeHTTPServiceStatusResponse(_ context.Context, r *http.Response) (interface{}, error) {
+	var resp endpoint.ServiceStatusResponse
+	err := decodeHTTPResponse(r, &resp)
+	return resp, err
+}
+
+func decodeHTTPExtractResponse(_ context.Context, r *http.Response) (interface{}, error) {
+	var resp endpoint.ExtractResponse
+	err := decodeHTTPResponse(r, &resp)
+	return resp, err
+}
+
+// decodeHTTPResponse decodes a successful response into resp, and turns the
+// body written by encodeError back into a domain error otherwise.
+func decodeHTTPResponse(r *http.Response, resp interface{}) error {
+	if r.StatusCode < 200 || r.StatusCode > 299 {
+		var body wmerrors.Body
+		json.NewDecoder(r.Body).Decode(&body)
+		return wmerrors.FromHTTP(r.StatusCode, body)
+	}
+	return json.NewDecoder(r.Body).Decode(resp)
+}
@@ -0,0 +1,125 @@
+package transport
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/go-kit/log"
+	"github.com/wzzfarewell/go-microservice-example/internal"
+	"github.com/wzzfarewell/go-microservice-example/pkg/watermark/endpoint"
+	wmerrors "github.com/wzzfarewell/go-microservice-example/pkg/watermark/errors"
+)
+
+func newHTTPServer(t *testing.T, svc *stubService) *httptest.Server {
+	t.Helper()
+	server := httptest.NewServer(NewHTTPHandler(endpoint.NewEndpointSet(svc), nil, nil, testMaxUploadSize, log.NewNopLogger()))
+	t.Cleanup(server.Close)
+	return server
+}
+
+func TestHTTPUploadDocument(t *testing.T) {
+	tmp := t.TempDir()
+	t.Setenv("TMPDIR", tmp)
+	for _, tc := range []struct {
+		name    string
+		content string
+		wantErr bool
+	}{
+		{name: "small", content: "content"},
+		{name: "at the limit", content: strings.Repeat("x", testMaxUploadSize)},
+		{name: "too large", content: strings.Repeat("x", testMaxUploadSize+1), wantErr: true},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			svc := &stubService{}
+			client, err := NewHTTPClient(newHTTPServer(t, svc).URL)
+			if err != nil {
+				t.Fatal(err)
+			}
+			doc := &internal.Document{Title: "title", ContentType: "text/plain"}
+			ticketID, err := client.CreateDocument(context.Background(), doc, strings.NewReader(tc.content))
+			if tc.wantErr {
+				var invalid *wmerrors.InvalidArgument
+				if !errors.As(err, &invalid) {
+					t.Fatalf("got %v, want an invalid argument", err)
+				}
+			} else {
+				if err != nil {
+					t.Fatal(err)
+				}
+				if ticketID != "created" || svc.doc.Title != "title" || string(svc.content) != tc.content {
+					t.Fatalf("created %q with %+v and %q", ticketID, svc.doc, svc.content)
+				}
+			}
+			if entries, _ := os.ReadDir(tmp); len(entries) != 0 {
+				t.Fatalf("%d spooled uploads left behind", len(entries))
+			}
+		})
+	}
+}
+
+func TestHTTPJSONBodyLimit(t *testing.T) {
+	server := newHTTPServer(t, &stubService{})
+	body := `{"document":{"title":"` + strings.Repeat("x", testMaxUploadSize) + `"}}`
+	resp, err := http.Post(server.URL+"/api/v1/watermark/documents", "application/json", strings.NewReader(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusBadRequest {
+		t.Fatalf("status %d, want %d", resp.StatusCode, http.StatusBadRequest)
+	}
+}
+
+func TestRequireClientCert(t *testing.T) {
+	h := RequireClientCert(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {}))
+	for path, want := range map[string]int{
+		"/livez":                      http.StatusOK,
+		"/readyz":                     http.StatusOK,
+		"/metrics":                    http.StatusOK,
+		"/api/v1/watermark/documents": http.StatusUnauthorized,
+	} {
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, httptest.NewRequest("GET", path, nil))
+		if w.Code != want {
+			t.Errorf("%s: status %d, want %d", path, w.Code, want)
+		}
+	}
+}
+
+func TestHTTPExtract(t *testing.T) {
+	ctx := context.Background()
+	svc := &stubService{}
+	client, err := NewHTTPClient(newHTTPServer(t, svc).URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ticketID, mark, err := client.Extract(ctx, []byte("copy"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ticketID != "extracted" || mark != "mark" {
+		t.Fatalf("got %q, %q", ticketID, mark)
+	}
+	if string(svc.content) != "copy" {
+		t.Fatalf("the service got %q", svc.content)
+	}
+
+	for _, want := range []error{
+		&wmerrors.NotFound{Message: "no watermark found"},
+		&wmerrors.InvalidArgument{Message: "can't extract watermarks from application/pdf"},
+	} {
+		client, err := NewHTTPClient(newHTTPServer(t, &stubService{err: want}).URL)
+		if err != nil {
+			t.Fatal(err)
+		}
+		_, _, err = client.Extract(ctx, []byte("copy"))
+		if wmerrors.HTTPStatus(err) != wmerrors.HTTPStatus(want) || err.Error() != want.Error() {
+			t.Errorf("got %v, want %v", err, want)
+		}
+	}
+}
@@ -0,0 +1,77 @@
+package transport
+
+import (
+	"context"
+	"net/http"
+	"strings"
+
+	"github.com/google/uuid"
+	"github.com/wzzfarewell/go-microservice-example/pkg/watermark/logging"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/metadata"
+)
+
+// RequestIDHeader is the HTTP header, and in lower case the gRPC metadata
+// key, carrying the ID that correlates a request across clients, servers and
+// logs. Servers accept the one a client sends or generate one, and echo it
+// in the response.
+const RequestIDHeader = "X-Request-ID"
+
+// requestIDKey is the gRPC metadata key matching the X-Request-ID header.
+var requestIDKey = strings.ToLower(RequestIDHeader)
+
+// maxRequestIDLength bounds the IDs accepted from clients, as they end up in
+// every log line and on the tickets.
+const maxRequestIDLength = 128
+
+// acceptRequestID returns id if it is usable, else a new one.
+func acceptRequestID(id string) string {
+	if id == "" || len(id) > maxRequestIDLength {
+		return uuid.NewString()
+	}
+	for _, c := range id {
+		if c < '!' || c > '~' {
+			return uuid.NewString()
+		}
+	}
+	return id
+}
+
+func requestIDFromHTTP(ctx context.Context, r *http.Request) context.Context {
+	return logging.ContextWithRequestID(ctx, acceptRequestID(r.Header.Get(RequestIDHeader)))
+}
+
+// requestIDToHTTPResponse echoes the request ID, it runs both after the
+// endpoint succeeded and from encodeError.
+func requestIDToHTTPResponse(ctx context.Context, w http.ResponseWriter) context.Context {
+	if id := logging.RequestIDFromContext(ctx); id != "" {
+		w.Header().Set(RequestIDHeader, id)
+	}
+	return ctx
+}
+
+func requestIDToHTTP(ctx context.Context, r *http.Request) context.Context {
+	if id := logging.RequestIDFromContext(ctx); id != "" {
+		r.Header.Set(RequestIDHeader, id)
+	}
+	return ctx
+}
+
+// requestIDFromGRPC echoes the ID in the response header right away, which
+// grpc sends along with the reply or the error status alike.
+func requestIDFromGRPC(ctx context.Context, md metadata.MD) context.Context {
+	var id string
+	if values := md.Get(requestIDKey); len(values) > 0 {
+		id = values[0]
+	}
+	id = acceptRequestID(id)
+	grpc.SetHeader(ctx, metadata.Pairs(requestIDKey, id))
+	return logging.ContextWithRequestID(ctx, id)
+}
+
+func requestIDToGRPC(ctx context.Context, md *metadata.MD) context.Context {
+	if id := logging.RequestIDFromContext(ctx); id != "" {
+		md.Set(requestIDKey, id)
+	}
+	return ctx
+}
@@ -2,62 +2,165 @@ package watermark
 
 import (
 	"context"
+	"fmt"
+	"io"
 	"net/http"
-	"os"
 
-	"github.com/go-kit/kit/log"
+	"github.com/go-kit/log"
+	"github.com/go-kit/log/level"
 	"github.com/google/uuid"
 	"github.com/wzzfarewell/go-microservice-example/internal"
+	"github.com/wzzfarewell/go-microservice-example/internal/util"
+	"github.com/wzzfarewell/go-microservice-example/pkg/watermark/auth"
+	"github.com/wzzfarewell/go-microservice-example/pkg/watermark/errors"
+	"github.com/wzzfarewell/go-microservice-example/pkg/watermark/logging"
+	"go.opentelemetry.io/otel/trace"
 )
 
-var logger log.Logger
+type watermarkService struct {
+	repo    Repository
+	blobs   BlobStore
+	workers *WorkerPool
+	events  *Hub
+	ready   *Readiness
+	logger  log.Logger
 
-func init() {
-	logger = log.NewLogfmtLogger(log.NewSyncWriter(os.Stderr))
-	logger = log.With(logger, "ts", log.DefaultTimestampUTC)
+	// locks serialize the status check and the move to Started of each
+	// ticket, so a ticket can't be queued twice by concurrent Watermark calls.
+	locks ticketLocks
 }
 
-type watermarkService struct{}
-
-func NewService() Service {
-	return &watermarkService{}
+func NewService(repo Repository, blobs BlobStore, workers *WorkerPool, events *Hub, ready *Readiness, logger log.Logger) Service {
+	return &watermarkService{repo: repo, blobs: blobs, workers: workers, events: events, ready: ready, logger: logger}
 }
 
-func (w *watermarkService) Find(_ context.Context, filters ...internal.Filter) ([]internal.Document, error) {
-	// query the database using the filters and return the list of documents
-	// return error if the filter (key) is invalid and also return error if no item found
-	doc := internal.Document{
-		Content: "book",
-		Title:   "Harry Potter and Half Blood Prince",
-		Author:  "J.K. Rowling",
-		Topic:   "Fiction and Magic",
+// Find seeks to the page in the repository when the documents are returned in
+// ticket ID order. Sorting by other keys lists and sorts every document on
+// each call, whichever page is asked for, so its cost grows with the size of
+// the repository rather than with the size of the page.
+func (w *watermarkService) Find(ctx context.Context, page internal.Page, filters ...internal.Filter) ([]internal.Document, string, error) {
+	matchers, sortKeys, err := parseFilters(filters)
+	if err != nil {
+		return nil, "", err
+	}
+	if len(sortKeys) == 0 {
+		return findInOrder(ctx, w.repo, matchers, page)
+	}
+	docs, err := w.repo.List(ctx)
+	if err != nil {
+		return nil, "", err
 	}
-	return []internal.Document{doc}, nil
+	docs, positions := applyFilters(docs, matchers, sortKeys)
+	return paginate(docs, positions, sortKeys, page)
 }
 
-func (w *watermarkService) Status(_ context.Context, ticketID string) (internal.Status, error) {
-	// query database using the ticketID and return the document info
-	// return err if the ticketID is invalid or no Document exists for that ticketID
-	logger.Log("service", "watermarkService", "ticketID", ticketID, "Checking the status of the ticket...")
-	return internal.InProgress, nil
+func (w *watermarkService) Status(ctx context.Context, ticketID string) (internal.Status, error) {
+	doc, err := w.repo.Get(ctx, ticketID)
+	if err != nil {
+		return "", err
+	}
+	return doc.Status, nil
 }
 
-func (w *watermarkService) Watermark(_ context.Context, ticketID, mark string) (int, error) {
-	// update the database entry with watermark field as non empty
-	// first check if the watermark status is not already in InProgress, Started or Finished state
-	// If yes, then return invalid request
-	// return error if no item found using the ticketID
-	return http.StatusOK, nil
+func (w *watermarkService) Watermark(ctx context.Context, ticketID, mark string) (int, error) {
+	if mark == "" {
+		return http.StatusBadRequest, fmt.Errorf("%w: empty mark", util.ErrInvalidArgument)
+	}
+	defer w.locks.lock(ticketID)()
+	doc, err := w.repo.Get(ctx, ticketID)
+	if err != nil {
+		return errors.HTTPStatus(err), err
+	}
+	doc.RequestID = logging.RequestIDFromContext(ctx)
+	// a ticket that is already Started, InProgress or Finished can't be watermarked again
+	if err := transition(ctx, w.repo, w.events, doc, internal.Started); err != nil {
+		return http.StatusConflict, err
+	}
+	if err := w.workers.Enqueue(Job{TicketID: ticketID, Mark: mark, SpanContext: trace.SpanContextFromContext(ctx)}); err != nil {
+		if err := transition(ctx, w.repo, w.events, doc, internal.Failed); err != nil {
+			level.Error(logging.FromContext(ctx, w.logger)).Log("during", "Failed", "err", err)
+		}
+		return http.StatusServiceUnavailable, err
+	}
+	return http.StatusAccepted, nil
 }
 
-func (w *watermarkService) CreateDocument(_ context.Context, doc *internal.Document) (string, error) {
-	// add the document entry in the database by calling the database service
-	// return error if the doc is invalid and/or the database invalid entry error
-	newTicketID := uuid.NewString()
-	return newTicketID, nil
+// CreateDocument stores the content in the blob store and the document,
+// pointing at it, in the repository.
+func (w *watermarkService) CreateDocument(ctx context.Context, doc *internal.Document, content io.Reader) (string, error) {
+	if doc == nil || content == nil {
+		return "", util.ErrInvalidArgument
+	}
+	if err := w.workers.Accepts(doc.ContentType); err != nil {
+		return "", err
+	}
+	digest, size, err := w.blobs.Put(ctx, content)
+	if err != nil {
+		return "", err
+	}
+	doc.TicketID = uuid.NewString()
+	doc.Status = internal.Pending
+	doc.ContentDigest = digest
+	doc.ContentSize = size
+	doc.Watermark = ""
+	doc.MarkedDigest = ""
+	doc.MarkedSize = 0
+	doc.RequestID = logging.RequestIDFromContext(ctx)
+	doc.CreatedBy = ""
+	if p, ok := auth.PrincipalFromContext(ctx); ok {
+		doc.CreatedBy = p.Subject
+	}
+	// the repository sets the tenant of scoped calls
+	doc.TenantID = ""
+	if err := w.repo.Create(ctx, doc); err != nil {
+		return "", err
+	}
+	w.events.Publish(StatusEvent{TicketID: doc.TicketID, Status: doc.Status})
+	return doc.TicketID, nil
 }
 
 func (w *watermarkService) ServiceStatus(_ context.Context) (int, error) {
-	logger.Log("Checking the Service health...")
+	if !w.ready.Ready() {
+		return http.StatusServiceUnavailable, &errors.Unavailable{Message: "service is not ready"}
+	}
 	return http.StatusOK, nil
 }
+
+// Extract recovers the ticket ID and mark hidden in a copy of a watermarked
+// document. The content type is detected from the content itself.
+func (w *watermarkService) Extract(_ context.Context, content []byte) (string, string, error) {
+	contentType := http.DetectContentType(content)
+	embedder, ok := w.workers.embedders.For(contentType)
+	if !ok {
+		return "", "", fmt.Errorf("%w: can't extract watermarks from %s", util.ErrInvalidArgument, contentType)
+	}
+	var ticketID, mark string
+	payload, err := embedder.Extract(content)
+	if err == nil {
+		ticketID, mark, err = decodePayload(payload)
+	}
+	switch {
+	case err == errNoPayload:
+		return "", "", &errors.NotFound{Err: err}
+	case err != nil:
+		// the content could not even be decoded
+		return "", "", &errors.InvalidArgument{Err: err}
+	}
+	return ticketID, mark, nil
+}
+
+// transition moves doc to the next status, persists it and publishes the
+// change to events, rejecting moves that the status state machine does not
+// allow.
+func transition(ctx context.Context, repo Repository, events *Hub, doc *internal.Document, next internal.Status) error {
+	if err := doc.Status.TransitionTo(next); err != nil {
+		return &errors.Conflict{Err: err}
+	}
+	previous := doc.Status
+	doc.Status = next
+	if err := repo.Update(ctx, doc); err != nil {
+		return err
+	}
+	events.Publish(StatusEvent{TicketID: doc.TicketID, Status: next, Previous: previous})
+	return nil
+}
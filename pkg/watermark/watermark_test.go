@@ -0,0 +1,85 @@
+package watermark
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"testing"
+
+	"github.com/go-kit/log"
+	"github.com/wzzfarewell/go-microservice-example/internal"
+	wmerrors "github.com/wzzfarewell/go-microservice-example/pkg/watermark/errors"
+)
+
+// failingRepository fails every Get with err.
+type failingRepository struct {
+	Repository
+	err error
+}
+
+func (r failingRepository) Get(context.Context, string) (*internal.Document, error) {
+	return nil, r.err
+}
+
+func newTestService(repo Repository) Service {
+	events := NewHub()
+	workers := NewWorkerPool(repo, nil, events, DefaultMarkers(), DefaultEmbedders(), 1, 10, log.NewNopLogger())
+	return NewService(repo, nil, workers, events, new(Readiness), log.NewNopLogger())
+}
+
+func TestWatermarkStatusCodes(t *testing.T) {
+	ctx := context.Background()
+	repo := newMemRepository(internal.Document{TicketID: "t1", Status: internal.Pending})
+	svc := newTestService(repo)
+
+	for _, tc := range []struct {
+		name     string
+		svc      Service
+		ticketID string
+		want     int
+	}{
+		{"queued", svc, "t1", http.StatusAccepted},
+		{"already started", svc, "t1", http.StatusConflict},
+		{"unknown ticket", svc, "t2", http.StatusNotFound},
+		// a broken repository is no missing ticket
+		{"repository failure", newTestService(failingRepository{repo, errors.New("disk on fire")}), "t1", http.StatusInternalServerError},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			code, err := tc.svc.Watermark(ctx, tc.ticketID, "mark")
+			if code != tc.want {
+				t.Fatalf("status %d (%v), want %d", code, err, tc.want)
+			}
+		})
+	}
+	if doc, _ := repo.Get(ctx, "t1"); doc.Status != internal.Started {
+		t.Fatalf("t1 is %s, want %s", doc.Status, internal.Started)
+	}
+}
+
+func TestExtract(t *testing.T) {
+	ctx := context.Background()
+	svc := newTestService(newMemRepository())
+	marked, err := TextEmbedder{}.Embed([]byte("some text\n"), encodePayload("t1", "mark"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	ticketID, mark, err := svc.Extract(ctx, marked)
+	if err != nil || ticketID != "t1" || mark != "mark" {
+		t.Fatalf("got %q, %q, %v", ticketID, mark, err)
+	}
+
+	for _, tc := range []struct {
+		name    string
+		content []byte
+		want    int
+	}{
+		{"no watermark", []byte("some text\n"), http.StatusNotFound},
+		{"no embedder", []byte("%PDF-1.4\n"), http.StatusBadRequest},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			if _, _, err := svc.Extract(ctx, tc.content); wmerrors.HTTPStatus(err) != tc.want {
+				t.Fatalf("got %v, want status %d", err, tc.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,296 @@
+package watermark
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"sync"
+
+	"github.com/go-kit/log"
+	"github.com/go-kit/log/level"
+	"github.com/wzzfarewell/go-microservice-example/internal"
+	"github.com/wzzfarewell/go-microservice-example/internal/util"
+	"github.com/wzzfarewell/go-microservice-example/pkg/watermark/logging"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// Job asks the worker pool to apply Mark to the document behind TicketID.
+type Job struct {
+	TicketID string
+	Mark     string
+
+	// SpanContext belongs to the request that queued the job, processing it
+	// continues the same trace.
+	SpanContext trace.SpanContext
+}
+
+// WorkerPool applies watermarks in the background. Jobs are taken from a
+// bounded queue by a fixed number of workers, and every ticket is moved from
+// Started through InProgress to either Finished or Failed. The Marker for the
+// content type of the document produces the marked content, and if there is
+// an Embedder for it the ticket ID and mark are hidden in it as well.
+type WorkerPool struct {
+	repo        Repository
+	blobs       BlobStore
+	events      *Hub
+	markers     Markers
+	embedders   Embedders
+	concurrency int
+	jobs        chan Job
+	logger      log.Logger
+
+	mtx    sync.RWMutex
+	closed bool
+
+	// done is closed when Run returns, abort when Shutdown gives up on
+	// draining the queue.
+	done      chan struct{}
+	abort     chan struct{}
+	abortOnce sync.Once
+}
+
+func NewWorkerPool(repo Repository, blobs BlobStore, events *Hub, markers Markers, embedders Embedders, concurrency, queueSize int, logger log.Logger) *WorkerPool {
+	if concurrency < 1 {
+		concurrency = 1
+	}
+	return &WorkerPool{
+		repo:        repo,
+		blobs:       blobs,
+		events:      events,
+		markers:     markers,
+		embedders:   embedders,
+		concurrency: concurrency,
+		jobs:        make(chan Job, queueSize),
+		logger:      logger,
+		done:        make(chan struct{}),
+		abort:       make(chan struct{}),
+	}
+}
+
+// Enqueue adds a job to the queue without blocking. It fails with
+// util.ErrQueueFull when the queue is at capacity and with
+// util.ErrQueueClosed once Shutdown has been called.
+func (p *WorkerPool) Enqueue(job Job) error {
+	p.mtx.RLock()
+	defer p.mtx.RUnlock()
+	if p.closed {
+		return util.ErrQueueClosed
+	}
+	select {
+	case p.jobs <- job:
+		return nil
+	default:
+		return util.ErrQueueFull
+	}
+}
+
+// Run starts the workers and blocks until Shutdown has been called and every
+// job that was already queued has been processed, or Shutdown gave up on
+// waiting for them.
+func (p *WorkerPool) Run() error {
+	defer close(p.done)
+	var wg sync.WaitGroup
+	for i := 0; i < p.concurrency; i++ {
+		wg.Add(1)
+		go func(worker int) {
+			defer wg.Done()
+			for job := range p.jobs {
+				select {
+				case <-p.abort:
+					p.abandon(job)
+					continue
+				default:
+				}
+				p.process(worker, job)
+			}
+		}(i)
+	}
+	drained := make(chan struct{})
+	go func() {
+		wg.Wait()
+		close(drained)
+	}()
+	select {
+	case <-drained:
+	case <-p.abort:
+	}
+	return nil
+}
+
+// Recover fails the tickets left Started or InProgress by a previous run
+// that crashed or gave up on its jobs, so they can be watermarked again. The
+// jobs themselves are lost with the queue, the marks included, so they can't
+// be run again. It must be called before Run and returns the number of
+// tickets failed.
+func (p *WorkerPool) Recover(ctx context.Context) (int, error) {
+	var stuck []string
+	err := p.repo.Walk(ctx, func(doc internal.Document) error {
+		if doc.Status == internal.Started || doc.Status == internal.InProgress {
+			stuck = append(stuck, doc.TicketID)
+		}
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+	for i, ticketID := range stuck {
+		doc, err := p.repo.Get(ctx, ticketID)
+		if err != nil {
+			return i, err
+		}
+		ctx := logging.ContextWithTicketID(logging.ContextWithRequestID(ctx, doc.RequestID), ticketID)
+		p.fail(ctx, logging.FromContext(ctx, p.logger), doc, errors.New("interrupted by a restart"))
+		level.Warn(logging.FromContext(ctx, p.logger)).Log("msg", "failed interrupted job", "status", doc.Status)
+	}
+	return len(stuck), nil
+}
+
+// Accepts returns an error if the pool has no Marker for contentType.
+func (p *WorkerPool) Accepts(contentType string) error {
+	_, err := p.markers.For(contentType)
+	return err
+}
+
+// QueueDepth returns the number of jobs waiting for a worker.
+func (p *WorkerPool) QueueDepth() int {
+	return len(p.jobs)
+}
+
+// CheckHealth fails when the queue can't take a new job.
+func (p *WorkerPool) CheckHealth(_ context.Context) error {
+	p.mtx.RLock()
+	defer p.mtx.RUnlock()
+	switch {
+	case p.closed:
+		return util.ErrQueueClosed
+	case len(p.jobs) == cap(p.jobs):
+		return util.ErrQueueFull
+	}
+	return nil
+}
+
+// Shutdown stops accepting new jobs and waits until Run has drained the
+// queue. If ctx is done first, the jobs still queued are failed and Run
+// returns without waiting for the ones in progress.
+func (p *WorkerPool) Shutdown(ctx context.Context) error {
+	p.mtx.Lock()
+	if !p.closed {
+		p.closed = true
+		close(p.jobs)
+	}
+	p.mtx.Unlock()
+
+	select {
+	case <-p.done:
+		return nil
+	case <-ctx.Done():
+	}
+	p.abortOnce.Do(func() { close(p.abort) })
+	for job := range p.jobs {
+		p.abandon(job)
+	}
+	return ctx.Err()
+}
+
+// abandon fails a queued job that won't be processed, so its ticket can be
+// watermarked again.
+func (p *WorkerPool) abandon(job Job) {
+	ctx, span := tracer.Start(trace.ContextWithSpanContext(context.Background(), job.SpanContext), "WorkerPool.abandon",
+		trace.WithAttributes(attribute.String("ticket_id", job.TicketID)))
+	defer span.End()
+	ctx = logging.ContextWithTicketID(ctx, job.TicketID)
+	logger := logging.FromContext(ctx, p.logger)
+	doc, err := p.repo.Get(ctx, job.TicketID)
+	if err != nil {
+		level.Error(logger).Log("during", "Abandon", "err", err)
+		return
+	}
+	ctx = logging.ContextWithRequestID(ctx, doc.RequestID)
+	logger = logging.FromContext(ctx, p.logger)
+	if err := transition(ctx, p.repo, p.events, doc, internal.Failed); err != nil {
+		level.Error(logger).Log("during", "Abandon", "err", err)
+		return
+	}
+	level.Warn(logger).Log("msg", "abandoned queued job", "status", doc.Status)
+}
+
+func (p *WorkerPool) process(worker int, job Job) {
+	ctx, span := tracer.Start(trace.ContextWithSpanContext(context.Background(), job.SpanContext), "WorkerPool.process",
+		trace.WithAttributes(attribute.String("ticket_id", job.TicketID), attribute.Int("worker", worker)))
+	defer span.End()
+	ctx = logging.ContextWithTicketID(ctx, job.TicketID)
+	logger := log.With(logging.FromContext(ctx, p.logger), "worker", worker)
+	doc, err := p.repo.Get(ctx, job.TicketID)
+	if err != nil {
+		level.Error(logger).Log("during", "Get", "err", err)
+		recordError(span, err)
+		return
+	}
+	// log with the ID of the request that started the job
+	ctx = logging.ContextWithRequestID(ctx, doc.RequestID)
+	logger = log.With(logging.FromContext(ctx, p.logger), "worker", worker)
+	if err := transition(ctx, p.repo, p.events, doc, internal.InProgress); err != nil {
+		level.Error(logger).Log("during", "InProgress", "err", err)
+		p.fail(ctx, logger, doc, err)
+		return
+	}
+	marker, err := p.markers.For(doc.ContentType)
+	if err != nil {
+		level.Error(logger).Log("during", "Marker", "err", err)
+		p.fail(ctx, logger, doc, err)
+		return
+	}
+	content, err := readBlob(ctx, p.blobs, doc.ContentDigest)
+	if err != nil {
+		level.Error(logger).Log("during", "Read", "err", err)
+		p.fail(ctx, logger, doc, err)
+		return
+	}
+	_, markSpan := tracer.Start(ctx, "Marker.Mark", trace.WithAttributes(attribute.String("content_type", doc.ContentType)))
+	marked, err := marker.Mark(content, job.Mark)
+	endSpan(markSpan, err)
+	if err != nil {
+		level.Error(logger).Log("during", "Mark", "err", err)
+		p.fail(ctx, logger, doc, err)
+		return
+	}
+	// the invisible watermark goes in last so the visible one can't damage it
+	if embedder, ok := p.embedders.For(doc.ContentType); ok {
+		_, embedSpan := tracer.Start(ctx, "Embedder.Embed")
+		marked, err = embedder.Embed(marked, encodePayload(doc.TicketID, job.Mark))
+		endSpan(embedSpan, err)
+		if err != nil {
+			level.Error(logger).Log("during", "Embed", "err", err)
+			p.fail(ctx, logger, doc, err)
+			return
+		}
+	}
+	digest, size, err := p.blobs.Put(ctx, bytes.NewReader(marked))
+	if err != nil {
+		level.Error(logger).Log("during", "Put", "err", err)
+		p.fail(ctx, logger, doc, err)
+		return
+	}
+	doc.Watermark = job.Mark
+	doc.MarkedDigest = digest
+	doc.MarkedSize = size
+	if err := transition(ctx, p.repo, p.events, doc, internal.Finished); err != nil {
+		level.Error(logger).Log("during", "Finished", "err", err)
+		p.fail(ctx, logger, doc, err)
+		return
+	}
+	level.Info(logger).Log("status", doc.Status)
+}
+
+// fail moves the ticket to Failed and records err, the reason, on the span
+// of the job.
+func (p *WorkerPool) fail(ctx context.Context, logger log.Logger, doc *internal.Document, err error) {
+	recordError(trace.SpanFromContext(ctx), err)
+	doc.Watermark = ""
+	doc.MarkedDigest = ""
+	doc.MarkedSize = 0
+	if err := transition(ctx, p.repo, p.events, doc, internal.Failed); err != nil {
+		level.Error(logger).Log("during", "Failed", "err", err)
+	}
+}
@@ -0,0 +1,295 @@
+package watermark
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"io"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/go-kit/log"
+	"github.com/wzzfarewell/go-microservice-example/internal"
+	"github.com/wzzfarewell/go-microservice-example/internal/util"
+)
+
+// memBlobStore is a minimal BlobStore, the inmem one can't be imported from
+// here.
+type memBlobStore struct {
+	mtx   sync.Mutex
+	blobs map[string][]byte
+}
+
+func newMemBlobStore() *memBlobStore {
+	return &memBlobStore{blobs: make(map[string][]byte)}
+}
+
+func (s *memBlobStore) Put(_ context.Context, r io.Reader) (string, int64, error) {
+	content, err := io.ReadAll(r)
+	if err != nil {
+		return "", 0, err
+	}
+	sum := sha256.Sum256(content)
+	digest := hex.EncodeToString(sum[:])
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+	s.blobs[digest] = content
+	return digest, int64(len(content)), nil
+}
+
+func (s *memBlobStore) Get(_ context.Context, digest string) (io.ReadCloser, int64, error) {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+	content, ok := s.blobs[digest]
+	if !ok {
+		return nil, 0, util.ErrBlobNotFound
+	}
+	return io.NopCloser(bytes.NewReader(content)), int64(len(content)), nil
+}
+
+func (s *memBlobStore) Delete(_ context.Context, digest string) error {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+	delete(s.blobs, digest)
+	return nil
+}
+
+// blockingMarker waits for release before marking.
+type blockingMarker struct {
+	started chan struct{}
+	release chan struct{}
+}
+
+func (m blockingMarker) Mark(content []byte, mark string) ([]byte, error) {
+	m.started <- struct{}{}
+	<-m.release
+	return TextMarker{}.Mark(content, mark)
+}
+
+type workerFixture struct {
+	repo   *memRepository
+	blobs  *memBlobStore
+	events *Hub
+	pool   *WorkerPool
+
+	mtx         sync.Mutex
+	transitions map[string][]internal.Status
+}
+
+func newWorkerFixture(t *testing.T, markers Markers, queueSize int) *workerFixture {
+	t.Helper()
+	f := &workerFixture{
+		repo:        newMemRepository(),
+		blobs:       newMemBlobStore(),
+		events:      NewHub(),
+		transitions: make(map[string][]internal.Status),
+	}
+	f.events.Observe(func(e StatusEvent) {
+		f.mtx.Lock()
+		defer f.mtx.Unlock()
+		f.transitions[e.TicketID] = append(f.transitions[e.TicketID], e.Status)
+	})
+	f.pool = NewWorkerPool(f.repo, f.blobs, f.events, markers, DefaultEmbedders(), 1, queueSize, log.NewNopLogger())
+	return f
+}
+
+// submit stores a text document and moves it to Started, like Watermark
+// does, and queues its job.
+func (f *workerFixture) submit(t *testing.T, ticketID, content string) error {
+	t.Helper()
+	digest, size, err := f.blobs.Put(context.Background(), bytes.NewReader([]byte(content)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	doc := &internal.Document{TicketID: ticketID, Status: internal.Pending, ContentType: ContentTypeText, ContentDigest: digest, ContentSize: size}
+	if err := f.repo.Create(context.Background(), doc); err != nil {
+		t.Fatal(err)
+	}
+	if err := transition(context.Background(), f.repo, f.events, doc, internal.Started); err != nil {
+		t.Fatal(err)
+	}
+	return f.pool.Enqueue(Job{TicketID: ticketID, Mark: "mark"})
+}
+
+func (f *workerFixture) doc(t *testing.T, ticketID string) *internal.Document {
+	t.Helper()
+	doc, err := f.repo.Get(context.Background(), ticketID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return doc
+}
+
+func (f *workerFixture) run(t *testing.T) chan struct{} {
+	done := make(chan struct{})
+	go func() {
+		f.pool.Run()
+		close(done)
+	}()
+	return done
+}
+
+func TestEnqueue(t *testing.T) {
+	f := newWorkerFixture(t, DefaultMarkers(), 1)
+	if err := f.submit(t, "t1", "text"); err != nil {
+		t.Fatal(err)
+	}
+	if err := f.submit(t, "t2", "text"); err != util.ErrQueueFull {
+		t.Fatalf("got %v, want %v", err, util.ErrQueueFull)
+	}
+	if err := f.pool.CheckHealth(context.Background()); err != util.ErrQueueFull {
+		t.Fatalf("health %v, want %v", err, util.ErrQueueFull)
+	}
+
+	done := f.run(t)
+	if err := f.pool.Shutdown(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	<-done
+	if err := f.pool.Enqueue(Job{TicketID: "t3"}); err != util.ErrQueueClosed {
+		t.Fatalf("got %v, want %v", err, util.ErrQueueClosed)
+	}
+}
+
+func TestWorkerTransitions(t *testing.T) {
+	f := newWorkerFixture(t, DefaultMarkers(), 10)
+	if err := f.submit(t, "ok", "text"); err != nil {
+		t.Fatal(err)
+	}
+	// a document without content fails
+	if err := f.repo.Create(context.Background(), &internal.Document{TicketID: "broken", Status: internal.Started, ContentType: ContentTypeText, ContentDigest: "missing"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := f.pool.Enqueue(Job{TicketID: "broken", Mark: "mark"}); err != nil {
+		t.Fatal(err)
+	}
+
+	done := f.run(t)
+	if err := f.pool.Shutdown(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	<-done
+
+	ok := f.doc(t, "ok")
+	if ok.Status != internal.Finished || ok.Watermark != "mark" || ok.MarkedDigest == "" {
+		t.Fatalf("ok is %+v", ok)
+	}
+	marked, err := readBlob(context.Background(), f.blobs, ok.MarkedDigest)
+	if err != nil {
+		t.Fatal(err)
+	}
+	payload, err := TextEmbedder{}.Extract(marked)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ticketID, mark, err := decodePayload(payload); err != nil || ticketID != "ok" || mark != "mark" {
+		t.Fatalf("embedded %q, %q, %v", ticketID, mark, err)
+	}
+	broken := f.doc(t, "broken")
+	if broken.Status != internal.Failed || broken.Watermark != "" || broken.MarkedDigest != "" {
+		t.Fatalf("broken is %+v", broken)
+	}
+
+	wantTransitions := map[string][]internal.Status{
+		"ok":     {internal.Started, internal.InProgress, internal.Finished},
+		"broken": {internal.InProgress, internal.Failed},
+	}
+	if !reflect.DeepEqual(f.transitions, wantTransitions) {
+		t.Fatalf("transitions %v, want %v", f.transitions, wantTransitions)
+	}
+}
+
+func TestShutdownDrainsQueue(t *testing.T) {
+	f := newWorkerFixture(t, DefaultMarkers(), 10)
+	tickets := []string{"t1", "t2", "t3", "t4", "t5"}
+	for _, ticketID := range tickets {
+		if err := f.submit(t, ticketID, "text"); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// shut down before the workers even started
+	shutdown := make(chan error)
+	go func() { shutdown <- f.pool.Shutdown(context.Background()) }()
+	done := f.run(t)
+	if err := <-shutdown; err != nil {
+		t.Fatal(err)
+	}
+	<-done
+	for _, ticketID := range tickets {
+		if doc := f.doc(t, ticketID); doc.Status != internal.Finished {
+			t.Fatalf("%s is %s, want %s", ticketID, doc.Status, internal.Finished)
+		}
+	}
+}
+
+func TestShutdownTimeoutFailsQueuedJobs(t *testing.T) {
+	marker := blockingMarker{started: make(chan struct{}), release: make(chan struct{})}
+	f := newWorkerFixture(t, Markers{ContentTypeText: marker}, 10)
+	for _, ticketID := range []string{"busy", "queued1", "queued2"} {
+		if err := f.submit(t, ticketID, "text"); err != nil {
+			t.Fatal(err)
+		}
+	}
+	done := f.run(t)
+	<-marker.started
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	if err := f.pool.Shutdown(ctx); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("got %v, want %v", err, context.DeadlineExceeded)
+	}
+	// Run gives up on the job in progress
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("Run still waits for the job in progress")
+	}
+	for _, ticketID := range []string{"queued1", "queued2"} {
+		if doc := f.doc(t, ticketID); doc.Status != internal.Failed {
+			t.Fatalf("%s is %s, want %s", ticketID, doc.Status, internal.Failed)
+		}
+	}
+	if doc := f.doc(t, "busy"); doc.Status != internal.InProgress {
+		t.Fatalf("busy is %s, want %s", doc.Status, internal.InProgress)
+	}
+	close(marker.release)
+}
+
+func TestRecover(t *testing.T) {
+	f := newWorkerFixture(t, DefaultMarkers(), 10)
+	statuses := map[string]internal.Status{
+		"pending":     internal.Pending,
+		"started":     internal.Started,
+		"in progress": internal.InProgress,
+		"finished":    internal.Finished,
+		"failed":      internal.Failed,
+	}
+	for ticketID, status := range statuses {
+		if err := f.repo.Create(context.Background(), &internal.Document{TicketID: ticketID, Status: status}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	n, err := f.pool.Recover(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != 2 {
+		t.Fatalf("failed %d tickets, want 2", n)
+	}
+	statuses["started"], statuses["in progress"] = internal.Failed, internal.Failed
+	for ticketID, want := range statuses {
+		if doc := f.doc(t, ticketID); doc.Status != want {
+			t.Errorf("%s is %s, want %s", ticketID, doc.Status, want)
+		}
+	}
+
+	// and can be watermarked again
+	svc := NewService(f.repo, f.blobs, f.pool, f.events, new(Readiness), log.NewNopLogger())
+	if _, err := svc.Watermark(context.Background(), "started", "mark"); err != nil {
+		t.Fatal(err)
+	}
+}